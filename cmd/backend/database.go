@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/postgres"
+	"github.com/jfernstad/habitz/web/internal/sqlite"
+)
+
+// database is the SQLite or Postgres backend, the rest of the server only sees the service
+type database struct {
+	db         *sqlx.DB
+	newService func(db *sqlx.DB, debug bool) internal.HabitzServicer
+
+	schemaVersion func(db *sqlx.DB) (int, error)
+	pending       func(db *sqlx.DB) ([]migration, error)
+	migrate       func(db *sqlx.DB) ([]migration, error)
+}
+
+// migration is the part of a backends migration the -migrations flag prints
+type migration struct {
+	version     int
+	description string
+}
+
+// openDatabase connects to Postgres when databaseURL is set, SQLite otherwise
+func openDatabase(databaseURL, sqliteFile string) (*database, error) {
+	if databaseURL != "" {
+		db, err := sqlx.Open("postgres", databaseURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return &database{
+			db:            db,
+			newService:    postgres.NewHabitzService,
+			schemaVersion: postgres.SchemaVersion,
+			pending: func(db *sqlx.DB) ([]migration, error) {
+				return postgresMigrations(postgres.PendingMigrations(db))
+			},
+			migrate: func(db *sqlx.DB) ([]migration, error) {
+				return postgresMigrations(postgres.Migrate(db))
+			},
+		}, nil
+	}
+
+	// SQLite only enforces foreign keys when asked to, on every connection
+	db, err := sqlx.Open("sqlite3", sqliteFile+"?_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+
+	return &database{
+		db:            db,
+		newService:    sqlite.NewHabitzService,
+		schemaVersion: sqlite.SchemaVersion,
+		pending: func(db *sqlx.DB) ([]migration, error) {
+			return sqliteMigrations(sqlite.PendingMigrations(db))
+		},
+		migrate: func(db *sqlx.DB) ([]migration, error) {
+			return sqliteMigrations(sqlite.Migrate(db))
+		},
+	}, nil
+}
+
+func (d *database) Service(debug bool) internal.HabitzServicer {
+	return d.newService(d.db, debug)
+}
+
+func (d *database) Close() error {
+	return d.db.Close()
+}
+
+// Migrations prints the schema version and pending migrations, and applies them if apply is set
+func (d *database) Migrations(apply bool) error {
+	version, err := d.schemaVersion(d.db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version: %d\n", version)
+
+	pending, err := d.pending(d.db)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		fmt.Println("No pending migrations")
+		return nil
+	}
+
+	for _, mig := range pending {
+		fmt.Printf("Pending: %d \t%s\n", mig.version, mig.description)
+	}
+
+	if !apply {
+		return nil
+	}
+
+	applied, err := d.migrate(d.db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d migrations\n", len(applied))
+	return nil
+}
+
+func sqliteMigrations(migs []sqlite.Migration, err error) ([]migration, error) {
+	list := []migration{}
+	for _, mig := range migs {
+		list = append(list, migration{mig.Version, mig.Description})
+	}
+	return list, err
+}
+
+func postgresMigrations(migs []postgres.Migration, err error) ([]migration, error) {
+	list := []migration{}
+	for _, mig := range migs {
+		list = append(list, migration{mig.Version, mig.Description})
+	}
+	return list, err
+}
@@ -1,11 +1,14 @@
 package endpoints
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"net"
 	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -19,29 +22,36 @@ type authEndpoint struct {
 	DefaultEndpoint
 	service           internal.HabitzServicer
 	jwtSigningService auth.JWTServicer
-	jwtGoogleAudience string
-	cachedGoogleCerts map[string]string
+	providers         map[string]auth.IdentityProvider
+
+	// Failed password logins, per email and per client address
+	emailLimiter *auth.LoginLimiter
+	ipLimiter    *auth.LoginLimiter
+	proxies      TrustedProxies
 }
 
 const (
-	AuthProviderGoogle = "google"
+	loginWindow          = 15 * time.Minute
+	maxFailuresPerEmail  = 5
+	maxFailuresPerClient = 20
 )
 
-type googleClaims struct {
-	jwt.StandardClaims
-	Email         string `json:"email"`
-	EmailVerified bool   `json:"email_verified"`
-	FirstName     string `json:"given_name"`
-	LastName      string `json:"family_name"`
-	ProfileImage  string `json:"picture"`
-}
+// NewAuthEndpoint handles logins. Behind a reverse proxy the client address is read from
+// the X-Forwarded-For or X-Real-IP headers set by `proxies`, without proxies it's the
+// address of the connection.
+func NewAuthEndpoint(hs internal.HabitzServicer, jwtService auth.JWTServicer, providers []auth.IdentityProvider, proxies TrustedProxies) EndpointRouter {
+	byName := map[string]auth.IdentityProvider{}
+	for _, p := range providers {
+		byName[p.Name()] = p
+	}
 
-func NewAuthEndpoint(hs internal.HabitzServicer, jwtService auth.JWTServicer, jwtGoogleAudience string) EndpointRouter {
 	return &authEndpoint{
 		service:           hs,
 		jwtSigningService: jwtService,
-		jwtGoogleAudience: jwtGoogleAudience,   // Verify the incoming JWT token was intended for us
-		cachedGoogleCerts: map[string]string{}, // Optimization
+		providers:         byName,
+		emailLimiter:      auth.NewLoginLimiter(maxFailuresPerEmail, loginWindow),
+		ipLimiter:         auth.NewLoginLimiter(maxFailuresPerClient, loginWindow),
+		proxies:           proxies,
 	}
 }
 
@@ -49,156 +59,429 @@ func (a *authEndpoint) Routes() chi.Router {
 	router := NewRouter()
 
 	router.Route("/", func(r chi.Router) {
-		r.Post("/google", ErrorHandler(a.google))
+		r.Get("/.well-known/jwks.json", ErrorHandler(a.jwks))
+		r.Post("/{provider}", ErrorHandler(a.login))
+		r.Post("/register", ErrorHandler(a.register))
+		r.Post("/login", ErrorHandler(a.passwordLogin))
+		r.Post("/refresh", ErrorHandler(a.refresh))
+		r.Post("/logout", ErrorHandler(a.logout))
+		r.With(JWTValidation(a.jwtSigningService, a.service)).Post("/logout/all", ErrorHandler(a.logoutAll))
 	})
 
 	return router
 }
 
-func (a *authEndpoint) google(w http.ResponseWriter, r *http.Request) error {
-	// User fetched a JQT token from Google
-	// Lets transform it to our JWT token
+// login exchanges a credential from an identity provider, e.g. a Google ID token, for our tokens
+func (a *authEndpoint) login(w http.ResponseWriter, r *http.Request) error {
 	defer r.Body.Close()
 
-	type token struct {
-		Token string `json:"token"`
+	provider, ok := a.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		return newNotFoundErr("unknown auth provider")
 	}
 
-	loginToken := token{}
+	// Clients can tell us their timezone at login
+	loginToken := struct {
+		Token    string `json:"token"`
+		Timezone string `json:"timezone,omitempty"`
+	}{}
 
 	err := json.NewDecoder(r.Body).Decode(&loginToken)
 	if err != nil {
-		return newBadRequestErr("not a valid Google JWT").Wrap(err)
+		return newBadRequestErr("invalid input").Wrap(err)
 	}
 
-	gToken, err := a.parseGoogleJWTToken(loginToken.Token)
+	if loginToken.Timezone != "" && !validTimezone(loginToken.Timezone) {
+		return newBadRequestErr("invalid timezone")
+	}
+
+	identity, err := provider.Authenticate(loginToken.Token)
 	if err != nil {
-		return newBadRequestErr("could not validate Google JWT").Wrap(err)
+		return newBadRequestErr("could not validate " + provider.Name() + " token").Wrap(err)
 	}
 
 	// Is this the first time the user logs in?
-	user, err := a.service.UserWithExternalID(gToken.Subject, AuthProviderGoogle)
+	user, err := a.service.UserWithExternalID(r.Context(), identity.ExternalID, identity.Provider)
 	if err != nil {
 		return newInternalServerErr("could not fetch user").Wrap(err)
 	}
 
 	// If so, create an account, store basic info
 	if user == nil {
-		ext := repository.ExternalUser{
-			User: repository.User{
-				Firstname:       gToken.FirstName,
-				Lastname:        gToken.LastName,
-				Email:           gToken.Email,
-				ProfileImageURL: gToken.ProfileImage,
-			},
-			Provider:   AuthProviderGoogle,
-			ExternalID: gToken.Subject,
-		}
+		identity.Timezone = loginToken.Timezone
 
 		// Lets create the user properly
-		user, err = a.service.CreateExternalUser(&ext)
+		user, err = a.service.CreateExternalUser(r.Context(), identity)
 		if err != nil {
 			return newInternalServerErr("could not create user").Wrap(err)
 		}
+	} else if loginToken.Timezone != "" && loginToken.Timezone != user.Timezone {
+		if err := a.service.SetUserTimezone(r.Context(), user.ID, loginToken.Timezone); err != nil {
+			return newInternalServerErr("could not update timezone").Wrap(err)
+		}
 	}
 
-	// Our Habitz claims
-	claims := &auth.HabitzJWTClaims{
-		Firstname: gToken.FirstName,
-		StandardClaims: jwt.StandardClaims{
-			Subject: user.ID,
-		},
+	resp, err := a.startSession(r.Context(), user)
+	if err != nil {
+		return err
 	}
 
-	expirationTime := time.Now().Add(30 * 24 * time.Hour) // Generous 30 days expiration
+	writeJSON(w, http.StatusOK, resp)
 
-	// Create a JWT token for the API
-	tokenString, err := a.jwtSigningService.NewToken(claims, &expirationTime)
+	return nil
+}
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// register creates an account that logs in with email and password
+func (a *authEndpoint) register(w http.ResponseWriter, r *http.Request) error {
+	input := struct {
+		credentials
+		Firstname string `json:"firstname"`
+		Lastname  string `json:"lastname"`
+		Timezone  string `json:"timezone,omitempty"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
+
+	email, err := mail.ParseAddress(strings.TrimSpace(input.Email))
+	if err != nil || email.Name != "" {
+		return newBadRequestErr("invalid email")
+	}
+
+	if input.Timezone != "" && !validTimezone(input.Timezone) {
+		return newBadRequestErr("invalid timezone")
+	}
+
+	hash, err := auth.HashPassword(input.Password)
+	if err == auth.ErrWeakPassword {
+		return newBadRequestErr(err.Error())
+	}
 	if err != nil {
-		// If there is an error in creating the JWT return an internal server error
-		return newInternalServerErr("could not sign habitz JWT").Wrap(err)
+		return newInternalServerErr("could not hash password").Wrap(err)
 	}
 
-	resp := token{
-		Token: tokenString,
+	user, err := a.service.CreateLocalUser(r.Context(), &repository.User{
+		Email:     email.Address,
+		Firstname: strings.TrimSpace(input.Firstname),
+		Lastname:  strings.TrimSpace(input.Lastname),
+		Timezone:  input.Timezone,
+	}, hash)
+	if err == internal.ErrAlreadyExists {
+		return newConflictErr("an account with that email already exists")
+	}
+	if err != nil {
+		return newInternalServerErr("could not create user").Wrap(err)
 	}
 
-	writeJSON(w, http.StatusOK, resp)
+	resp, err := a.startSession(r.Context(), user)
+	if err != nil {
+		return err
+	}
 
+	writeJSON(w, http.StatusCreated, resp)
 	return nil
 }
 
-func (a *authEndpoint) parseGoogleJWTToken(tokenString string) (*googleClaims, error) {
-	claimsStruct := googleClaims{}
+// passwordLogin logs in with email and password.
+// Repeated failures block the email, and the client address, for a while.
+func (a *authEndpoint) passwordLogin(w http.ResponseWriter, r *http.Request) error {
+	input := credentials{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
 
-	token, err := jwt.ParseWithClaims(
-		tokenString,
-		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) {
-			pem, err := a.getGooglePublicKey(fmt.Sprintf("%s", token.Header["kid"]))
-			if err != nil {
-				return nil, err
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+	client := a.proxies.ClientAddress(r)
+
+	for _, limit := range []struct {
+		limiter *auth.LoginLimiter
+		key     string
+	}{{a.emailLimiter, email}, {a.ipLimiter, client}} {
+		if ok, retryAfter := limit.limiter.Allowed(limit.key); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			return newTooManyRequestsErr("too many failed logins, try again later")
+		}
+	}
+
+	creds, err := a.service.LocalCredentials(r.Context(), email)
+	if err != nil {
+		return newInternalServerErr("could not load credentials").Wrap(err)
+	}
+
+	hash := ""
+	if creds != nil {
+		hash = creds.PasswordHash
+	}
+
+	// Same answer for unknown emails and wrong passwords
+	if !auth.CheckPassword(hash, input.Password) {
+		a.emailLimiter.Failure(email)
+		a.ipLimiter.Failure(client)
+		return newNotAuthenticatedErr("invalid email or password")
+	}
+	a.emailLimiter.Reset(email)
+
+	user, err := a.service.User(r.Context(), creds.UserID)
+	if err != nil {
+		return newInternalServerErr("could not fetch user").Wrap(err)
+	}
+	if user == nil {
+		return newNotAuthenticatedErr("invalid email or password")
+	}
+
+	resp, err := a.startSession(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+	return nil
+}
+
+// TrustedProxies are the reverse proxies allowed to tell the address of the client
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies reads a comma separated list of addresses and networks,
+// e.g. "127.0.0.1, 10.0.0.0/8"
+func ParseTrustedProxies(s string) (TrustedProxies, error) {
+	proxies := TrustedProxies{}
+	for _, proxy := range strings.Split(s, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", proxy)
 			}
-			key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pem))
-			if err != nil {
-				return nil, err
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
 			}
-			return key, nil
-		},
-	)
+			bits := 8 * len(ip)
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %q", proxy)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddress is the IP address of the client, without the port.
+// Requests from a trusted proxy use the last address in X-Forwarded-For that isn't
+// a trusted proxy, or X-Real-IP. Those headers are ignored from anyone else,
+// since clients can set them to anything.
+func (p TrustedProxies) ClientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return &googleClaims{}, err
+		host = r.RemoteAddr
+	}
+	if !p.trusted(host) {
+		return host
 	}
 
-	claims, ok := token.Claims.(*googleClaims)
-	if !ok {
-		return &googleClaims{}, errors.New("Invalid Google JWT")
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		addresses := strings.Split(forwarded, ",")
+		for i := len(addresses) - 1; i >= 0; i-- {
+			address := strings.TrimSpace(addresses[i])
+			if net.ParseIP(address) == nil {
+				break
+			}
+			host = address
+			if !p.trusted(address) {
+				break
+			}
+		}
+		return host
 	}
 
-	if claims.Issuer != "accounts.google.com" && claims.Issuer != "https://accounts.google.com" {
-		return &googleClaims{}, errors.New("iss is invalid")
+	if address := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(address) != nil {
+		return address
 	}
+	return host
+}
 
-	// TODO: Pass Google App ClientID into Auth service
-	if claims.Audience != a.jwtGoogleAudience {
-		return &googleClaims{}, errors.New("aud is invalid")
+// jwks publishes the public keys, so other services can verify Habitz tokens
+func (a *authEndpoint) jwks(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, a.jwtSigningService.JWKS())
+	return nil
+}
+
+// tokenResponse is returned at login and when the access token is refreshed
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds until the access token expires
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// startSession creates a login session for the user, one per device
+func (a *authEndpoint) startSession(ctx context.Context, user *repository.User) (*tokenResponse, error) {
+	sessionID, err := auth.NewSessionID()
+	if err != nil {
+		return nil, newInternalServerErr("could not create session").Wrap(err)
 	}
 
-	if claims.ExpiresAt < time.Now().UTC().Unix() {
-		return &googleClaims{}, errors.New("JWT is expired")
+	refreshToken, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, newInternalServerErr("could not create refresh token").Wrap(err)
+	}
+
+	now := time.Now()
+	session := &repository.Session{
+		ID:               sessionID,
+		UserID:           user.ID,
+		RefreshTokenHash: hash,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(auth.RefreshTokenDuration),
+	}
+	if err := a.service.CreateSession(ctx, session); err != nil {
+		return nil, newInternalServerErr("could not create session").Wrap(err)
+	}
+
+	return a.issueTokens(user, sessionID, refreshToken)
+}
+
+// issueTokens signs a short lived access token for the session
+func (a *authEndpoint) issueTokens(user *repository.User, sessionID, refreshToken string) (*tokenResponse, error) {
+	// Our Habitz claims
+	claims := &auth.HabitzJWTClaims{
+		Firstname: user.Firstname,
+		StandardClaims: jwt.StandardClaims{
+			Id:      sessionID,
+			Subject: user.ID,
+		},
+	}
+
+	expirationTime := time.Now().Add(auth.AccessTokenDuration)
+
+	// Create a JWT token for the API
+	tokenString, err := a.jwtSigningService.NewToken(claims, &expirationTime)
+	if err != nil {
+		// If there is an error in creating the JWT return an internal server error
+		return nil, newInternalServerErr("could not sign habitz JWT").Wrap(err)
 	}
 
-	return claims, nil
+	return &tokenResponse{
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(auth.AccessTokenDuration.Seconds()),
+	}, nil
 }
 
-// From: https://blog.boot.dev/golang/how-to-implement-sign-in-with-google-in-golang/
-func (a *authEndpoint) getGooglePublicKey(keyID string) (string, error) {
+// refresh exchanges a refresh token for a new access token.
+// The refresh token is rotated, clients must use the new one next time.
+func (a *authEndpoint) refresh(w http.ResponseWriter, r *http.Request) error {
+	input := refreshRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
+	if input.RefreshToken == "" {
+		return newMissingParameterErr("refresh_token is required")
+	}
 
-	// Check cache first
-	if key, ok := a.cachedGoogleCerts[keyID]; ok {
-		return key, nil
+	hash := auth.HashRefreshToken(input.RefreshToken)
+	session, err := a.service.SessionWithRefreshToken(r.Context(), hash)
+	if err != nil {
+		return newInternalServerErr("could not load session").Wrap(err)
+	}
+	if session == nil || session.RevokedAt != nil || session.ExpiresAt.Before(time.Now()) {
+		return newNotAuthenticatedErr("invalid refresh token")
 	}
 
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v1/certs")
+	user, err := a.service.User(r.Context(), session.UserID)
 	if err != nil {
-		return "", err
+		return newInternalServerErr("could not fetch user").Wrap(err)
+	}
+	if user == nil {
+		return newNotAuthenticatedErr("invalid refresh token")
 	}
-	dat, err := ioutil.ReadAll(resp.Body)
+
+	refreshToken, newHash, err := auth.NewRefreshToken()
 	if err != nil {
-		return "", err
+		return newInternalServerErr("could not create refresh token").Wrap(err)
 	}
 
-	myResp := map[string]string{}
-	err = json.Unmarshal(dat, &myResp)
+	err = a.service.RotateSession(r.Context(), session.ID, hash, newHash, time.Now().Add(auth.RefreshTokenDuration))
+	if err == internal.ErrNotFound {
+		return newNotAuthenticatedErr("invalid refresh token")
+	}
 	if err != nil {
-		return "", err
+		return newInternalServerErr("could not refresh session").Wrap(err)
 	}
-	key, ok := myResp[keyID]
-	if !ok {
-		return "", errors.New("key not found")
+
+	resp, err := a.issueTokens(user, session.ID, refreshToken)
+	if err != nil {
+		return err
 	}
 
-	// Cache key
-	a.cachedGoogleCerts[keyID] = key
-	return key, nil
+	writeJSON(w, http.StatusOK, resp)
+	return nil
+}
+
+// logout revokes the session of a refresh token, signing out that device.
+// Unknown or already revoked tokens are ignored.
+func (a *authEndpoint) logout(w http.ResponseWriter, r *http.Request) error {
+	input := refreshRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
+	if input.RefreshToken == "" {
+		return newMissingParameterErr("refresh_token is required")
+	}
+
+	session, err := a.service.SessionWithRefreshToken(r.Context(), auth.HashRefreshToken(input.RefreshToken))
+	if err != nil {
+		return newInternalServerErr("could not load session").Wrap(err)
+	}
+
+	if session != nil {
+		err = a.service.RevokeSession(r.Context(), session.UserID, session.ID)
+		if err != nil && err != internal.ErrNotFound {
+			return newInternalServerErr("could not revoke session").Wrap(err)
+		}
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+	return nil
+}
+
+// logoutAll signs out all devices of the user, including this one
+func (a *authEndpoint) logoutAll(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	if err := a.service.RevokeAllSessions(r.Context(), userID); err != nil {
+		return newInternalServerErr("could not revoke sessions").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+	return nil
 }
@@ -1,11 +1,13 @@
 package endpoints
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -80,6 +82,11 @@ func (h WebserviceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		log.Println(msg)
 
+		// Whatever failed, it was cancelled because the request ran out of time
+		if r.Context().Err() == context.DeadlineExceeded {
+			msg = newTimeoutErr("request timed out")
+		}
+
 		rsp := errHttpResponse{
 			errMsg:    *msg,
 			RequestID: middleware.GetReqID(r.Context()),
@@ -131,3 +138,17 @@ func readJSON(r *http.Request, v interface{}) error {
 	}
 	return nil
 }
+
+// intQueryParam reads an optional integer from the query string
+func intQueryParam(r *http.Request, name string, defaultValue int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, newBadRequestErr("invalid '" + name + "' parameter").Wrap(err)
+	}
+	return i, nil
+}
@@ -0,0 +1,740 @@
+package endpoints_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/golang-jwt/jwt"
+	"github.com/jfernstad/habitz/web/cmd/backend/endpoints"
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/auth"
+	"github.com/jfernstad/habitz/web/internal/mock"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const (
+	testSessionID = "test-session"
+	testToken     = "test-token"
+)
+
+var allWeekdays = `["monday","tuesday","wednesday","thursday","friday","saturday","sunday"]`
+
+// fakeJWTService hands out opaque tokens and remembers their claims
+type fakeJWTService struct {
+	mu     sync.Mutex
+	tokens map[string]auth.HabitzJWTClaims
+}
+
+func newFakeJWTService() *fakeJWTService {
+	return &fakeJWTService{tokens: map[string]auth.HabitzJWTClaims{}}
+}
+
+func (f *fakeJWTService) NewToken(claims *auth.HabitzJWTClaims, expiration *time.Time) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token := "token-" + claims.Id
+	f.tokens[token] = *claims
+	return token, nil
+}
+
+func (f *fakeJWTService) VerifyToken(tokenString string) (bool, *auth.HabitzJWTClaims, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	claims, ok := f.tokens[tokenString]
+	if !ok {
+		return false, nil, errors.New("invalid token")
+	}
+	return true, &claims, nil
+}
+
+func (f *fakeJWTService) JWKS() *auth.JWKSet {
+	return &auth.JWKSet{Keys: []auth.JWK{}}
+}
+
+// testServer routes requests like the backend does, on top of the in-memory service
+type testServer struct {
+	service internal.HabitzServicer
+	jwt     *fakeJWTService
+	router  http.Handler
+	user    *repository.User
+}
+
+func newTestServer(t *testing.T) *testServer {
+	hs := mock.NewHabitzService()
+	jwtService := newFakeJWTService()
+
+	user, err := hs.CreateLocalUser(context.Background(), &repository.User{Email: "tester@example.com", Firstname: "Tester", Timezone: "UTC"}, "")
+	assert.Nil(t, err)
+
+	now := time.Now()
+	err = hs.CreateSession(context.Background(), &repository.Session{
+		ID:               testSessionID,
+		UserID:           user.ID,
+		RefreshTokenHash: "test-hash",
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(time.Hour),
+	})
+	assert.Nil(t, err)
+
+	jwtService.tokens[testToken] = auth.HabitzJWTClaims{
+		Firstname:      user.Firstname,
+		StandardClaims: jwt.StandardClaims{Id: testSessionID, Subject: user.ID},
+	}
+
+	r := endpoints.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Mount("/v1", endpoints.NewHabitzEndpoint(hs, jwtService, time.Minute).Routes())
+	r.Mount("/auth", endpoints.NewAuthEndpoint(hs, jwtService, nil, nil).Routes())
+
+	return &testServer{
+		service: hs,
+		jwt:     jwtService,
+		router:  r,
+		user:    user,
+	}
+}
+
+func (s *testServer) do(method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(middleware.RequestIDHeader, "test-request")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// normalize replaces the values that change between runs, the user ID and today's date
+func (s *testServer) normalize(body string) string {
+	today := time.Now().UTC()
+	weekday := strings.ToLower(today.Weekday().String())
+
+	body = strings.Replace(body, s.user.ID, "USER_ID", -1)
+	body = strings.Replace(body, today.Format("2006-01-02"), "TODAY", -1)
+	body = strings.Replace(body, `"weekday":"`+weekday+`"`, `"weekday":"WEEKDAY"`, -1)
+	return body
+}
+
+// assertGolden compares the response with testdata/<name>.json, run with -update to rewrite it
+func (s *testServer) assertGolden(t *testing.T, name string, w *httptest.ResponseRecorder) {
+	path := filepath.Join("testdata", name+".json")
+	got := s.normalize(w.Body.String())
+
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, string(want), got, name)
+}
+
+type requestCase struct {
+	name   string
+	method string
+	path   string
+	token  string
+	body   string
+	status int
+	golden string // Response is compared with testdata/<golden>.json, when set
+}
+
+func (s *testServer) run(t *testing.T, cases []requestCase) {
+	for _, tc := range cases {
+		w := s.do(tc.method, tc.path, tc.token, tc.body)
+
+		if !assert.Equal(t, tc.status, w.Code, "%s: %s", tc.name, w.Body.String()) {
+			continue
+		}
+		if tc.golden != "" {
+			assert.Equal(t, "application/json", w.Header().Get("content-type"), tc.name)
+			s.assertGolden(t, tc.golden, w)
+		}
+	}
+}
+
+// The cases run in order against the same service, each builds on the previous
+func TestSchedule(t *testing.T) {
+	s := newTestServer(t)
+
+	s.run(t, []requestCase{
+		{"empty schedule", "GET", "/v1/schedule", testToken, "", http.StatusOK, "schedule_empty"},
+		{"nothing today", "GET", "/v1/today", testToken, "", http.StatusOK, "today_empty"},
+		{"schedule every day", "POST", "/v1/schedule", testToken, `{"habit":"Read","weekdays":` + allWeekdays + `}`, http.StatusCreated, "empty"},
+		{"scheduled", "GET", "/v1/schedule", testToken, "", http.StatusOK, "schedule"},
+		{"entry for today", "GET", "/v1/today", testToken, "", http.StatusOK, "today"},
+		{"complete today", "PATCH", "/v1/today", testToken, `[{"habitz":[{"id":1,"complete":true}]}]`, http.StatusOK, "empty"},
+		{"unknown entry", "PATCH", "/v1/today", testToken, `[{"habitz":[{"id":42,"complete":true}]}]`, http.StatusNotFound, "error_unknown_entry"},
+		{"unknown habit", "DELETE", "/v1/schedule", testToken, `{"habit":"Write","weekday":"monday"}`, http.StatusNotFound, "error_unknown_habit"},
+		{"both weekdays and rrule", "POST", "/v1/schedule", testToken, `{"habit":"Read","weekdays":["monday"],"rrule":"FREQ=DAILY"}`, http.StatusBadRequest, "error_weekdays_and_rrule"},
+	})
+
+	// Removing every day also removes today's entry
+	for _, weekday := range []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"} {
+		s.run(t, []requestCase{
+			{"unschedule " + weekday, "DELETE", "/v1/schedule", testToken, `{"habit":"Read","weekday":"` + weekday + `"}`, http.StatusOK, "empty"},
+		})
+	}
+
+	s.run(t, []requestCase{
+		{"not scheduled", "DELETE", "/v1/schedule", testToken, `{"habit":"Read","weekday":"monday"}`, http.StatusNotFound, "error_not_scheduled"},
+		{"unscheduled", "GET", "/v1/schedule", testToken, "", http.StatusOK, "schedule_unscheduled"},
+		{"nothing left today", "GET", "/v1/today", testToken, "", http.StatusOK, "today_empty"},
+	})
+}
+
+// Entries for today are created when they are first loaded, only once
+func TestTodayCreatesEntries(t *testing.T) {
+	s := newTestServer(t)
+
+	habit, err := s.service.CreateHabit(context.Background(), s.user.ID, &repository.Habit{Name: "Walk", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+
+	weekday := strings.ToLower(time.Now().UTC().Weekday().String())
+	assert.Nil(t, s.service.CreateTemplate(context.Background(), s.user.ID, weekday, habit.ID))
+
+	today := time.Now().UTC().Format("2006-01-02")
+	entries, err := s.service.HabitEntries(context.Background(), s.user.ID, today)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	for i := 0; i < 2; i++ {
+		w := s.do("GET", "/v1/today", testToken, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		s.assertGolden(t, "today_lazy", w)
+	}
+
+	entries, err = s.service.HabitEntries(context.Background(), s.user.ID, today)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// Stats count every day of the window since the habit was created, not only days with entries
+// Habitz with a recurrence rule have no weekdays, unscheduling removes the rule
+func TestUnscheduleRecurrence(t *testing.T) {
+	s := newTestServer(t)
+
+	s.run(t, []requestCase{
+		{"schedule with rrule", "POST", "/v1/schedule", testToken, `{"habit":"Read","rrule":"FREQ=DAILY"}`, http.StatusCreated, "empty"},
+		{"unschedule", "DELETE", "/v1/schedule", testToken, `{"habit":"Read"}`, http.StatusOK, "empty"},
+		{"unscheduled", "GET", "/v1/schedule", testToken, "", http.StatusOK, "schedule_unscheduled"},
+		{"nothing left today", "GET", "/v1/today", testToken, "", http.StatusOK, "today_empty"},
+		{"not scheduled", "DELETE", "/v1/schedule", testToken, `{"habit":"Read","weekday":"monday"}`, http.StatusNotFound, "error_not_scheduled"},
+	})
+
+	habit, err := s.service.HabitWithName(context.Background(), s.user.ID, "Read")
+	assert.Nil(t, err)
+	assert.Equal(t, "", habit.Recurrence)
+	assert.Equal(t, "", habit.RecurrenceStart)
+}
+
+func TestStats(t *testing.T) {
+	s := newTestServer(t)
+
+	today := time.Now().UTC()
+	day := func(days int) string { return today.AddDate(0, 0, -days).Format("2006-01-02") }
+	created, _ := time.Parse("2006-01-02", day(2))
+
+	_, err := s.service.Import(context.Background(), s.user.ID, &repository.HabitImport{
+		Habits: []*repository.ImportedHabit{
+			{Habit: repository.Habit{Name: "Walk", Kind: repository.HabitKindCheck, Target: 1, CreatedAt: today.AddDate(0, -2, 0)}, Weekdays: []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}},
+			{Habit: repository.Habit{Name: "Read", Kind: repository.HabitKindCheck, Target: 1, CreatedAt: created, Recurrence: "FREQ=DAILY"}},
+		},
+		Entries: []*repository.ImportedEntry{
+			{Habit: "Walk", Date: day(3), Complete: true},
+			{Habit: "Walk", Date: day(1), Complete: true},
+			{Habit: "Read", Date: day(1), Complete: true},
+			{Habit: "Walk", Date: day(10), Complete: true}, // Outside the window
+		},
+	}, false)
+	assert.Nil(t, err)
+
+	s.run(t, []requestCase{
+		{name: "invalid window", method: "GET", path: "/v1/stats?window=decade", token: testToken, status: http.StatusBadRequest},
+		{name: "no token", method: "GET", path: "/v1/stats", status: http.StatusUnauthorized},
+	})
+
+	w := s.do("GET", "/v1/stats", testToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Window string                   `json:"window"`
+		From   string                   `json:"from"`
+		To     string                   `json:"to"`
+		Stats  []*repository.HabitStats `json:"stats"`
+	}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "week", response.Window)
+	assert.Equal(t, day(6), response.From)
+	assert.Equal(t, day(0), response.To)
+
+	if assert.Len(t, response.Stats, 2) {
+		walk := response.Stats[0]
+		assert.Equal(t, "Walk", walk.Habit)
+		assert.Equal(t, 6, walk.ScheduledDays) // Today isn't completed yet
+		assert.Equal(t, 2, walk.CompletedDays)
+
+		read := response.Stats[1]
+		assert.Equal(t, "Read", read.Habit)
+		assert.Equal(t, 2, read.ScheduledDays)
+		assert.Equal(t, 1, read.CompletedDays)
+		assert.Equal(t, 0.5, read.CompletionRate)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := newTestServer(t)
+
+	_, err := s.service.Import(context.Background(), s.user.ID, &repository.HabitImport{
+		Habits: []*repository.ImportedHabit{{Habit: repository.Habit{Name: "Walk", Kind: repository.HabitKindCheck, Target: 1}}},
+		Entries: []*repository.ImportedEntry{
+			{Habit: "Walk", Date: "2021-03-01", Complete: true},
+			{Habit: "Walk", Date: "2021-03-02"},
+			{Habit: "Walk", Date: "2021-03-03", Complete: true},
+			{Habit: "Walk", Date: "2021-03-10", Complete: true}, // Outside the range
+		},
+	}, false)
+	assert.Nil(t, err)
+
+	history := "/v1/history?from=2021-03-01&to=2021-03-05"
+	s.run(t, []requestCase{
+		{name: "invalid from", method: "GET", path: "/v1/history?from=march", token: testToken, status: http.StatusBadRequest},
+		{name: "invalid to", method: "GET", path: "/v1/history?to=2021-03-32", token: testToken, status: http.StatusBadRequest},
+		{name: "from after to", method: "GET", path: "/v1/history?from=2021-03-05&to=2021-03-01", token: testToken, status: http.StatusBadRequest, golden: "error_history_from_after_to"},
+		{name: "limit not a number", method: "GET", path: history + "&limit=ten", token: testToken, status: http.StatusBadRequest},
+		{name: "limit too small", method: "GET", path: history + "&limit=0", token: testToken, status: http.StatusBadRequest, golden: "error_history_limit_range"},
+		{name: "limit too large", method: "GET", path: history + "&limit=1001", token: testToken, status: http.StatusBadRequest, golden: "error_history_limit_range"},
+		{name: "offset not a number", method: "GET", path: history + "&offset=first", token: testToken, status: http.StatusBadRequest},
+		{name: "negative offset", method: "GET", path: history + "&offset=-1", token: testToken, status: http.StatusBadRequest, golden: "error_history_negative_offset"},
+		{name: "first page", method: "GET", path: history + "&limit=2", token: testToken, status: http.StatusOK, golden: "history_first_page"},
+		{name: "last page", method: "GET", path: history + "&limit=2&offset=2", token: testToken, status: http.StatusOK, golden: "history_last_page"},
+		{name: "exactly one page", method: "GET", path: history + "&limit=3", token: testToken, status: http.StatusOK, golden: "history_one_page"},
+		{name: "past the end", method: "GET", path: history + "&offset=10", token: testToken, status: http.StatusOK, golden: "history_past_end"},
+	})
+}
+
+// Habitz are validated the same way when created and when changed
+func TestHabitValidation(t *testing.T) {
+	s := newTestServer(t)
+
+	s.run(t, []requestCase{
+		{"no name", "POST", "/v1/habits", testToken, `{"name":"  "}`, http.StatusBadRequest, "error_habit_name_missing"},
+		{"quantity without target", "POST", "/v1/habits", testToken, `{"name":"Water","kind":"quantity","unit":"l"}`, http.StatusBadRequest, "error_habit_target"},
+		{"quantity with negative target", "POST", "/v1/habits", testToken, `{"name":"Water","kind":"quantity","target":-2}`, http.StatusBadRequest, "error_habit_target"},
+		{"invalid kind", "POST", "/v1/habits", testToken, `{"name":"Water","kind":"sometimes"}`, http.StatusBadRequest, "error_habit_kind"},
+	})
+
+	habit := func(w *httptest.ResponseRecorder) *repository.Habit {
+		h := &repository.Habit{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), h), w.Body.String())
+		return h
+	}
+
+	// Check habitz have no target or unit of their own
+	w := s.do("POST", "/v1/habits", testToken, `{"name":" Walk ","target":5,"unit":"km"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	walk := habit(w)
+	assert.Equal(t, "Walk", walk.Name)
+	assert.Equal(t, repository.HabitKindCheck, walk.Kind)
+	assert.Equal(t, 1.0, walk.Target)
+	assert.Equal(t, "", walk.Unit)
+
+	w = s.do("POST", "/v1/habits", testToken, `{"name":"Water","kind":"quantity","target":2.5,"unit":"l"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	water := habit(w)
+	assert.Equal(t, repository.HabitKindQuantity, water.Kind)
+	assert.Equal(t, 2.5, water.Target)
+	assert.Equal(t, "l", water.Unit)
+
+	walkPath := fmt.Sprintf("/v1/habits/%d", walk.ID)
+	waterPath := fmt.Sprintf("/v1/habits/%d", water.ID)
+	s.run(t, []requestCase{
+		{"same name", "POST", "/v1/habits", testToken, `{"name":"Walk"}`, http.StatusConflict, ""},
+		{"rename to existing", "PATCH", walkPath, testToken, `{"name":"Water"}`, http.StatusConflict, ""},
+		{"remove name", "PATCH", walkPath, testToken, `{"name":""}`, http.StatusBadRequest, "error_habit_name_missing"},
+		{"remove target", "PATCH", waterPath, testToken, `{"target":0}`, http.StatusBadRequest, "error_habit_target"},
+		{"change to invalid kind", "PATCH", waterPath, testToken, `{"kind":"sometimes"}`, http.StatusBadRequest, "error_habit_kind"},
+		{"invalid habit id", "PATCH", "/v1/habits/walk", testToken, `{"name":"Run"}`, http.StatusBadRequest, ""},
+		{"unknown habit", "PATCH", "/v1/habits/42", testToken, `{"name":"Run"}`, http.StatusNotFound, ""},
+	})
+
+	// A check habit becomes a quantity of one
+	w = s.do("PATCH", walkPath, testToken, `{"kind":"quantity","unit":"walks"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	walk = habit(w)
+	assert.Equal(t, repository.HabitKindQuantity, walk.Kind)
+	assert.Equal(t, 1.0, walk.Target)
+	assert.Equal(t, "walks", walk.Unit)
+
+	// A quantity habit loses its target and unit
+	w = s.do("PATCH", waterPath, testToken, `{"kind":"check"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	water = habit(w)
+	assert.Equal(t, repository.HabitKindCheck, water.Kind)
+	assert.Equal(t, 1.0, water.Target)
+	assert.Equal(t, "", water.Unit)
+}
+
+// Quantity habitz are updated with a value or increments, check habitz with complete
+func TestUpdateTodaysEntries(t *testing.T) {
+	s := newTestServer(t)
+
+	s.run(t, []requestCase{
+		{"create quantity habit", "POST", "/v1/habits", testToken, `{"name":"Water","kind":"quantity","target":8,"unit":"glasses"}`, http.StatusCreated, ""},
+		{"schedule quantity habit", "POST", "/v1/schedule", testToken, `{"habit":"Water","weekdays":` + allWeekdays + `}`, http.StatusCreated, ""},
+		{"schedule check habit", "POST", "/v1/schedule", testToken, `{"habit":"Walk","weekdays":` + allWeekdays + `}`, http.StatusCreated, ""},
+		{"entries for today", "GET", "/v1/today", testToken, "", http.StatusOK, ""},
+	})
+
+	today := time.Now().UTC().Format("2006-01-02")
+	entries, err := s.service.HabitEntries(context.Background(), s.user.ID, today)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	byHabit := map[string]int{}
+	for _, e := range entries {
+		byHabit[e.Habit] = e.ID
+	}
+	water, walk := byHabit["Water"], byHabit["Walk"]
+
+	update := func(changes string) string {
+		return `[{"habitz":[` + changes + `]}]`
+	}
+	entry := func(id int) *repository.HabitEntry {
+		entries, err := s.service.HabitEntries(context.Background(), s.user.ID, today)
+		assert.Nil(t, err)
+		for _, e := range entries {
+			if e.ID == id {
+				return e
+			}
+		}
+		t.Fatalf("entry %d not found", id)
+		return nil
+	}
+	assertEntry := func(name string, id int, value float64, complete bool) {
+		e := entry(id)
+		assert.Equal(t, value, e.Value, name)
+		assert.Equal(t, complete, e.Complete, name)
+	}
+
+	steps := []struct {
+		name     string
+		changes  string
+		status   int
+		golden   string
+		value    float64
+		complete bool
+	}{
+		{"increment", fmt.Sprintf(`{"id":%d,"increment":3}`, water), http.StatusOK, "empty", 3, false},
+		{"increment again", fmt.Sprintf(`{"id":%d,"increment":2.5}`, water), http.StatusOK, "empty", 5.5, false},
+		{"value and increment", fmt.Sprintf(`{"id":%d,"value":8,"increment":1}`, water), http.StatusBadRequest, "error_entry_value_and_increment", 5.5, false},
+		{"negative value", fmt.Sprintf(`{"id":%d,"value":-1}`, water), http.StatusBadRequest, "error_entry_negative_value", 5.5, false},
+		{"reach the target", fmt.Sprintf(`{"id":%d,"value":8}`, water), http.StatusOK, "empty", 8, true},
+		{"negative increment", fmt.Sprintf(`{"id":%d,"increment":-1}`, water), http.StatusOK, "empty", 7, false},
+		{"never below zero", fmt.Sprintf(`{"id":%d,"increment":-10}`, water), http.StatusOK, "empty", 0, false},
+		{"value wins for quantity habitz", fmt.Sprintf(`{"id":%d,"kind":"check","value":9,"complete":false}`, water), http.StatusOK, "empty", 9, true},
+		{"invalid update is not applied", fmt.Sprintf(`{"id":%d,"value":1},{"id":%d,"value":-1}`, water, water), http.StatusBadRequest, "error_entry_negative_value", 9, true},
+		{"unknown entry", `{"id":42,"increment":1}`, http.StatusNotFound, "error_unknown_entry", 9, true},
+		{"unknown entry later in the batch", fmt.Sprintf(`{"id":%d,"increment":-9},{"id":42,"increment":1}`, water), http.StatusNotFound, "error_unknown_entry", 9, true},
+	}
+
+	for _, step := range steps {
+		s.run(t, []requestCase{{step.name, "PATCH", "/v1/today", testToken, update(step.changes), step.status, step.golden}})
+		assertEntry(step.name, water, step.value, step.complete)
+	}
+
+	// Entries sent back from GET /today complete check habitz with `complete`, whatever kind they claim
+	s.run(t, []requestCase{
+		{"complete check habit", "PATCH", "/v1/today", testToken, update(fmt.Sprintf(`{"id":%d,"kind":"quantity","complete":true,"value":0}`, walk)), http.StatusOK, "empty"},
+	})
+	assert.True(t, entry(walk).Complete)
+}
+
+// Only IANA timezones are stored, the ones time.LoadLocation treats specially are not
+func TestUpdateMeTimezone(t *testing.T) {
+	s := newTestServer(t)
+
+	s.run(t, []requestCase{
+		{name: "iana timezone", method: "PATCH", path: "/v1/me", token: testToken, body: `{"timezone":"Europe/Stockholm"}`, status: http.StatusOK},
+		{name: "server timezone", method: "PATCH", path: "/v1/me", token: testToken, body: `{"timezone":"Local"}`, status: http.StatusBadRequest, golden: "error_invalid_timezone"},
+		{name: "empty timezone", method: "PATCH", path: "/v1/me", token: testToken, body: `{"timezone":""}`, status: http.StatusBadRequest, golden: "error_invalid_timezone"},
+		{name: "unknown timezone", method: "PATCH", path: "/v1/me", token: testToken, body: `{"timezone":"Mars/Olympus_Mons"}`, status: http.StatusBadRequest, golden: "error_invalid_timezone"},
+	})
+
+	user, err := s.service.User(context.Background(), s.user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Europe/Stockholm", user.Timezone)
+}
+
+func TestMalformedBodies(t *testing.T) {
+	s := newTestServer(t)
+
+	s.run(t, []requestCase{
+		{"schedule", "POST", "/v1/schedule", testToken, `{"habit":`, http.StatusBadRequest, "error_schedule_malformed"},
+		{"unschedule", "DELETE", "/v1/schedule", testToken, `{"weekday":`, http.StatusBadRequest, "error_unschedule_malformed"},
+		{"today", "PATCH", "/v1/today", testToken, `[{"habitz":[{"id":1,`, http.StatusBadRequest, "error_today_malformed"},
+		{"habit", "POST", "/v1/habits", testToken, `not json`, http.StatusBadRequest, ""},
+		{"register", "POST", "/auth/register", "", `{`, http.StatusBadRequest, "error_register_malformed"},
+		{"login", "POST", "/auth/login", "", ``, http.StatusBadRequest, "error_login_malformed"},
+		{"refresh", "POST", "/auth/refresh", "", `{}`, http.StatusBadRequest, "error_refresh_missing"},
+	})
+}
+
+func TestAuthFailures(t *testing.T) {
+	s := newTestServer(t)
+
+	s.jwt.tokens["no-session"] = auth.HabitzJWTClaims{}
+	s.jwt.tokens["other-user"] = auth.HabitzJWTClaims{
+		StandardClaims: jwt.StandardClaims{Id: testSessionID, Subject: "someone-else"},
+	}
+
+	// The access token is still valid, but its session isn't
+	expired := time.Now().Add(-time.Hour)
+	err := s.service.CreateSession(context.Background(), &repository.Session{
+		ID:               "expired-session",
+		UserID:           s.user.ID,
+		RefreshTokenHash: "expired-hash",
+		CreatedAt:        expired.Add(-time.Hour),
+		ExpiresAt:        expired,
+	})
+	assert.Nil(t, err)
+	s.jwt.tokens["expired-session"] = auth.HabitzJWTClaims{
+		StandardClaims: jwt.StandardClaims{Id: "expired-session", Subject: s.user.ID},
+	}
+
+	s.run(t, []requestCase{
+		{"missing token", "GET", "/v1/today", "", "", http.StatusUnauthorized, "error_token_missing"},
+		{"invalid token", "GET", "/v1/today", "invalid", "", http.StatusUnauthorized, "error_token_invalid"},
+		{"token without session", "GET", "/v1/today", "no-session", "", http.StatusUnauthorized, "error_token_no_session"},
+		{"session of other user", "GET", "/v1/today", "other-user", "", http.StatusUnauthorized, "error_session_revoked"},
+		{"expired session", "GET", "/v1/today", "expired-session", "", http.StatusUnauthorized, "error_session_expired"},
+		{"logout all without token", "POST", "/auth/logout/all", "", "", http.StatusUnauthorized, "error_token_missing"},
+		{"admins only", "GET", "/v1/users", testToken, "", http.StatusForbidden, "error_admins_only"},
+		{"unknown provider", "POST", "/auth/unknown", "", `{"token":"abc"}`, http.StatusNotFound, "error_unknown_provider"},
+	})
+
+	// Tokens stop working once their session is revoked
+	s.run(t, []requestCase{
+		{"logout all", "POST", "/auth/logout/all", testToken, "", http.StatusNoContent, ""},
+		{"revoked session", "GET", "/v1/today", testToken, "", http.StatusUnauthorized, "error_session_revoked"},
+	})
+}
+
+func TestAuthEndpoint(t *testing.T) {
+	s := newTestServer(t)
+
+	s.run(t, []requestCase{
+		{"weak password", "POST", "/auth/register", "", `{"email":"new@example.com","password":"short"}`, http.StatusBadRequest, ""},
+		{"invalid email", "POST", "/auth/register", "", `{"email":"not an email","password":"correct horse battery"}`, http.StatusBadRequest, "error_invalid_email"},
+		{"server timezone", "POST", "/auth/register", "", `{"email":"new@example.com","password":"correct horse battery","timezone":"Local"}`, http.StatusBadRequest, "error_invalid_timezone"},
+		{"jwks", "GET", "/auth/.well-known/jwks.json", "", "", http.StatusOK, "jwks"},
+	})
+
+	w := s.do("POST", "/auth/register", "", `{"email":"new@example.com","password":"correct horse battery","firstname":"New"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	s.run(t, []requestCase{
+		{"already registered", "POST", "/auth/register", "", `{"email":"NEW@example.com","password":"correct horse battery"}`, http.StatusConflict, "error_already_registered"},
+		{"wrong password", "POST", "/auth/login", "", `{"email":"new@example.com","password":"wrong password"}`, http.StatusUnauthorized, "error_wrong_password"},
+	})
+
+	w = s.do("POST", "/auth/login", "", `{"email":"new@example.com","password":"correct horse battery"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	tokens := struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+	assert.NotEmpty(t, tokens.Token)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	assert.Equal(t, int(auth.AccessTokenDuration.Seconds()), tokens.ExpiresIn)
+
+	refresh := `{"refresh_token":"` + tokens.RefreshToken + `"}`
+	s.run(t, []requestCase{
+		{"new user", "GET", "/v1/schedule", tokens.Token, "", http.StatusOK, ""},
+		{"refresh", "POST", "/auth/refresh", "", refresh, http.StatusOK, ""},
+		{"refresh token rotated", "POST", "/auth/refresh", "", refresh, http.StatusUnauthorized, "error_invalid_refresh_token"},
+	})
+}
+
+func TestUnknownRoutes(t *testing.T) {
+	s := newTestServer(t)
+
+	s.run(t, []requestCase{
+		{"unknown route", "GET", "/v1/nothing", testToken, "", http.StatusNotFound, "error_handler_not_found"},
+		{"wrong method", "PUT", "/v1/today", testToken, "", http.StatusMethodNotAllowed, "error_method_not_allowed"},
+	})
+}
+
+// Handlers that fail because the deadline passed respond with a timeout
+func TestRequestTimeout(t *testing.T) {
+	s := newTestServer(t)
+
+	r := endpoints.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(endpoints.RequestTimeout(time.Millisecond))
+	r.Get("/slow", endpoints.ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		<-r.Context().Done()
+		return r.Context().Err()
+	}))
+	s.router = r
+
+	s.run(t, []requestCase{
+		{"slow", "GET", "/slow", "", "", http.StatusGatewayTimeout, "error_timeout"},
+	})
+}
+
+// slowService reads habitz and entries slowly and stops when the request is cancelled, like a database would
+type slowService struct {
+	internal.HabitzServicer
+	delay time.Duration
+}
+
+func (s *slowService) Habits(ctx context.Context, userID string, includeArchived bool) ([]*repository.Habit, error) {
+	time.Sleep(s.delay)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.HabitzServicer.Habits(ctx, userID, includeArchived)
+}
+
+func (s *slowService) EachHabitEntry(ctx context.Context, userID string, fn func(*repository.HabitEntry) error) error {
+	return s.HabitzServicer.EachHabitEntry(ctx, userID, func(entry *repository.HabitEntry) error {
+		time.Sleep(s.delay)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(entry)
+	})
+}
+
+// Exports outlast the request deadline, the file is never cut off halfway
+func TestExportHasNoDeadline(t *testing.T) {
+	s := newTestServer(t)
+
+	habit, err := s.service.CreateHabit(context.Background(), s.user.ID, &repository.Habit{Name: "Walk", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+	for _, date := range []string{"2021-03-01", "2021-03-02", "2021-03-03"} {
+		_, err := s.service.CreateHabitEntry(context.Background(), s.user.ID, date, "monday", habit.ID)
+		assert.Nil(t, err)
+	}
+
+	slow := &slowService{HabitzServicer: s.service, delay: 20 * time.Millisecond}
+	r := endpoints.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Mount("/v1", endpoints.NewHabitzEndpoint(slow, s.jwt, 10*time.Millisecond).Routes())
+	s.router = r
+
+	w := s.do("GET", "/v1/export", testToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	export := struct {
+		Entries []*repository.HabitEntry `json:"entries"`
+	}{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &export), w.Body.String())
+	assert.Len(t, export.Entries, 3)
+
+	// Other requests still have a deadline
+	s.run(t, []requestCase{
+		{"slow habitz", "GET", "/v1/habits", testToken, "", http.StatusGatewayTimeout, "error_timeout"},
+	})
+}
+
+// The preferences are a JSON column of profile.csv, on a single line
+func TestExportCSVProfile(t *testing.T) {
+	s := newTestServer(t)
+
+	s.run(t, []requestCase{
+		{name: "preferences", method: "PATCH", path: "/v1/me", token: testToken, body: "{\"preferences\":{\"theme\": \"dark\",\n \"week_starts_on\": \"monday\"}}", status: http.StatusOK},
+	})
+
+	w := s.do("GET", "/v1/export?format=csv", testToken, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.Nil(t, err)
+	for _, file := range archive.File {
+		if file.Name != "profile.csv" {
+			continue
+		}
+		f, err := file.Open()
+		assert.Nil(t, err)
+		rows, err := csv.NewReader(f).ReadAll()
+		assert.Nil(t, err)
+		assert.Equal(t, [][]string{
+			{"id", "email", "name", "lastname", "timezone", "preferences"},
+			{s.user.ID, "tester@example.com", "Tester", "", "UTC", `{"theme":"dark","week_starts_on":"monday"}`},
+		}, rows)
+		return
+	}
+	t.Error("profile.csv is missing")
+}
+
+func TestClientAddress(t *testing.T) {
+	proxies, err := endpoints.ParseTrustedProxies("10.0.0.1, 172.16.0.0/12, ::1")
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		proxies   endpoints.TrustedProxies
+		address   string
+	}{
+		{name: "direct", remote: "192.0.2.7:1234", address: "192.0.2.7"},
+		{name: "headers without trusted proxies", remote: "10.0.0.1:1234", forwarded: "192.0.2.7", address: "10.0.0.1"},
+		{name: "headers from an untrusted client", remote: "192.0.2.9:1234", forwarded: "192.0.2.7", realIP: "192.0.2.8", proxies: proxies, address: "192.0.2.9"},
+		{name: "forwarded by a proxy", remote: "10.0.0.1:1234", forwarded: "192.0.2.7", proxies: proxies, address: "192.0.2.7"},
+		{name: "spoofed forwarded address", remote: "10.0.0.1:1234", forwarded: "1.2.3.4, 192.0.2.7", proxies: proxies, address: "192.0.2.7"},
+		{name: "chain of proxies", remote: "10.0.0.1:1234", forwarded: "192.0.2.7, 172.17.0.2", proxies: proxies, address: "192.0.2.7"},
+		{name: "invalid forwarded address", remote: "10.0.0.1:1234", forwarded: "unknown, 172.17.0.2", proxies: proxies, address: "172.17.0.2"},
+		{name: "real ip", remote: "[::1]:1234", realIP: "192.0.2.7", proxies: proxies, address: "192.0.2.7"},
+		{name: "no headers from a proxy", remote: "10.0.0.1:1234", proxies: proxies, address: "10.0.0.1"},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/auth/login", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-IP", c.realIP)
+		}
+		assert.Equal(t, c.address, c.proxies.ClientAddress(r), c.name)
+	}
+
+	for _, invalid := range []string{"10.0.0", "10.0.0.0/33", "proxy"} {
+		_, err := endpoints.ParseTrustedProxies(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
@@ -9,7 +9,11 @@ import (
 const (
 	BadRequest          = "BAD_REQUEST"
 	UnAuthorized        = "UNAUTHORIZED"
+	Forbidden           = "FORBIDDEN"
 	NotFound            = "NOT_FOUND"
+	Conflict            = "CONFLICT"
+	TooManyRequests     = "TOO_MANY_REQUESTS"
+	Timeout             = "TIMEOUT"
 	InternalServerError = "INTERNAL_SERVER_ERROR"
 	MissingParameter    = "MISSING_PARAMETER"
 	MethodNotAllowed    = "METHOD_NOT_ALLOWED"
@@ -82,6 +86,14 @@ func newNotAuthenticatedErr(msg string) *errMsg {
 	}
 }
 
+func newForbiddenErr(msg string) *errMsg {
+	return &errMsg{
+		HTTPCode: http.StatusForbidden,
+		Code:     Forbidden,
+		Message:  msg,
+	}
+}
+
 func newNotFoundErr(msg string) *errMsg {
 	return &errMsg{
 		HTTPCode: http.StatusNotFound,
@@ -90,6 +102,30 @@ func newNotFoundErr(msg string) *errMsg {
 	}
 }
 
+func newConflictErr(msg string) *errMsg {
+	return &errMsg{
+		HTTPCode: http.StatusConflict,
+		Code:     Conflict,
+		Message:  msg,
+	}
+}
+
+func newTooManyRequestsErr(msg string) *errMsg {
+	return &errMsg{
+		HTTPCode: http.StatusTooManyRequests,
+		Code:     TooManyRequests,
+		Message:  msg,
+	}
+}
+
+func newTimeoutErr(msg string) *errMsg {
+	return &errMsg{
+		HTTPCode: http.StatusGatewayTimeout,
+		Code:     Timeout,
+		Message:  msg,
+	}
+}
+
 func newInternalServerErr(msg string) *errMsg {
 	return &errMsg{
 		HTTPCode: http.StatusInternalServerError,
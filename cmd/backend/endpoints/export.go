@@ -0,0 +1,222 @@
+package endpoints
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// exportData is everything about a user except the entries, which are streamed
+type exportData struct {
+	Profile    *repository.User                 `json:"profile"`
+	HabitTypes []*repository.HabitType          `json:"habit_types"`
+	Habits     []*repository.Habit              `json:"habits"`
+	Templates  []*repository.WeekHabitTemplates `json:"templates"`
+}
+
+// export streams all data of the user, as a JSON document or CSV files in a zip archive.
+// The entries are written as they are read from the database.
+func (h *habitz) export(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		return newBadRequestErr("format must be json or csv")
+	}
+
+	data, err := h.exportData(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	filename := "habitz-export-" + time.Now().UTC().Format(internal.ShortDateFormat)
+
+	// The status is sent with the first write, errors after that can only be logged
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		err = h.exportJSON(r.Context(), w, userID, data)
+	} else {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+		err = h.exportCSV(r.Context(), w, userID, data)
+	}
+	if err != nil {
+		log.Println("export error: ", err)
+	}
+
+	return nil
+}
+
+func (h *habitz) exportData(ctx context.Context, userID string) (*exportData, error) {
+	profile, err := h.service.User(ctx, userID)
+	if err != nil {
+		return nil, newInternalServerErr("could not load user").Wrap(err)
+	}
+	if profile == nil {
+		return nil, newNotFoundErr("user not found")
+	}
+
+	types, err := h.service.HabitTypes(ctx, userID)
+	if err != nil {
+		return nil, newInternalServerErr("could not load habit types").Wrap(err)
+	}
+
+	habits, err := h.service.Habits(ctx, userID, true)
+	if err != nil {
+		return nil, newInternalServerErr("could not load habitz").Wrap(err)
+	}
+
+	templates, err := h.service.Templates(ctx, userID)
+	if err != nil {
+		return nil, newInternalServerErr("could not load templates").Wrap(err)
+	}
+
+	return &exportData{
+		Profile:    profile,
+		HabitTypes: types,
+		Habits:     habits,
+		Templates:  templates,
+	}, nil
+}
+
+// exportJSON writes the data followed by the entries, without holding all entries in memory
+func (h *habitz) exportJSON(ctx context.Context, w io.Writer, userID string, data *exportData) error {
+	head, err := json.Marshal(struct {
+		ExportedAt time.Time `json:"exported_at"`
+		*exportData
+	}{time.Now().UTC(), data})
+	if err != nil {
+		return err
+	}
+
+	// Leave the object open and add the entries
+	if _, err := fmt.Fprintf(w, "%s,\"entries\":[\n", head[:len(head)-1]); err != nil {
+		return err
+	}
+
+	first := true
+	err = h.service.EachHabitEntry(ctx, userID, func(entry *repository.HabitEntry) error {
+		if !first {
+			if _, err := io.WriteString(w, ",\n"); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(entryJSON)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n]}\n")
+	return err
+}
+
+// exportCSV writes profile.csv, habits.csv and entries.csv to a zip archive.
+// The weekday templates are a column of habits.csv.
+func (h *habitz) exportCSV(ctx context.Context, w io.Writer, userID string, data *exportData) error {
+	archive := zip.NewWriter(w)
+
+	typeNames := map[int]string{}
+	for _, t := range data.HabitTypes {
+		typeNames[t.ID] = t.Name
+	}
+	weekdays := map[int][]string{}
+	for _, t := range data.Templates {
+		weekdays[t.HabitID] = t.Weekdays
+	}
+
+	p := data.Profile
+	preferences, err := json.Marshal(p.Preferences)
+	if err != nil {
+		return err
+	}
+	err = writeCSVFile(archive, "profile.csv", [][]string{
+		{"id", "email", "name", "lastname", "timezone", "preferences"},
+		{p.ID, p.Email, p.Firstname, p.Lastname, p.Timezone, string(preferences)},
+	})
+	if err != nil {
+		return err
+	}
+
+	habitRows := [][]string{{"id", "name", "description", "type", "kind", "target", "unit",
+		"weekdays", "rrule", "rrule_start", "created_at", "archived_at"}}
+	for _, habit := range data.Habits {
+		typeName := ""
+		if habit.TypeID != nil {
+			typeName = typeNames[*habit.TypeID]
+		}
+		habitRows = append(habitRows, []string{
+			strconv.Itoa(habit.ID), habit.Name, habit.Description, typeName, habit.Kind,
+			formatFloat(habit.Target), habit.Unit, strings.Join(weekdays[habit.ID], ";"),
+			habit.Recurrence, habit.RecurrenceStart, formatTime(&habit.CreatedAt), formatTime(habit.ArchivedAt),
+		})
+	}
+	if err := writeCSVFile(archive, "habits.csv", habitRows); err != nil {
+		return err
+	}
+
+	file, err := archive.Create("entries.csv")
+	if err != nil {
+		return err
+	}
+	entries := csv.NewWriter(file)
+	entries.Write([]string{"date", "weekday", "habit_id", "habit", "kind", "value", "target", "unit", "complete", "complete_at"})
+
+	err = h.service.EachHabitEntry(ctx, userID, func(e *repository.HabitEntry) error {
+		entries.Write([]string{
+			e.Date, e.Weekday, strconv.Itoa(e.HabitID), e.Habit, e.Kind, formatFloat(e.Value),
+			formatFloat(e.Target), e.Unit, strconv.FormatBool(e.Complete), formatTime(e.CompleteAt),
+		})
+		return entries.Error()
+	})
+	if err != nil {
+		return err
+	}
+	entries.Flush()
+	if err := entries.Error(); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+func writeCSVFile(archive *zip.Writer, name string, rows [][]string) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	return csv.NewWriter(file).WriteAll(rows)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,293 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/recurrence"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// resolveHabit finds a habit by ID, or by name if no ID is given.
+// With `create` a missing habit is created and an archived habit is restored.
+func (h *habitz) resolveHabit(ctx context.Context, userID string, habitID int, name string, create bool) (*repository.Habit, error) {
+	name = strings.TrimSpace(name)
+
+	var habit *repository.Habit
+	var err error
+
+	switch {
+	case habitID != 0:
+		habit, err = h.service.Habit(ctx, userID, habitID)
+	case name != "":
+		habit, err = h.service.HabitWithName(ctx, userID, name)
+	default:
+		return nil, newMissingParameterErr("habit or habit_id is required")
+	}
+
+	if err != nil {
+		return nil, newInternalServerErr("could not load habit").Wrap(err)
+	}
+
+	// Only habitz referenced by name are created
+	if habit == nil && (!create || habitID != 0) {
+		return nil, newNotFoundErr("habit not found")
+	}
+
+	if !create {
+		return habit, nil
+	}
+
+	if habit == nil {
+		newHabit := &repository.Habit{Name: name}
+		if err := validateHabit(newHabit); err != nil {
+			return nil, err
+		}
+
+		habit, err = h.service.CreateHabit(ctx, userID, newHabit)
+		if err != nil {
+			return nil, newInternalServerErr("could not create habit").Wrap(err)
+		}
+	}
+
+	if habit.ArchivedAt != nil {
+		habit, err = h.service.ArchiveHabit(ctx, userID, habit.ID, false)
+		if err != nil {
+			return nil, newInternalServerErr("could not restore habit").Wrap(err)
+		}
+	}
+
+	return habit, nil
+}
+
+// parseRecurrence validates a recurrence rule and returns it in its canonical form
+func parseRecurrence(rrule string) (string, error) {
+	if strings.TrimSpace(rrule) == "" {
+		return "", nil
+	}
+
+	rule, err := recurrence.Parse(rrule)
+	if err != nil {
+		return "", newBadRequestErr("invalid rrule").Wrap(err)
+	}
+	return rule.String(), nil
+}
+
+// validateHabit checks the name and target, and fills in defaults for the kind of habit
+func validateHabit(habit *repository.Habit) error {
+	habit.Name = strings.TrimSpace(habit.Name)
+	if habit.Name == "" {
+		return newMissingParameterErr("name is required")
+	}
+
+	switch habit.Kind {
+	case "", repository.HabitKindCheck:
+		habit.Kind = repository.HabitKindCheck
+		habit.Target = 1
+		habit.Unit = ""
+	case repository.HabitKindQuantity:
+		if habit.Target <= 0 {
+			return newBadRequestErr("target must be greater than 0")
+		}
+	default:
+		return newBadRequestErr("invalid kind, expected check or quantity")
+	}
+
+	return nil
+}
+
+func habitIDParam(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "habitID"))
+	if err != nil {
+		return 0, newBadRequestErr("invalid habit id").Wrap(err)
+	}
+	return id, nil
+}
+
+func (h *habitz) loadHabits(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+	includeArchived := r.URL.Query().Get("archived") == "true"
+
+	habits, err := h.service.Habits(r.Context(), userID, includeArchived)
+	if err != nil {
+		return newInternalServerErr("could not load habitz").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusOK, &habits)
+	return nil
+}
+
+func (h *habitz) loadHabit(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	id, err := habitIDParam(r)
+	if err != nil {
+		return err
+	}
+
+	habit, err := h.resolveHabit(r.Context(), userID, id, "", false)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, http.StatusOK, habit)
+	return nil
+}
+
+func (h *habitz) createHabit(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	input := repository.Habit{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
+
+	if err := validateHabit(&input); err != nil {
+		return err
+	}
+
+	habit, err := h.service.CreateHabit(r.Context(), userID, &input)
+	if err == internal.ErrAlreadyExists {
+		return newConflictErr("a habit with that name already exists")
+	}
+	if err != nil {
+		return newInternalServerErr("could not create habit").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusCreated, habit)
+	return nil
+}
+
+func (h *habitz) updateHabit(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	id, err := habitIDParam(r)
+	if err != nil {
+		return err
+	}
+
+	input := struct {
+		Name        *string  `json:"name"`
+		Description *string  `json:"description"`
+		Kind        *string  `json:"kind"`
+		Target      *float64 `json:"target"`
+		Unit        *string  `json:"unit"`
+		Archived    *bool    `json:"archived"`
+		TypeID      *int     `json:"type_id"` // 0 removes the type
+		RRule       *string  `json:"rrule"`   // Empty removes the recurrence rule
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
+
+	habit, err := h.resolveHabit(r.Context(), userID, id, "", false)
+	if err != nil {
+		return err
+	}
+
+	// Rename, history follows the habit ID
+	if input.Name != nil || input.Description != nil || input.Kind != nil || input.Target != nil || input.Unit != nil {
+		if input.Name != nil {
+			habit.Name = *input.Name
+		}
+		if input.Description != nil {
+			habit.Description = *input.Description
+		}
+		if input.Kind != nil {
+			habit.Kind = *input.Kind
+		}
+		if input.Target != nil {
+			habit.Target = *input.Target
+		}
+		if input.Unit != nil {
+			habit.Unit = *input.Unit
+		}
+
+		if err := validateHabit(habit); err != nil {
+			return err
+		}
+
+		habit, err = h.service.UpdateHabit(r.Context(), userID, habit)
+		if err == internal.ErrAlreadyExists {
+			return newConflictErr("a habit with that name already exists")
+		}
+		if err != nil {
+			return newInternalServerErr("could not update habit").Wrap(err)
+		}
+	}
+
+	if input.Archived != nil {
+		habit, err = h.service.ArchiveHabit(r.Context(), userID, id, *input.Archived)
+		if err != nil {
+			return newInternalServerErr("could not archive habit").Wrap(err)
+		}
+	}
+
+	if input.TypeID != nil {
+		typeID, err := h.validHabitType(r.Context(), userID, *input.TypeID)
+		if err != nil {
+			return err
+		}
+
+		if err := h.service.SetHabitType(r.Context(), userID, id, typeID); err != nil {
+			return newInternalServerErr("could not set habit type").Wrap(err)
+		}
+
+		if habit, err = h.service.Habit(r.Context(), userID, id); err != nil {
+			return newInternalServerErr("could not load habit").Wrap(err)
+		}
+	}
+
+	if input.RRule != nil {
+		rrule, err := parseRecurrence(*input.RRule)
+		if err != nil {
+			return err
+		}
+
+		// The rule counts from today
+		start := ""
+		if rrule != "" {
+			loc, err := h.userLocation(r.Context(), userID)
+			if err != nil {
+				return err
+			}
+			start = internal.TodayIn(loc)
+		}
+
+		if err := h.service.SetHabitRecurrence(r.Context(), userID, id, rrule, start); err != nil {
+			return newInternalServerErr("could not set recurrence rule").Wrap(err)
+		}
+
+		if habit, err = h.service.Habit(r.Context(), userID, id); err != nil {
+			return newInternalServerErr("could not load habit").Wrap(err)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, habit)
+	return nil
+}
+
+func (h *habitz) removeHabit(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	id, err := habitIDParam(r)
+	if err != nil {
+		return err
+	}
+
+	err = h.service.RemoveHabit(r.Context(), userID, id)
+	if err == internal.ErrNotFound {
+		return newNotFoundErr("habit not found")
+	}
+	if err != nil {
+		return newInternalServerErr("could not remove habit").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+	return nil
+}
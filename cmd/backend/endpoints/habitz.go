@@ -1,9 +1,10 @@
 package endpoints
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -16,27 +17,50 @@ type habitz struct {
 	DefaultEndpoint
 	service     internal.HabitzServicer
 	authService auth.JWTServicer
+	timeout     time.Duration
 }
 
-func NewHabitzEndpoint(hs internal.HabitzServicer, js auth.JWTServicer) EndpointRouter {
+// Imports are all or nothing and respond when they are done, large ones need more time
+const importTimeout = 5 * time.Minute
+
+// NewHabitzEndpoint serves the habitz of the user, requests are cancelled after `timeout`.
+// Exports have no deadline, cancelling them halfway would leave the client with a truncated file.
+func NewHabitzEndpoint(hs internal.HabitzServicer, js auth.JWTServicer, timeout time.Duration) EndpointRouter {
 	return &habitz{
 		service:     hs,
 		authService: js,
+		timeout:     timeout,
 	}
 }
 
 type habitState struct {
 	// UserID   string                   `json:"user_id"`
-	TypeName string                   `json:"type_name"`
-	Habitz   []*repository.HabitEntry `json:"habitz"`
+	typeGroup
+	Habitz []*repository.HabitEntry `json:"habitz"`
 }
 
 func (h *habitz) Routes() chi.Router {
 	router := NewRouter()
 
-	router.Use(JWTValidation(h.authService))
-	router.Route("/", func(r chi.Router) {
+	router.Use(JWTValidation(h.authService, h.service))
+	router.Group(func(r chi.Router) {
+		r.Use(RequestTimeout(h.timeout))
+
 		r.Get("/users", ErrorHandler(h.loadUsers))
+		r.Get("/me", ErrorHandler(h.loadMe))
+		r.Patch("/me", ErrorHandler(h.updateMe))
+		r.Delete("/me", ErrorHandler(h.removeMe))
+
+		r.Get("/habits", ErrorHandler(h.loadHabits))
+		r.Post("/habits", ErrorHandler(h.createHabit))
+		r.Get("/habits/{habitID}", ErrorHandler(h.loadHabit))
+		r.Patch("/habits/{habitID}", ErrorHandler(h.updateHabit))
+		r.Delete("/habits/{habitID}", ErrorHandler(h.removeHabit))
+
+		r.Get("/types", ErrorHandler(h.loadHabitTypes))
+		r.Post("/types", ErrorHandler(h.createHabitType))
+		r.Patch("/types/{typeID}", ErrorHandler(h.updateHabitType))
+		r.Delete("/types/{typeID}", ErrorHandler(h.removeHabitType))
 
 		r.Get("/schedule", ErrorHandler(h.loadHabitTemplates))
 		r.Post("/schedule", ErrorHandler(h.createHabitTemplate))
@@ -44,13 +68,32 @@ func (h *habitz) Routes() chi.Router {
 
 		r.Get("/today", ErrorHandler(h.loadTodaysHabitz))
 		r.Patch("/today", ErrorHandler(h.updateTodaysHabitz))
+
+		r.Get("/streaks", ErrorHandler(h.loadStreaks))
+		r.Get("/history", ErrorHandler(h.loadHistory))
+		r.Get("/stats", ErrorHandler(h.loadStats))
 	})
 
+	// The status of an export is sent before the entries are read, a deadline would truncate it
+	router.Get("/export", ErrorHandler(h.export))
+	router.With(RequestTimeout(importTimeout)).Post("/import", ErrorHandler(h.importData))
+
 	return router
 }
 
+// loadUsers lists the first names of all users, for admins only
 func (h *habitz) loadUsers(w http.ResponseWriter, r *http.Request) error {
-	users, err := h.service.Users()
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	me, err := h.service.User(r.Context(), userID)
+	if err != nil {
+		return newInternalServerErr("could not load user").Wrap(err)
+	}
+	if me == nil || !me.Admin {
+		return newForbiddenErr("only admins can list users")
+	}
+
+	users, err := h.service.Users(r.Context())
 	if err != nil {
 		return newInternalServerErr("could not load users").Wrap(err)
 	}
@@ -58,28 +101,103 @@ func (h *habitz) loadUsers(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// userLocation is the timezone of the user, used to decide what day it is
+func (h *habitz) userLocation(ctx context.Context, userID string) (*time.Location, error) {
+	user, err := h.service.User(ctx, userID)
+	if err != nil {
+		return nil, newInternalServerErr("could not load user").Wrap(err)
+	}
+
+	if user == nil {
+		return time.UTC, nil
+	}
+	return internal.Location(user.Timezone), nil
+}
+
 func (h *habitz) createHabitTemplate(w http.ResponseWriter, r *http.Request) error {
 
 	// firstname := r.Context().Value(ContextFirstnameKey).(string)
 	userID := r.Context().Value(ContextUserIDKey).(string)
 
-	ht := repository.WeekHabitTemplates{}
+	// Habitz are scheduled on weekdays or with a recurrence rule
+	ht := struct {
+		repository.WeekHabitTemplates
+		RRule *string `json:"rrule"`
+	}{}
 	if err := json.NewDecoder(r.Body).Decode(&ht); err != nil {
 		return newBadRequestErr("invalid input").Wrap(err)
 	}
 
-	thisWeekday := internal.Weekday()
+	var rrule string
+	if ht.RRule != nil {
+		if len(ht.Weekdays) > 0 {
+			return newBadRequestErr("use either weekdays or rrule")
+		}
+
+		rule, err := parseRecurrence(*ht.RRule)
+		if err != nil {
+			return err
+		}
+		rrule = rule
+	}
+
+	var typeID *int
+	if ht.TypeID != nil {
+		validID, err := h.validHabitType(r.Context(), userID, *ht.TypeID)
+		if err != nil {
+			return err
+		}
+		typeID = validID
+	}
+
+	habit, err := h.resolveHabit(r.Context(), userID, ht.HabitID, ht.Habit, true)
+	if err != nil {
+		return err
+	}
+
+	if ht.TypeID != nil {
+		if err := h.service.SetHabitType(r.Context(), userID, habit.ID, typeID); err != nil {
+			return newInternalServerErr("could not set habit type").Wrap(err)
+		}
+	}
+
+	loc, err := h.userLocation(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	today := internal.TodayIn(loc)
+	thisWeekday := internal.WeekdayIn(loc)
+
+	if rrule != "" {
+		// The rule counts from today and replaces any weekday templates
+		if err := h.service.SetHabitRecurrence(r.Context(), userID, habit.ID, rrule, today); err != nil {
+			return newInternalServerErr("could not set recurrence rule").Wrap(err)
+		}
+
+		// Ignore this error, less important
+		internal.CreateDailyEntries(r.Context(), h.service, userID, today)
+
+		writeJSON(w, http.StatusCreated, nil)
+		return nil
+	}
+
+	// Weekdays replace a recurrence rule
+	if habit.Recurrence != "" {
+		if err := h.service.SetHabitRecurrence(r.Context(), userID, habit.ID, "", ""); err != nil {
+			return newInternalServerErr("could not remove recurrence rule").Wrap(err)
+		}
+	}
 
 	// Create Habit template
 	for _, weekday := range ht.Weekdays {
-		if err := h.service.CreateTemplate(userID, weekday, ht.Habit); err != nil {
+		if err := h.service.CreateTemplate(r.Context(), userID, weekday, habit.ID); err != nil {
 			return newInternalServerErr("could not create template").Wrap(err)
 		}
 
 		// If we're adding a habit for today, make sure we use it today!
 		if weekday == thisWeekday {
 			// Ignore this error, less important
-			h.service.CreateHabitEntry(userID, weekday, ht.Habit)
+			h.service.EnsureHabitEntries(r.Context(), userID, today, []int{habit.ID})
 		}
 	}
 
@@ -88,22 +206,49 @@ func (h *habitz) createHabitTemplate(w http.ResponseWriter, r *http.Request) err
 }
 
 func (h *habitz) deleteHabit(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
 
 	ht := repository.WeekdayHabitTemplate{}
 	if err := json.NewDecoder(r.Body).Decode(&ht); err != nil {
 		return newBadRequestErr("invalid input").Wrap(err)
 	}
 
-	if err := h.service.RemoveTemplate(ht.UserID, ht.Weekday, ht.Habit); err != nil {
-		return newInternalServerErr("could not remove template").Wrap(err)
+	// Only the callers own habitz, the user in the body is ignored
+	habit, err := h.resolveHabit(r.Context(), userID, ht.HabitID, ht.Habit, false)
+	if err != nil {
+		return err
+	}
+
+	loc, err := h.userLocation(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	// Habitz with a recurrence rule have no weekday templates, the whole rule is removed
+	if habit.Recurrence != "" {
+		if err := h.service.SetHabitRecurrence(r.Context(), userID, habit.ID, "", ""); err != nil {
+			return newInternalServerErr("could not remove recurrence rule").Wrap(err)
+		}
+
+		// Ignore this error, less important
+		h.service.RemoveEntry(r.Context(), userID, habit.ID, internal.TodayIn(loc))
+
+		writeJSON(w, http.StatusOK, nil)
+		return nil
 	}
 
-	weekday := internal.Weekday()
+	err = h.service.RemoveTemplate(r.Context(), userID, ht.Weekday, habit.ID)
+	if err == internal.ErrNotFound {
+		return newNotFoundErr("habit is not scheduled on " + ht.Weekday)
+	}
+	if err != nil {
+		return newInternalServerErr("could not remove template").Wrap(err)
+	}
 
 	// If we're removing todays Habit
 	// Also delete todays entry
-	if weekday == ht.Weekday {
-		h.service.RemoveEntry(ht.UserID, ht.Habit, time.Now())
+	if internal.WeekdayIn(loc) == ht.Weekday {
+		h.service.RemoveEntry(r.Context(), userID, habit.ID, internal.TodayIn(loc))
 	}
 
 	writeJSON(w, http.StatusOK, nil)
@@ -115,9 +260,14 @@ func (h *habitz) loadTodaysHabitz(w http.ResponseWriter, r *http.Request) error
 	// firstname := r.Context().Value(ContextFirstnameKey).(string)
 	userID := r.Context().Value(ContextUserIDKey).(string)
 
+	loc, err := h.userLocation(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
 	// What day is it?
-	today := internal.Today()
-	weekday := internal.Weekday()
+	today := internal.TodayIn(loc)
+	weekday := internal.WeekdayIn(loc)
 
 	response := struct {
 		UserID     string       `json:"user_id"`
@@ -129,48 +279,33 @@ func (h *habitz) loadTodaysHabitz(w http.ResponseWriter, r *http.Request) error
 		Weekday:    weekday,
 		TodaysDate: today,
 	}
-	daily := []habitState{}
-
-	// We can show multiple habitz per day
-	// for multiple types of habitz.
-	// For now it's just a single habit type
-	// called `dafault`
-	allTypes := []string{"default"}
 
-	// Try to retrive todays habitz for all users
-	for _, habitType := range allTypes {
-		habitz, err := h.service.HabitEntries(userID, today)
-		if err != nil {
-			return newInternalServerErr("could not load habitz for today").Wrap(err)
-		}
-
-		// Todays entries might not have been created yet, lets create them
-		if len(habitz) == 0 {
-			log.Println("No entries for today, lets create them")
+	types, err := h.service.HabitTypes(r.Context(), userID)
+	if err != nil {
+		return newInternalServerErr("could not load habit types").Wrap(err)
+	}
 
-			habitz = []*repository.HabitEntry{}
-			templates, err := h.service.WeekdayTemplates(userID, weekday)
-			if err != nil {
-				return newInternalServerErr("could not load templates for today").Wrap(err)
-			}
+	// Todays entries might not have been created yet, the scheduler runs after midnight
+	habitz, err := internal.CreateDailyEntries(r.Context(), h.service, userID, today)
+	if err != nil {
+		return newInternalServerErr("could not load habitz for today").Wrap(err)
+	}
 
-			for _, t := range templates {
-				entry, err := h.service.CreateHabitEntry(userID, t.Weekday, t.Habit)
-				if err != nil {
-					return newInternalServerErr("could not create habit entry for today").Wrap(err)
-				}
-				habitz = append(habitz, entry)
-			}
-		}
+	// We show habitz grouped by their type, only types with habitz today
+	groups, groupIndex := habitTypeGroups(types)
+	grouped := make([][]*repository.HabitEntry, len(groups))
+	for _, entry := range habitz {
+		idx := groupIndex(entry.TypeID)
+		grouped[idx] = append(grouped[idx], entry)
+	}
 
-		if len(habitz) > 0 {
-			userHabitz := habitState{
-				TypeName: habitType,
-				Habitz:   habitz,
-			}
-			daily = append(daily, userHabitz)
+	daily := []habitState{}
+	for idx, group := range groups {
+		if len(grouped[idx]) > 0 {
+			daily = append(daily, habitState{typeGroup: group, Habitz: grouped[idx]})
 		}
 	}
+
 	response.Daily = daily
 	writeJSON(w, http.StatusOK, &response)
 	return nil
@@ -181,34 +316,99 @@ type wd struct {
 	Enabled bool   `json:"enabled"`
 }
 type habit struct {
+	HabitID  int    `json:"habit_id"`
 	Habit    string `json:"habit"`
 	Weekdays []*wd  `json:"weekdays"`
+	RRule    string `json:"rrule,omitempty"`
+	Due      bool   `json:"due"`
 }
 
 func (h *habitz) loadHabitTemplates(w http.ResponseWriter, r *http.Request) error {
 	// firstname := r.Context().Value(ContextFirstnameKey).(string)
 	userID := r.Context().Value(ContextUserIDKey).(string)
 
-	userHabitz, err := h.service.Templates(userID)
+	loc, err := h.userLocation(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	// Which habitz are due is decided for a date, today by default
+	date := internal.TodayIn(loc)
+	if v := r.URL.Query().Get("date"); v != "" {
+		if _, err := time.Parse(internal.ShortDateFormat, v); err != nil {
+			return newBadRequestErr("invalid 'date', expected YYYY-MM-DD").Wrap(err)
+		}
+		date = v
+	}
+
+	userHabitz, err := h.service.Templates(r.Context(), userID)
 	if err != nil {
 		return newInternalServerErr("could not find user schedule").Wrap(err)
 	}
 
+	habits, err := h.service.Habits(r.Context(), userID, false)
+	if err != nil {
+		return newInternalServerErr("could not load habitz").Wrap(err)
+	}
+
+	types, err := h.service.HabitTypes(r.Context(), userID)
+	if err != nil {
+		return newInternalServerErr("could not load habit types").Wrap(err)
+	}
+
+	dueHabits, err := internal.DueHabits(r.Context(), h.service, userID, date)
+	if err != nil {
+		return newInternalServerErr("could not evaluate schedule").Wrap(err)
+	}
+
+	due := map[int]bool{}
+	for _, habitID := range dueHabits {
+		due[habitID] = true
+	}
+
+	// Habitz with a recurrence rule have no templates, they go last
+	rules := map[int]string{}
+	for _, hb := range habits {
+		rules[hb.ID] = hb.Recurrence
+		if hb.Recurrence != "" {
+			userHabitz = append(userHabitz, &repository.WeekHabitTemplates{
+				UserID:  userID,
+				HabitID: hb.ID,
+				Habit:   hb.Name,
+				TypeID:  hb.TypeID,
+			})
+		}
+	}
+
+	type typeSchedule struct {
+		typeGroup
+		Habitz []*habit `json:"habitz"`
+	}
+
 	weekdays := []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
 	schedule := struct {
-		UserID   string   `json:"user_id"`
-		TypeName string   `json:"type_name"`
-		Habitz   []*habit `json:"habitz"`
+		UserID string          `json:"user_id"`
+		Date   string          `json:"date"`
+		Types  []*typeSchedule `json:"types"`
 	}{
-		UserID:   userID,
-		TypeName: "default", // TODO: allow multiple types: health, etc
+		UserID: userID,
+		Date:   date,
+	}
+
+	// All types are listed, even without habitz, so they can be scheduled
+	groups, groupIndex := habitTypeGroups(types)
+	typeSchedules := make([]*typeSchedule, len(groups))
+	for idx, group := range groups {
+		typeSchedules[idx] = &typeSchedule{typeGroup: group, Habitz: []*habit{}}
 	}
 
-	habitz := []*habit{}
 	for _, uh := range userHabitz {
 		s := habit{
+			HabitID:  uh.HabitID,
 			Habit:    uh.Habit,
 			Weekdays: make([]*wd, 7),
+			RRule:    rules[uh.HabitID],
+			Due:      due[uh.HabitID],
 		}
 
 		// Remove need to search array
@@ -222,28 +422,258 @@ func (h *habitz) loadHabitTemplates(w http.ResponseWriter, r *http.Request) erro
 			_, enabled := enabledDays[day]
 			s.Weekdays[idx] = &wd{Day: day, Enabled: enabled}
 		}
-		habitz = append(habitz, &s)
+		idx := groupIndex(uh.TypeID)
+		typeSchedules[idx].Habitz = append(typeSchedules[idx].Habitz, &s)
 	}
-	schedule.Habitz = habitz
+	schedule.Types = typeSchedules
 	writeJSON(w, http.StatusOK, schedule)
 	return nil
 }
 
+// entryUpdate is the change of a single entry, clients can send back the entries from `GET /today`.
+// Quantitative habitz are updated with `increment` or `value`, other habitz with `complete`.
+// The kind of the stored habit decides, a `kind` sent by the client is ignored.
+type entryUpdate struct {
+	ID        int      `json:"id"`
+	Complete  *bool    `json:"complete"`
+	Value     *float64 `json:"value"`
+	Increment *float64 `json:"increment"`
+}
+
+// validate rejects updates that can't be applied. Negative increments are fine,
+// they undo a step, and the value never goes below 0.
+func (e *entryUpdate) validate() error {
+	if e.Value != nil && e.Increment != nil {
+		return newBadRequestErr("entry " + strconv.Itoa(e.ID) + ": send either 'value' or 'increment'")
+	}
+	if e.Value != nil && *e.Value < 0 {
+		return newBadRequestErr("entry " + strconv.Itoa(e.ID) + ": 'value' can't be negative")
+	}
+	return nil
+}
+
 func (h *habitz) updateTodaysHabitz(w http.ResponseWriter, r *http.Request) error {
-	hh := []habitState{}
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	hh := []struct {
+		Habitz []*entryUpdate `json:"habitz"`
+	}{}
 	if err := json.NewDecoder(r.Body).Decode(&hh); err != nil {
 		return newBadRequestErr("invalid input").Wrap(err)
 	}
 
+	// Nothing is changed unless every update is valid and every entry belongs to the user
+	updates := []*entryUpdate{}
+	kinds := map[int]string{}
 	for _, userEntries := range hh {
-		for _, entry := range userEntries.Habitz {
-			_, err := h.service.UpdateHabitEntry(entry.ID, entry.Complete)
+		for _, update := range userEntries.Habitz {
+			if err := update.validate(); err != nil {
+				return err
+			}
+
+			// Entries of other users are not found
+			entry, err := h.service.HabitEntry(r.Context(), userID, update.ID)
 			if err != nil {
-				return newInternalServerErr("could not update habit entry").Wrap(err)
+				return newInternalServerErr("could not load habit entry").Wrap(err)
+			}
+			if entry == nil {
+				return newNotFoundErr("habit entry " + strconv.Itoa(update.ID) + " not found")
 			}
+
+			updates = append(updates, update)
+			kinds[entry.ID] = entry.Kind
+		}
+	}
+
+	for _, update := range updates {
+		var err error
+
+		switch {
+		case update.Increment != nil:
+			_, err = h.service.IncrementHabitEntry(r.Context(), userID, update.ID, *update.Increment)
+		case update.Value != nil && (update.Complete == nil || kinds[update.ID] == repository.HabitKindQuantity):
+			_, err = h.service.SetHabitEntryValue(r.Context(), userID, update.ID, *update.Value)
+		case update.Complete != nil:
+			_, err = h.service.UpdateHabitEntry(r.Context(), userID, update.ID, *update.Complete)
+		}
+
+		// Removed since it was loaded
+		if err == internal.ErrNotFound {
+			return newNotFoundErr("habit entry " + strconv.Itoa(update.ID) + " not found")
+		}
+		if err != nil {
+			return newInternalServerErr("could not update habit entry").Wrap(err)
 		}
 	}
 
 	writeJSON(w, http.StatusOK, nil)
 	return nil
 }
+
+func (h *habitz) loadStreaks(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	loc, err := h.userLocation(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	streaks, err := internal.LoadHabitStreaks(r.Context(), h.service, userID, internal.TodayIn(loc))
+	if err != nil {
+		return newInternalServerErr("could not calculate streaks").Wrap(err)
+	}
+
+	response := struct {
+		UserID  string                    `json:"user_id"`
+		Streaks []*repository.HabitStreak `json:"streaks"`
+	}{
+		UserID:  userID,
+		Streaks: streaks,
+	}
+
+	writeJSON(w, http.StatusOK, &response)
+	return nil
+}
+
+const (
+	defaultHistoryDays  = 30
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+func (h *habitz) loadHistory(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+	query := r.URL.Query()
+
+	loc, err := h.userLocation(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	// Default to the last 30 days
+	to, _ := time.Parse(internal.ShortDateFormat, internal.TodayIn(loc))
+	if v := query.Get("to"); v != "" {
+		d, err := time.Parse(internal.ShortDateFormat, v)
+		if err != nil {
+			return newBadRequestErr("invalid 'to' date, expected YYYY-MM-DD").Wrap(err)
+		}
+		to = d
+	}
+
+	from := to.AddDate(0, 0, -defaultHistoryDays)
+	if v := query.Get("from"); v != "" {
+		d, err := time.Parse(internal.ShortDateFormat, v)
+		if err != nil {
+			return newBadRequestErr("invalid 'from' date, expected YYYY-MM-DD").Wrap(err)
+		}
+		from = d
+	}
+
+	if from.After(to) {
+		return newBadRequestErr("'from' must be before 'to'")
+	}
+
+	limit, err := intQueryParam(r, "limit", defaultHistoryLimit)
+	if err != nil {
+		return err
+	}
+	if limit < 1 || limit > maxHistoryLimit {
+		return newBadRequestErr("'limit' must be between 1 and " + strconv.Itoa(maxHistoryLimit))
+	}
+
+	offset, err := intQueryParam(r, "offset", 0)
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		return newBadRequestErr("'offset' can't be negative")
+	}
+
+	// One more than the limit tells if there is a next page
+	entries, err := h.service.HabitEntriesBetween(r.Context(), userID, internal.ShortDate(from), internal.ShortDate(to), limit+1, offset)
+	if err != nil {
+		return newInternalServerErr("could not load history").Wrap(err)
+	}
+
+	more := len(entries) > limit
+	if more {
+		entries = entries[:limit]
+	}
+
+	response := struct {
+		UserID     string                   `json:"user_id"`
+		From       string                   `json:"from"`
+		To         string                   `json:"to"`
+		Limit      int                      `json:"limit"`
+		Offset     int                      `json:"offset"`
+		NextOffset *int                     `json:"next_offset,omitempty"`
+		Entries    []*repository.HabitEntry `json:"entries"`
+	}{
+		UserID:  userID,
+		From:    internal.ShortDate(from),
+		To:      internal.ShortDate(to),
+		Limit:   limit,
+		Offset:  offset,
+		Entries: entries,
+	}
+
+	if more {
+		next := offset + limit
+		response.NextOffset = &next
+	}
+
+	writeJSON(w, http.StatusOK, &response)
+	return nil
+}
+
+// statsWindows maps a named window to its start, relative to today
+var statsWindows = map[string]func(today time.Time) time.Time{
+	"week":  func(today time.Time) time.Time { return today.AddDate(0, 0, -6) },
+	"month": func(today time.Time) time.Time { return today.AddDate(0, -1, 1) },
+	"year":  func(today time.Time) time.Time { return today.AddDate(-1, 0, 1) },
+}
+
+func (h *habitz) loadStats(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = "week"
+	}
+
+	windowStart, ok := statsWindows[window]
+	if !ok {
+		return newBadRequestErr("invalid 'window', expected week, month or year")
+	}
+
+	loc, err := h.userLocation(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	todaysDate := internal.TodayIn(loc)
+	today, _ := time.Parse(internal.ShortDateFormat, todaysDate)
+	from := internal.ShortDate(windowStart(today))
+
+	stats, err := internal.LoadHabitStats(r.Context(), h.service, userID, from, todaysDate, loc)
+	if err != nil {
+		return newInternalServerErr("could not load stats").Wrap(err)
+	}
+
+	response := struct {
+		UserID string                   `json:"user_id"`
+		Window string                   `json:"window"`
+		From   string                   `json:"from"`
+		To     string                   `json:"to"`
+		Stats  []*repository.HabitStats `json:"stats"`
+	}{
+		UserID: userID,
+		Window: window,
+		From:   from,
+		To:     todaysDate,
+		Stats:  stats,
+	}
+
+	writeJSON(w, http.StatusOK, &response)
+	return nil
+}
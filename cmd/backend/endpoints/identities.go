@@ -0,0 +1,152 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/auth"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// identitiesEndpoint manages the ways a signed in user can log in
+type identitiesEndpoint struct {
+	DefaultEndpoint
+	service     internal.HabitzServicer
+	authService auth.JWTServicer
+	providers   map[string]auth.IdentityProvider
+}
+
+func NewIdentitiesEndpoint(hs internal.HabitzServicer, js auth.JWTServicer, providers []auth.IdentityProvider) EndpointRouter {
+	byName := map[string]auth.IdentityProvider{}
+	for _, p := range providers {
+		byName[p.Name()] = p
+	}
+
+	return &identitiesEndpoint{
+		service:     hs,
+		authService: js,
+		providers:   byName,
+	}
+}
+
+func (i *identitiesEndpoint) Routes() chi.Router {
+	router := NewRouter()
+
+	router.Use(JWTValidation(i.authService, i.service))
+	router.Route("/", func(r chi.Router) {
+		r.Get("/", ErrorHandler(i.loadIdentities))
+		r.Post("/{provider}", ErrorHandler(i.linkIdentity))
+		r.Delete("/{provider}/{externalID}", ErrorHandler(i.unlinkIdentity))
+	})
+
+	return router
+}
+
+func (i *identitiesEndpoint) loadIdentities(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	identities, err := i.service.Identities(r.Context(), userID)
+	if err != nil {
+		return newInternalServerErr("could not load identities").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusOK, &identities)
+	return nil
+}
+
+// linkIdentity adds a provider to the account, using a credential from that provider
+// just like at login. The "local" provider adds an email and password instead.
+func (i *identitiesEndpoint) linkIdentity(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+	providerName := chi.URLParam(r, "provider")
+
+	input := struct {
+		credentials
+		Token string `json:"token"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
+
+	if providerName == repository.ProviderLocal {
+		return i.linkLocalCredentials(r.Context(), w, userID, input.credentials)
+	}
+
+	provider, ok := i.providers[providerName]
+	if !ok {
+		return newNotFoundErr("unknown auth provider")
+	}
+
+	identity, err := provider.Authenticate(input.Token)
+	if err != nil {
+		return newBadRequestErr("could not validate " + provider.Name() + " token").Wrap(err)
+	}
+
+	err = i.service.LinkIdentity(r.Context(), userID, identity)
+	if err == internal.ErrAlreadyExists {
+		return newConflictErr("identity is already linked to an account")
+	}
+	if err != nil {
+		return newInternalServerErr("could not link identity").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusCreated, &repository.Identity{
+		Provider:   identity.Provider,
+		ExternalID: identity.ExternalID,
+		Email:      identity.Email,
+	})
+	return nil
+}
+
+func (i *identitiesEndpoint) linkLocalCredentials(ctx context.Context, w http.ResponseWriter, userID string, input credentials) error {
+	email, err := mail.ParseAddress(strings.TrimSpace(input.Email))
+	if err != nil || email.Name != "" {
+		return newBadRequestErr("invalid email")
+	}
+
+	hash, err := auth.HashPassword(input.Password)
+	if err == auth.ErrWeakPassword {
+		return newBadRequestErr(err.Error())
+	}
+	if err != nil {
+		return newInternalServerErr("could not hash password").Wrap(err)
+	}
+
+	err = i.service.CreateLocalCredentials(ctx, userID, email.Address, hash)
+	if err == internal.ErrAlreadyExists {
+		return newConflictErr("the email is taken or the account has a password already")
+	}
+	if err != nil {
+		return newInternalServerErr("could not add password").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusCreated, &repository.Identity{
+		Provider:   repository.ProviderLocal,
+		ExternalID: email.Address,
+		Email:      email.Address,
+	})
+	return nil
+}
+
+func (i *identitiesEndpoint) unlinkIdentity(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	err := i.service.UnlinkIdentity(r.Context(), userID, chi.URLParam(r, "provider"), chi.URLParam(r, "externalID"))
+	if err == internal.ErrNotFound {
+		return newNotFoundErr("identity not found")
+	}
+	if err == internal.ErrLastIdentity {
+		return newConflictErr("can't remove the last way to log in")
+	}
+	if err != nil {
+		return newInternalServerErr("could not unlink identity").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+	return nil
+}
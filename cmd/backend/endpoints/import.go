@@ -0,0 +1,55 @@
+package endpoints
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/jfernstad/habitz/web/internal/importer"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// importData adds habitz and entries from an export, of Habitz or another tracker.
+// Nothing is stored with ?dry_run=true, the response tells what would be created.
+func (h *habitz) importData(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	dryRun := false
+	if param := r.URL.Query().Get("dry_run"); param != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(param); err != nil {
+			return newBadRequestErr("invalid dry_run").Wrap(err)
+		}
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, importer.MaxFileSize))
+	if err != nil {
+		return newBadRequestErr("could not read import, max size is 10 MB").Wrap(err)
+	}
+
+	var data *repository.HabitImport
+	switch r.URL.Query().Get("format") {
+	case "", importer.FormatHabitz:
+		data, err = importer.ParseHabitz(bytes.NewReader(body))
+	case importer.FormatLoop:
+		data, err = importer.ParseLoop(body)
+	default:
+		return newBadRequestErr("format must be habitz or loop")
+	}
+	if err != nil {
+		return newBadRequestErr(err.Error())
+	}
+
+	result, err := h.service.Import(r.Context(), userID, data, dryRun)
+	if err != nil {
+		return newInternalServerErr("could not import").Wrap(err)
+	}
+
+	status := http.StatusCreated
+	if dryRun {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, result)
+	return nil
+}
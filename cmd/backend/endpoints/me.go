@@ -0,0 +1,132 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+const (
+	maxNameLength      = 100
+	maxPreferencesSize = 8 * 1024
+)
+
+func (h *habitz) loadMe(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	me, err := h.service.User(r.Context(), userID)
+	if err != nil {
+		return newInternalServerErr("could not load user").Wrap(err)
+	}
+	if me == nil {
+		return newNotFoundErr("user not found")
+	}
+
+	writeJSON(w, http.StatusOK, me)
+	return nil
+}
+
+// updateMe changes the profile, fields that are left out keep their value
+func (h *habitz) updateMe(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	input := struct {
+		Firstname       *string          `json:"name"`
+		Lastname        *string          `json:"lastname"`
+		ProfileImageURL *string          `json:"profile_image"`
+		Timezone        *string          `json:"timezone"`
+		Preferences     *json.RawMessage `json:"preferences"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
+
+	me, err := h.service.User(r.Context(), userID)
+	if err != nil {
+		return newInternalServerErr("could not load user").Wrap(err)
+	}
+	if me == nil {
+		return newNotFoundErr("user not found")
+	}
+
+	for _, name := range []struct {
+		input *string
+		field *string
+	}{{input.Firstname, &me.Firstname}, {input.Lastname, &me.Lastname}} {
+		if name.input == nil {
+			continue
+		}
+		if utf8.RuneCountInString(strings.TrimSpace(*name.input)) > maxNameLength {
+			return newBadRequestErr("name is too long")
+		}
+		*name.field = strings.TrimSpace(*name.input)
+	}
+
+	if input.ProfileImageURL != nil {
+		if *input.ProfileImageURL != "" {
+			u, err := url.Parse(*input.ProfileImageURL)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return newBadRequestErr("profile_image must be an http(s) URL")
+			}
+		}
+		me.ProfileImageURL = *input.ProfileImageURL
+	}
+
+	if input.Timezone != nil {
+		if !validTimezone(*input.Timezone) {
+			return newBadRequestErr("invalid timezone")
+		}
+		me.Timezone = *input.Timezone
+	}
+
+	if input.Preferences != nil {
+		if len(*input.Preferences) > maxPreferencesSize {
+			return newBadRequestErr("preferences are too large")
+		}
+		object := map[string]interface{}{}
+		if err := json.Unmarshal(*input.Preferences, &object); err != nil {
+			return newBadRequestErr("preferences must be a JSON object").Wrap(err)
+		}
+		me.Preferences = repository.Preferences(*input.Preferences)
+	}
+
+	updated, err := h.service.UpdateUser(r.Context(), me)
+	if err != nil {
+		return newInternalServerErr("could not update user").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+	return nil
+}
+
+// removeMe deletes the account with all habitz, entries, identities and sessions
+func (h *habitz) removeMe(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	err := h.service.RemoveUser(r.Context(), userID)
+	if err == internal.ErrNotFound {
+		return newNotFoundErr("user not found")
+	}
+	if err != nil {
+		return newInternalServerErr("could not remove user").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+	return nil
+}
+
+// validTimezone accepts IANA names only, time.LoadLocation also takes "" and "Local"
+// which would be UTC and the timezone of the server.
+func validTimezone(timezone string) bool {
+	if timezone == "" || timezone == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(timezone)
+	return err == nil
+}
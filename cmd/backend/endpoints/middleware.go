@@ -4,9 +4,11 @@ import (
 	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/middleware"
 	"github.com/jfernstad/habitz/web/internal/auth"
+	"github.com/jfernstad/habitz/web/internal/repository"
 )
 
 type ContextKey string
@@ -14,8 +16,14 @@ type ContextKey string
 const (
 	ContextFirstnameKey ContextKey = "firstname"
 	ContextUserIDKey    ContextKey = "user-id"
+	ContextSessionIDKey ContextKey = "session-id"
 )
 
+// SessionVerifier looks up the login session an access token was issued for
+type SessionVerifier interface {
+	Session(ctx context.Context, id string) (*repository.Session, error)
+}
+
 // ErrorHandler should decorate all HTTP WebserviceHandlers
 // Convenience to convert to httpFunc
 func ErrorHandler(handler WebserviceHandler) http.HandlerFunc {
@@ -24,7 +32,8 @@ func ErrorHandler(handler WebserviceHandler) http.HandlerFunc {
 	}
 }
 
-func JWTValidation(jwtService auth.JWTServicer) func(next http.Handler) http.Handler {
+// JWTValidation accepts requests with a valid access token from a session that hasn't been revoked
+func JWTValidation(jwtService auth.JWTServicer, sessions SessionVerifier) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Read authorization header
@@ -33,12 +42,7 @@ func JWTValidation(jwtService auth.JWTServicer) func(next http.Handler) http.Han
 
 			// Bad authorization
 			if len(splitToken) != 2 {
-				err := newNotAuthenticatedErr("Bearer token missing or malformed")
-				rsp := errHttpResponse{
-					errMsg:    *err,
-					RequestID: middleware.GetReqID(r.Context()),
-				}
-				writeJSON(w, err.HTTPCode, rsp)
+				writeError(w, r, newNotAuthenticatedErr("Bearer token missing or malformed"))
 				return
 			}
 
@@ -48,19 +52,55 @@ func JWTValidation(jwtService auth.JWTServicer) func(next http.Handler) http.Han
 			ok, claims, err := jwtService.VerifyToken(bearerToken)
 			// If bad, return 401
 			if !ok {
-				unauthErr := newNotAuthenticatedErr("could not parse Bearer token").Wrap(err)
-				rsp := errHttpResponse{
-					errMsg:    *unauthErr,
-					RequestID: middleware.GetReqID(r.Context()),
-				}
-				writeJSON(w, unauthErr.HTTPCode, rsp)
+				writeError(w, r, newNotAuthenticatedErr("could not parse Bearer token").Wrap(err))
+				return
+			}
+
+			// Tokens are issued for a session, signing out revokes it
+			if claims.Id == "" {
+				writeError(w, r, newNotAuthenticatedErr("token has no session, sign in again"))
+				return
+			}
+
+			session, err := sessions.Session(r.Context(), claims.Id)
+			if err != nil {
+				writeError(w, r, newInternalServerErr("could not load session").Wrap(err))
+				return
+			}
+			if session == nil || session.RevokedAt != nil || session.UserID != claims.Subject {
+				writeError(w, r, newNotAuthenticatedErr("session has been revoked"))
+				return
+			}
+			if session.ExpiresAt.Before(time.Now()) {
+				writeError(w, r, newNotAuthenticatedErr("session has expired, sign in again"))
 				return
 			}
 
 			// If good, parse token, make variables available in context
 			ctx := context.WithValue(r.Context(), ContextFirstnameKey, claims.Firstname)
 			ctx = context.WithValue(ctx, ContextUserIDKey, claims.Subject)
+			ctx = context.WithValue(ctx, ContextSessionIDKey, claims.Id)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// RequestTimeout gives every request a deadline, queries still running when it passes are cancelled
+func RequestTimeout(timeout time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// writeError responds with the error, for middleware that runs outside the ErrorHandler
+func writeError(w http.ResponseWriter, r *http.Request, err *errMsg) {
+	rsp := errHttpResponse{
+		errMsg:    *err,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	writeJSON(w, err.HTTPCode, rsp)
+}
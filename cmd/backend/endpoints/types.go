@@ -0,0 +1,178 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// Habitz without a type are shown in this group
+const defaultTypeName = "default"
+
+// typeGroup describes the habit type a group of habitz belong to
+type typeGroup struct {
+	TypeID   *int   `json:"type_id,omitempty"`
+	TypeName string `json:"type_name"`
+	Color    string `json:"color,omitempty"`
+}
+
+// habitTypeGroups lists the default group first, followed by the users types in sort order.
+// The returned function maps the type ID of a habit to its index in the list.
+func habitTypeGroups(types []*repository.HabitType) ([]typeGroup, func(typeID *int) int) {
+	groups := []typeGroup{{TypeName: defaultTypeName}}
+	index := map[int]int{}
+
+	for _, t := range types {
+		id := t.ID
+		index[id] = len(groups)
+		groups = append(groups, typeGroup{
+			TypeID:   &id,
+			TypeName: t.Name,
+			Color:    t.Color,
+		})
+	}
+
+	return groups, func(typeID *int) int {
+		if typeID == nil {
+			return 0
+		}
+		return index[*typeID] // Unknown types end up in the default group
+	}
+}
+
+// validHabitType makes sure the type belongs to the user, 0 means no type and returns nil
+func (h *habitz) validHabitType(ctx context.Context, userID string, typeID int) (*int, error) {
+	if typeID == 0 {
+		return nil, nil
+	}
+
+	habitType, err := h.service.HabitType(ctx, userID, typeID)
+	if err != nil {
+		return nil, newInternalServerErr("could not load habit type").Wrap(err)
+	}
+	if habitType == nil {
+		return nil, newNotFoundErr("habit type not found")
+	}
+	return &habitType.ID, nil
+}
+
+func typeIDParam(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "typeID"))
+	if err != nil {
+		return 0, newBadRequestErr("invalid type id").Wrap(err)
+	}
+	return id, nil
+}
+
+func (h *habitz) loadHabitTypes(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	types, err := h.service.HabitTypes(r.Context(), userID)
+	if err != nil {
+		return newInternalServerErr("could not load habit types").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusOK, &types)
+	return nil
+}
+
+func (h *habitz) createHabitType(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	input := repository.HabitType{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		return newMissingParameterErr("name is required")
+	}
+
+	habitType, err := h.service.CreateHabitType(r.Context(), userID, &input)
+	if err == internal.ErrAlreadyExists {
+		return newConflictErr("a habit type with that name already exists")
+	}
+	if err != nil {
+		return newInternalServerErr("could not create habit type").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusCreated, habitType)
+	return nil
+}
+
+func (h *habitz) updateHabitType(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	id, err := typeIDParam(r)
+	if err != nil {
+		return err
+	}
+
+	input := struct {
+		Name      *string `json:"name"`
+		Color     *string `json:"color"`
+		SortOrder *int    `json:"sort_order"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return newBadRequestErr("invalid input").Wrap(err)
+	}
+
+	habitType, err := h.service.HabitType(r.Context(), userID, id)
+	if err != nil {
+		return newInternalServerErr("could not load habit type").Wrap(err)
+	}
+	if habitType == nil {
+		return newNotFoundErr("habit type not found")
+	}
+
+	if input.Name != nil {
+		habitType.Name = strings.TrimSpace(*input.Name)
+		if habitType.Name == "" {
+			return newMissingParameterErr("name can't be empty")
+		}
+	}
+	if input.Color != nil {
+		habitType.Color = *input.Color
+	}
+	if input.SortOrder != nil {
+		habitType.SortOrder = *input.SortOrder
+	}
+
+	habitType, err = h.service.UpdateHabitType(r.Context(), userID, habitType)
+	if err == internal.ErrAlreadyExists {
+		return newConflictErr("a habit type with that name already exists")
+	}
+	if err != nil {
+		return newInternalServerErr("could not update habit type").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusOK, habitType)
+	return nil
+}
+
+func (h *habitz) removeHabitType(w http.ResponseWriter, r *http.Request) error {
+	userID := r.Context().Value(ContextUserIDKey).(string)
+
+	id, err := typeIDParam(r)
+	if err != nil {
+		return err
+	}
+
+	err = h.service.RemoveHabitType(r.Context(), userID, id)
+	if err == internal.ErrNotFound {
+		return newNotFoundErr("habit type not found")
+	}
+	if err != nil {
+		return newInternalServerErr("could not remove habit type").Wrap(err)
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+	return nil
+}
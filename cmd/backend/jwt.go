@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/jfernstad/habitz/web/internal/auth"
+)
+
+// newJWTService picks how tokens are signed from the environment:
+//
+//	JWT_KEYS         comma separated PEM files, RS256 or ES256. The first key signs, the others
+//	                 only verify, e.g. keys rotated out. The file name is the key id (kid).
+//	JWT_SIGNING_KEY  legacy symmetric HS256 key, 32 bytes
+//
+// Without either a key is generated, tokens are then invalid after a restart.
+func newJWTService(keyFiles, signingKey string) (auth.JWTServicer, error) {
+	if keyFiles != "" {
+		keys := []*auth.SigningKey{}
+		for _, file := range strings.Split(keyFiles, ",") {
+			file = strings.TrimSpace(file)
+
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+
+			key, err := auth.ParseKeyPEM(keyID(file), data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			keys = append(keys, key)
+		}
+
+		log.Printf("Signing tokens with %s key %q\n", keys[0].Method.Alg(), keys[0].ID)
+		return auth.NewKeySetJWTService(keys)
+	}
+
+	if signingKey != "" {
+		log.Println("Signing tokens with the symmetric JWT_SIGNING_KEY, consider JWT_KEYS")
+		return auth.NewJWTService([]byte(signingKey)), nil
+	}
+
+	key, err := auth.GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	log.Println("WARNING: neither JWT_KEYS nor JWT_SIGNING_KEY is set, using a generated key. Users are signed out on restart.")
+	return auth.NewKeySetJWTService([]*auth.SigningKey{key})
+}
+
+// keyID is the file name without extensions, e.g. "2024-01" for "/keys/2024-01.pem"
+func keyID(file string) string {
+	name := filepath.Base(file)
+	if idx := strings.Index(name, "."); idx > 0 {
+		name = name[:idx]
+	}
+	return name
+}
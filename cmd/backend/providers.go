@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/jfernstad/habitz/web/internal/auth"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+var providerName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Provider names are part of the /auth routes, these are taken
+var reservedProviderNames = map[string]bool{
+	auth.ProviderGoogle:      true,
+	repository.ProviderLocal: true,
+	"register":               true,
+	"login":                  true,
+	"refresh":                true,
+	"logout":                 true,
+}
+
+// identityProviders configures Google and any OIDC providers from the environment:
+//
+//	GOOGLE_CERTS_URL        optional, where Google publishes its keys
+//	OIDC_PROVIDERS          comma separated provider names, e.g. "keycloak,apple"
+//	OIDC_<NAME>_ISSUER      issuer URL, e.g. https://sso.example.com/realms/habitz
+//	OIDC_<NAME>_CLIENT_ID   the client ID the ID tokens are issued for
+//	OIDC_<NAME>_JWKS_URL    optional, discovered from the issuer if not set
+//
+// Users log in with POST /auth/<name>.
+func identityProviders(googleClientID string, getenv func(string) string) ([]auth.IdentityProvider, error) {
+	providers := []auth.IdentityProvider{auth.NewGoogleProvider(googleClientID, getenv("GOOGLE_CERTS_URL"))}
+
+	names := strings.TrimSpace(getenv("OIDC_PROVIDERS"))
+	if names == "" {
+		return providers, nil
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !providerName.MatchString(name) || reservedProviderNames[name] {
+			return nil, fmt.Errorf("invalid OIDC provider name %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)) + "_"
+		provider, err := auth.NewOIDCProvider(auth.OIDCConfig{
+			Name:     name,
+			Issuer:   getenv(prefix + "ISSUER"),
+			ClientID: getenv(prefix + "CLIENT_ID"),
+			JWKSURL:  getenv(prefix + "JWKS_URL"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		log.Printf("Login with OIDC provider %q\n", name)
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
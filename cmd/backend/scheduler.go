@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal"
+)
+
+const (
+	schedulerInterval = 5 * time.Minute
+	maxBackfillDays   = 31 // Don't fill in more than a month if we've been down for long
+)
+
+// entryScheduler creates the daily habit entries shortly after midnight in each users timezone.
+// Days we missed, e.g. while the server was down, are backfilled. Without it missed days would
+// have no entries at all and couldn't be told apart from unscheduled days.
+type entryScheduler struct {
+	service  internal.HabitzServicer
+	interval time.Duration
+}
+
+func newEntryScheduler(hs internal.HabitzServicer) *entryScheduler {
+	return &entryScheduler{
+		service:  hs,
+		interval: schedulerInterval,
+	}
+}
+
+// Run materializes entries every interval until the context is cancelled
+func (s *entryScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.materialize(ctx, time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *entryScheduler) materialize(ctx context.Context, now time.Time) {
+	users, err := s.service.AllUsers(ctx)
+	if err != nil {
+		log.Println("scheduler: could not load users: ", err)
+		return
+	}
+
+	for _, user := range users {
+		if err := s.materializeUser(ctx, user.ID, now.In(internal.Location(user.Timezone))); err != nil {
+			log.Printf("scheduler: could not create entries for %s: %s\n", user.ID, err)
+		}
+	}
+}
+
+func (s *entryScheduler) materializeUser(ctx context.Context, userID string, localNow time.Time) error {
+	today, _ := time.Parse(internal.ShortDateFormat, localNow.Format(internal.ShortDateFormat))
+
+	last, err := s.service.MaterializedThrough(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// First run for this user, start today
+	from := today
+	if last != "" {
+		lastDate, err := time.Parse(internal.ShortDateFormat, last)
+		if err != nil {
+			return err
+		}
+		from = lastDate.AddDate(0, 0, 1)
+	}
+
+	if earliest := today.AddDate(0, 0, -maxBackfillDays); from.Before(earliest) {
+		from = earliest
+	}
+
+	if from.After(today) {
+		return nil // Already done
+	}
+
+	for d := from; !d.After(today); d = d.AddDate(0, 0, 1) {
+		date := d.Format(internal.ShortDateFormat)
+		if _, err := internal.CreateDailyEntries(ctx, s.service, userID, date); err != nil {
+			return err
+		}
+
+		if err := s.service.SetMaterializedThrough(ctx, userID, date); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("scheduler: created entries for %s from %s to %s\n", userID, from.Format(internal.ShortDateFormat), today.Format(internal.ShortDateFormat))
+	return nil
+}
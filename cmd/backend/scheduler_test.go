@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/mock"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+// newSchedulerUser creates a user with a habit due every day
+func newSchedulerUser(t *testing.T, hs internal.HabitzServicer, email, timezone string) *repository.User {
+	user, err := hs.CreateLocalUser(ctx, &repository.User{Email: email, Firstname: "Tester", Timezone: timezone}, "")
+	assert.Nil(t, err)
+
+	habit, err := hs.CreateHabit(ctx, user.ID, &repository.Habit{Name: "Walk", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+	for _, weekday := range []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"} {
+		assert.Nil(t, hs.CreateTemplate(ctx, user.ID, weekday, habit.ID))
+	}
+
+	return user
+}
+
+func assertMaterialized(t *testing.T, hs internal.HabitzServicer, userID, from, through string, days int) {
+	last, err := hs.MaterializedThrough(ctx, userID)
+	assert.Nil(t, err)
+	assert.Equal(t, through, last)
+
+	entries, err := hs.HabitEntriesBetween(ctx, userID, "2000-01-01", "2100-01-01", 1000, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, entries, days) {
+		assert.Equal(t, from, entries[0].Date)
+		assert.Equal(t, through, entries[len(entries)-1].Date)
+	}
+}
+
+func TestSchedulerFirstRun(t *testing.T) {
+	hs := mock.NewHabitzService()
+	user := newSchedulerUser(t, hs, "first@example.com", "UTC")
+	s := newEntryScheduler(hs)
+
+	now := time.Date(2021, 3, 10, 0, 5, 0, 0, time.UTC)
+	assert.Nil(t, s.materializeUser(ctx, user.ID, now))
+	assertMaterialized(t, hs, user.ID, "2021-03-10", "2021-03-10", 1)
+
+	// Nothing to do until the next day
+	assert.Nil(t, s.materializeUser(ctx, user.ID, now.Add(time.Hour)))
+	assertMaterialized(t, hs, user.ID, "2021-03-10", "2021-03-10", 1)
+}
+
+func TestSchedulerResumes(t *testing.T) {
+	hs := mock.NewHabitzService()
+	user := newSchedulerUser(t, hs, "resume@example.com", "UTC")
+	s := newEntryScheduler(hs)
+
+	assert.Nil(t, hs.SetMaterializedThrough(ctx, user.ID, "2021-03-07"))
+
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	assert.Nil(t, s.materializeUser(ctx, user.ID, now))
+	assertMaterialized(t, hs, user.ID, "2021-03-08", "2021-03-10", 3)
+}
+
+func TestSchedulerBackfillLimit(t *testing.T) {
+	hs := mock.NewHabitzService()
+	user := newSchedulerUser(t, hs, "backfill@example.com", "UTC")
+	s := newEntryScheduler(hs)
+
+	assert.Nil(t, hs.SetMaterializedThrough(ctx, user.ID, "2020-12-01"))
+
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	assert.Nil(t, s.materializeUser(ctx, user.ID, now))
+	assertMaterialized(t, hs, user.ID, "2021-02-07", "2021-03-10", maxBackfillDays+1)
+}
+
+// Each user gets their entries for the day it is where they live
+func TestSchedulerTimezones(t *testing.T) {
+	hs := mock.NewHabitzService()
+	utc := newSchedulerUser(t, hs, "utc@example.com", "UTC")
+	auckland := newSchedulerUser(t, hs, "auckland@example.com", "Pacific/Auckland")
+	honolulu := newSchedulerUser(t, hs, "honolulu@example.com", "Pacific/Honolulu")
+	s := newEntryScheduler(hs)
+
+	// 09:00 on march 2nd in Auckland, 10:00 on march 1st in Honolulu
+	s.materialize(ctx, time.Date(2021, 3, 1, 20, 0, 0, 0, time.UTC))
+
+	assertMaterialized(t, hs, utc.ID, "2021-03-01", "2021-03-01", 1)
+	assertMaterialized(t, hs, auckland.ID, "2021-03-02", "2021-03-02", 1)
+	assertMaterialized(t, hs, honolulu.ID, "2021-03-01", "2021-03-01", 1)
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+const (
+	ProviderGoogle = "google"
+
+	// GoogleCertsURL publishes the keys Google signs ID tokens with
+	GoogleCertsURL = "https://www.googleapis.com/oauth2/v3/certs"
+)
+
+type googleClaims struct {
+	jwt.StandardClaims
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	FirstName     string `json:"given_name"`
+	LastName      string `json:"family_name"`
+	ProfileImage  string `json:"picture"`
+}
+
+type googleProvider struct {
+	audience string
+	keys     *KeyCache
+}
+
+// NewGoogleProvider verifies Google ID tokens issued for our client ID.
+// The keys are fetched from certsURL, GoogleCertsURL if empty.
+func NewGoogleProvider(clientID, certsURL string) IdentityProvider {
+	if certsURL == "" {
+		certsURL = GoogleCertsURL
+	}
+
+	return &googleProvider{
+		audience: clientID, // Verify the incoming JWT token was intended for us
+		keys:     NewKeyCache(certsURL, nil, minKeyRefreshInterval),
+	}
+}
+
+func (g *googleProvider) Name() string {
+	return ProviderGoogle
+}
+
+func (g *googleProvider) Authenticate(credential string) (*repository.ExternalUser, error) {
+	gToken, err := g.parseGoogleJWTToken(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	return &repository.ExternalUser{
+		User: repository.User{
+			Firstname:       gToken.FirstName,
+			Lastname:        gToken.LastName,
+			Email:           gToken.Email,
+			ProfileImageURL: gToken.ProfileImage,
+		},
+		Provider:   ProviderGoogle,
+		ExternalID: gToken.Subject,
+	}, nil
+}
+
+func (g *googleProvider) parseGoogleJWTToken(tokenString string) (*googleClaims, error) {
+	claimsStruct := googleClaims{}
+
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&claimsStruct,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			key, err := g.keys.Key(kid)
+			if err != nil {
+				return nil, err
+			}
+
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
+			return key.Public, nil
+		},
+	)
+	if err != nil {
+		return &googleClaims{}, err
+	}
+
+	claims, ok := token.Claims.(*googleClaims)
+	if !ok {
+		return &googleClaims{}, errors.New("Invalid Google JWT")
+	}
+
+	if claims.Issuer != "accounts.google.com" && claims.Issuer != "https://accounts.google.com" {
+		return &googleClaims{}, errors.New("iss is invalid")
+	}
+
+	if claims.Audience != g.audience {
+		return &googleClaims{}, errors.New("aud is invalid")
+	}
+
+	if claims.ExpiresAt < time.Now().UTC().Unix() {
+		return &googleClaims{}, errors.New("JWT is expired")
+	}
+
+	return claims, nil
+}
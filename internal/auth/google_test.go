@@ -0,0 +1,47 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/jfernstad/habitz/web/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGoogleAuthenticate(t *testing.T) {
+	s := newKeyServer(t, "public, max-age=20000")
+	defer s.server.Close()
+	provider := auth.NewGoogleProvider(testClientID, s.server.URL)
+
+	idToken := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(s.keys[0].Method, claims)
+		token.Header["kid"] = s.keys[0].ID
+		signed, err := token.SignedString(s.keys[0].Private)
+		assert.Nil(t, err)
+		return signed
+	}
+
+	user, err := provider.Authenticate(idToken(jwt.MapClaims{
+		"iss":        "https://accounts.google.com",
+		"aud":        testClientID,
+		"sub":        "1234",
+		"exp":        time.Now().Add(time.Minute).Unix(),
+		"email":      "tester@gmail.com",
+		"given_name": "Tester",
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, auth.ProviderGoogle, user.Provider)
+	assert.Equal(t, "1234", user.ExternalID)
+	assert.Equal(t, "tester@gmail.com", user.Email)
+	assert.Equal(t, "Tester", user.Firstname)
+
+	_, err = provider.Authenticate(idToken(jwt.MapClaims{
+		"iss": "https://accounts.google.com",
+		"aud": "someone-else",
+		"sub": "1234",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}))
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, s.fetches())
+}
@@ -21,12 +21,13 @@ type HabitzJWTClaims struct {
 	// UserID    string `json:"user_id"`
 }
 
-// Lets start with symmetric encryption key
-// We're the only one that care about this JWT token anyway
+// The symmetric HS256 service is kept for existing deployments,
+// see NewKeySetJWTService for asymmetric keys that other services can verify
 
 type JWTServicer interface {
 	NewToken(claims *HabitzJWTClaims, expiration *time.Time) (token string, err error)
 	VerifyToken(tokenString string) (bool, *HabitzJWTClaims, error)
+	JWKS() *JWKSet // Public keys, empty for symmetric keys
 }
 
 type jwtService struct {
@@ -51,9 +52,6 @@ func (j *jwtService) NewToken(claims *HabitzJWTClaims, expiration *time.Time) (s
 		return "", errors.New(fmt.Sprintf("invalid signing key length %d", len(j.signingKey)))
 	}
 
-	// Add Basic information
-	claims.Issuer = HabitzJWTIssuer
-
 	// HS256 - symmetric key used for signing and verifying
 	habitzToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := habitzToken.SignedString(j.signingKey)
@@ -75,6 +73,9 @@ func (j *jwtService) VerifyToken(tokenString string) (bool, *HabitzJWTClaims, er
 		tokenString,
 		claims,
 		func(token *jwt.Token) (interface{}, error) {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
 			return j.signingKey, nil
 		},
 	)
@@ -82,8 +83,16 @@ func (j *jwtService) VerifyToken(tokenString string) (bool, *HabitzJWTClaims, er
 		return false, nil, err
 	}
 
-	var ok bool
-	claims, ok = token.Claims.(*HabitzJWTClaims)
+	return verifyClaims(token)
+}
+
+func (j *jwtService) JWKS() *JWKSet {
+	return &JWKSet{Keys: []JWK{}}
+}
+
+// verifyClaims checks the Habitz claims of a token with a valid signature
+func verifyClaims(token *jwt.Token) (bool, *HabitzJWTClaims, error) {
+	claims, ok := token.Claims.(*HabitzJWTClaims)
 	if !ok {
 		return false, nil, errors.New("invalid Habitz JWT")
 	}
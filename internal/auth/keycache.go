@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keys are cached this long when the JWKS response doesn't have a max-age
+const defaultKeyMaxAge = time.Hour
+
+// KeyCache holds the public keys published at a JWKS URL, e.g. by an identity provider.
+// The keys are fetched again when the Cache-Control max-age has passed, or for an unknown
+// key id, but not more often than the minimum refresh interval. It's safe for concurrent
+// use, and concurrent lookups share a single fetch.
+type KeyCache struct {
+	url        string
+	client     *http.Client
+	minRefresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*SigningKey
+	fetchedAt time.Time
+	expiresAt time.Time
+	inflight  *keyFetch
+}
+
+// keyFetch is a fetch in progress, waiting lookups are released when done is closed
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewKeyCache creates a cache for the keys at url, fetched with client or a default client
+func NewKeyCache(url string, client *http.Client, minRefresh time.Duration) *KeyCache {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &KeyCache{
+		url:        url,
+		client:     client,
+		minRefresh: minRefresh,
+		keys:       map[string]*SigningKey{},
+	}
+}
+
+// Key finds the key with the key id, fetching the keys if needed.
+// Known keys are still used if the keys can't be fetched again.
+func (c *KeyCache) Key(kid string) (*SigningKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	now := time.Now()
+	fresh := now.Before(c.expiresAt)
+
+	if ok && fresh {
+		c.mu.Unlock()
+		return key, nil
+	}
+	if fresh && now.Sub(c.fetchedAt) < c.minRefresh {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("key %q not found", kid)
+	}
+
+	fetch := c.inflight
+	leader := fetch == nil
+	if leader {
+		fetch = &keyFetch{done: make(chan struct{})}
+		c.inflight = fetch
+	}
+	c.mu.Unlock()
+
+	if leader {
+		fetch.err = c.fetch()
+
+		c.mu.Lock()
+		c.inflight = nil
+		c.mu.Unlock()
+		close(fetch.done)
+	} else {
+		<-fetch.done
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if fetch.err != nil {
+		return nil, fetch.err
+	}
+	return nil, fmt.Errorf("key %q not found", kid)
+}
+
+func (c *KeyCache) fetch() error {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", c.url, resp.Status)
+	}
+
+	set := JWKSet{}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := map[string]*SigningKey{}
+	for _, jwk := range set.Keys {
+		public, err := jwk.PublicKey()
+		if err != nil {
+			continue // Skip keys we can't use, e.g. encryption keys
+		}
+
+		key, err := newSigningKey(jwk.KeyID, public)
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.keys = keys
+	c.fetchedAt = now
+	c.expiresAt = now.Add(maxAge(resp.Header.Get("Cache-Control")))
+	return nil
+}
+
+// maxAge reads how long a response may be cached, no-cache and no-store mean not at all
+func maxAge(cacheControl string) time.Duration {
+	if cacheControl == "" {
+		return defaultKeyMaxAge
+	}
+
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds < 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultKeyMaxAge
+}
@@ -0,0 +1,138 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// keyServer is a stub JWKS endpoint
+type keyServer struct {
+	server       *httptest.Server
+	keys         []*auth.SigningKey
+	cacheControl string
+	failing      bool
+	served       int32
+}
+
+func newKeyServer(t *testing.T, cacheControl string) *keyServer {
+	s := &keyServer{cacheControl: cacheControl}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.served, 1)
+		time.Sleep(10 * time.Millisecond) // Let concurrent lookups pile up
+
+		if s.failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		set := auth.JWKSet{}
+		for _, k := range s.keys {
+			set.Keys = append(set.Keys, k.JWK())
+		}
+		w.Header().Set("Cache-Control", s.cacheControl)
+		json.NewEncoder(w).Encode(set)
+	}))
+	s.addKey(t, "key-1")
+	return s
+}
+
+func (s *keyServer) addKey(t *testing.T, kid string) {
+	key, err := auth.ParseKeyPEM(kid, ecKeyPEM(t))
+	assert.Nil(t, err)
+	s.keys = append(s.keys, key)
+}
+
+func (s *keyServer) fetches() int {
+	return int(atomic.LoadInt32(&s.served))
+}
+
+func TestKeyCacheHonorsMaxAge(t *testing.T) {
+	s := newKeyServer(t, "public, max-age=3600")
+	defer s.server.Close()
+	cache := auth.NewKeyCache(s.server.URL, nil, 0)
+
+	for i := 0; i < 3; i++ {
+		key, err := cache.Key("key-1")
+		assert.Nil(t, err)
+		assert.Equal(t, "key-1", key.ID)
+	}
+	assert.Equal(t, 1, s.fetches())
+
+	// Expired keys are fetched again
+	s.cacheControl = "no-cache"
+	other := auth.NewKeyCache(s.server.URL, nil, time.Hour)
+	_, err := other.Key("key-1")
+	assert.Nil(t, err)
+	_, err = other.Key("key-1")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, s.fetches())
+}
+
+func TestKeyCacheSingleFlight(t *testing.T) {
+	s := newKeyServer(t, "max-age=3600")
+	defer s.server.Close()
+	cache := auth.NewKeyCache(s.server.URL, nil, 0)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.Key("key-1")
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, s.fetches())
+}
+
+func TestKeyCacheUnknownKeys(t *testing.T) {
+	s := newKeyServer(t, "max-age=3600")
+	defer s.server.Close()
+
+	// Unknown key ids don't fetch the keys again within the minimum interval
+	limited := auth.NewKeyCache(s.server.URL, nil, time.Hour)
+	_, err := limited.Key("key-1")
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = limited.Key("unknown")
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, 1, s.fetches())
+
+	// A rotated key is found once the interval has passed
+	rotating := auth.NewKeyCache(s.server.URL, nil, 0)
+	_, err = rotating.Key("key-1")
+	assert.Nil(t, err)
+	s.addKey(t, "key-2")
+	key, err := rotating.Key("key-2")
+	assert.Nil(t, err)
+	assert.Equal(t, "key-2", key.ID)
+	assert.Equal(t, 3, s.fetches())
+}
+
+func TestKeyCacheKeepsKeysWhenUnavailable(t *testing.T) {
+	s := newKeyServer(t, "max-age=0")
+	defer s.server.Close()
+	cache := auth.NewKeyCache(s.server.URL, nil, 0)
+
+	_, err := cache.Key("key-1")
+	assert.Nil(t, err)
+
+	s.failing = true
+	key, err := cache.Key("key-1")
+	assert.Nil(t, err)
+	assert.Equal(t, "key-1", key.ID)
+
+	_, err = cache.Key("key-2")
+	assert.NotNil(t, err)
+}
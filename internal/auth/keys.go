@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// SigningKey is an asymmetric key identified by `kid`.
+// Keys without a private part can only verify tokens, e.g. keys that were rotated out.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// ParseKeyPEM reads an RSA (RS256) or P-256 (ES256) key.
+// Private keys can be PKCS#1, SEC 1 or PKCS#8, public keys PKIX.
+func ParseKeyPEM(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(kid, key)
+}
+
+// GenerateSigningKey creates a new ES256 key, used when no keys are configured
+func GenerateSigningKey() (*SigningKey, error) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(fmt.Sprintf("generated-%d", time.Now().Unix()), private)
+}
+
+func newSigningKey(kid string, key interface{}) (*SigningKey, error) {
+	sk := &SigningKey{ID: kid}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sk.Method, sk.Private, sk.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		sk.Method, sk.Public = jwt.SigningMethodRS256, k
+	case *ecdsa.PrivateKey:
+		sk.Method, sk.Private, sk.Public = jwt.SigningMethodES256, k, &k.PublicKey
+	case *ecdsa.PublicKey:
+		sk.Method, sk.Public = jwt.SigningMethodES256, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	if pub, ok := sk.Public.(*ecdsa.PublicKey); ok && pub.Curve != elliptic.P256() {
+		return nil, errors.New("only P-256 EC keys are supported")
+	}
+
+	return sk, nil
+}
+
+// JWK is the public part of a signing key, as published in the JWKS
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK describes the public key
+func (k *SigningKey) JWK() JWK {
+	jwk := JWK{
+		KeyID:     k.ID,
+		Use:       "sig",
+		Algorithm: k.Method.Alg(),
+	}
+
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padded(pub.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padded(pub.Y.Bytes(), size))
+	}
+
+	return jwk
+}
+
+// PublicKey parses the public key of an RSA or P-256 JWK, e.g. from an identity provider
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch j.KeyType {
+	case "RSA":
+		n, err := decode(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		if j.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", j.Curve)
+		}
+		x, err := decode(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", j.KeyType)
+}
+
+// padded left pads coordinates to the size of the curve, as required by RFC 7518
+func padded(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
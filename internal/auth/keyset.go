@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type keySetService struct {
+	signing *SigningKey
+	keys    []*SigningKey
+	byID    map[string]*SigningKey
+}
+
+// NewKeySetJWTService signs tokens with the first key, any of the keys verify them.
+// Rotate by putting a new key first and keeping the old ones until their tokens have expired.
+func NewKeySetJWTService(keys []*SigningKey) (JWTServicer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	if keys[0].Private == nil {
+		return nil, fmt.Errorf("key %q can't sign, the first key must be a private key", keys[0].ID)
+	}
+
+	byID := map[string]*SigningKey{}
+	for _, k := range keys {
+		if _, ok := byID[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		byID[k.ID] = k
+	}
+
+	return &keySetService{
+		signing: keys[0],
+		keys:    keys,
+		byID:    byID,
+	}, nil
+}
+
+func (k *keySetService) NewToken(claims *HabitzJWTClaims, expiration *time.Time) (string, error) {
+	claims.ExpiresAt = expiration.Unix()
+	claims.Issuer = HabitzJWTIssuer
+	claims.Audience = HabitzJWTAudience
+
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+
+	return token.SignedString(k.signing.Private)
+}
+
+func (k *keySetService) VerifyToken(tokenString string) (bool, *HabitzJWTClaims, error) {
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&HabitzJWTClaims{},
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			key, ok := k.byID[kid]
+			if !ok {
+				return nil, fmt.Errorf("unknown key id %q", kid)
+			}
+
+			// The key decides the algorithm, never the token
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
+			return key.Public, nil
+		},
+	)
+	if err != nil {
+		return false, nil, err
+	}
+
+	return verifyClaims(token)
+}
+
+func (k *keySetService) JWKS() *JWKSet {
+	set := &JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		set.Keys = append(set.Keys, key.JWK())
+	}
+	return set
+}
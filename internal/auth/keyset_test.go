@@ -0,0 +1,124 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func rsaKeyPEM(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func ecKeyPEM(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func publicKeyPEM(t *testing.T, key *auth.SigningKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(key.Public)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestKeySetSignAndVerify(t *testing.T) {
+	for alg, data := range map[string][]byte{"RS256": rsaKeyPEM(t), "ES256": ecKeyPEM(t)} {
+		key, err := auth.ParseKeyPEM("key-1", data)
+		assert.Nil(t, err)
+		assert.Equal(t, alg, key.Method.Alg())
+
+		signer, err := auth.NewKeySetJWTService([]*auth.SigningKey{key})
+		assert.Nil(t, err)
+
+		expiration := time.Now().Add(time.Minute)
+		token, err := signer.NewToken(testClaims, &expiration)
+		assert.Nil(t, err)
+
+		ok, claims, err := signer.VerifyToken(token)
+		assert.True(t, ok, alg)
+		assert.Nil(t, err)
+		assert.Equal(t, testClaims.Subject, claims.Subject)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, err := auth.ParseKeyPEM("old", ecKeyPEM(t))
+	assert.Nil(t, err)
+	newKey, err := auth.ParseKeyPEM("new", rsaKeyPEM(t))
+	assert.Nil(t, err)
+
+	before, err := auth.NewKeySetJWTService([]*auth.SigningKey{oldKey})
+	assert.Nil(t, err)
+
+	expiration := time.Now().Add(time.Minute)
+	oldToken, err := before.NewToken(testClaims, &expiration)
+	assert.Nil(t, err)
+
+	// After rotation only the public part of the old key is kept
+	oldPublic, err := auth.ParseKeyPEM("old", publicKeyPEM(t, oldKey))
+	assert.Nil(t, err)
+
+	after, err := auth.NewKeySetJWTService([]*auth.SigningKey{newKey, oldPublic})
+	assert.Nil(t, err)
+
+	ok, _, err := after.VerifyToken(oldToken)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	newToken, err := after.NewToken(testClaims, &expiration)
+	assert.Nil(t, err)
+
+	// Services that haven't seen the new key reject its tokens
+	ok, _, err = before.VerifyToken(newToken)
+	assert.False(t, ok)
+	assert.NotNil(t, err)
+
+	jwks := after.JWKS()
+	assert.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "new", jwks.Keys[0].KeyID)
+	assert.Equal(t, "RSA", jwks.Keys[0].KeyType)
+	assert.NotEmpty(t, jwks.Keys[0].N)
+	assert.Equal(t, "old", jwks.Keys[1].KeyID)
+	assert.Equal(t, "EC", jwks.Keys[1].KeyType)
+	assert.Equal(t, "P-256", jwks.Keys[1].Curve)
+	assert.Len(t, jwks.Keys[1].X, 43) // 32 bytes, base64url without padding
+}
+
+func TestKeySetNeedsPrivateSigningKey(t *testing.T) {
+	key, err := auth.ParseKeyPEM("key", ecKeyPEM(t))
+	assert.Nil(t, err)
+	public, err := auth.ParseKeyPEM("key", publicKeyPEM(t, key))
+	assert.Nil(t, err)
+
+	_, err = auth.NewKeySetJWTService([]*auth.SigningKey{public})
+	assert.NotNil(t, err)
+}
+
+func TestKeySetRejectsSymmetricTokens(t *testing.T) {
+	key, err := auth.GenerateSigningKey()
+	assert.Nil(t, err)
+	verifier, err := auth.NewKeySetJWTService([]*auth.SigningKey{key})
+	assert.Nil(t, err)
+
+	expiration := time.Now().Add(time.Minute)
+	token, err := auth.NewJWTService([]byte(testSecret)).NewToken(testClaims, &expiration)
+	assert.Nil(t, err)
+
+	ok, claims, err := verifier.VerifyToken(token)
+	assert.False(t, ok)
+	assert.Nil(t, claims)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// OIDCConfig describes an OpenID Connect provider, e.g. a self-hosted Keycloak
+type OIDCConfig struct {
+	Name     string // Provider name, stored with the external user
+	Issuer   string // Must match the `iss` of the ID tokens
+	ClientID string // Must be in the `aud` of the ID tokens
+	JWKSURL  string // Optional, discovered from the issuer if empty
+
+	HTTPClient *http.Client
+}
+
+// An unknown key id fetches the keys again, but not more often than this
+const minKeyRefreshInterval = time.Minute
+
+type oidcProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu   sync.Mutex
+	keys *KeyCache // Created once the JWKS URL is known
+}
+
+// NewOIDCProvider verifies ID tokens signed with the providers published keys
+func NewOIDCProvider(config OIDCConfig) (IdentityProvider, error) {
+	if config.Name == "" || config.Issuer == "" || config.ClientID == "" {
+		return nil, errors.New("oidc provider needs a name, issuer and client id")
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	provider := &oidcProvider{
+		config: config,
+		client: client,
+	}
+	if config.JWKSURL != "" {
+		provider.keys = NewKeyCache(config.JWKSURL, client, minKeyRefreshInterval)
+	}
+	return provider, nil
+}
+
+func (o *oidcProvider) Name() string {
+	return o.config.Name
+}
+
+// Authenticate verifies an ID token and maps the standard OIDC claims to a user
+func (o *oidcProvider) Authenticate(credential string) (*repository.ExternalUser, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(credential, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := o.key(kid)
+		if err != nil {
+			return nil, err
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return key.Public, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(o.config.Issuer, true) {
+		return nil, errors.New("iss is invalid")
+	}
+	if !claims.VerifyAudience(o.config.ClientID, true) {
+		return nil, errors.New("aud is invalid")
+	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("JWT is expired")
+	}
+
+	str := func(name string) string {
+		v, _ := claims[name].(string)
+		return v
+	}
+
+	if str("sub") == "" {
+		return nil, errors.New("sub is missing")
+	}
+
+	firstname, lastname := str("given_name"), str("family_name")
+	if firstname == "" && lastname == "" {
+		firstname = str("name")
+	}
+
+	return &repository.ExternalUser{
+		User: repository.User{
+			Firstname:       firstname,
+			Lastname:        lastname,
+			Email:           str("email"),
+			ProfileImageURL: str("picture"),
+		},
+		Provider:   o.config.Name,
+		ExternalID: str("sub"),
+	}, nil
+}
+
+// key finds a signing key of the provider, the JWKS URL is discovered the first time
+func (o *oidcProvider) key(kid string) (*SigningKey, error) {
+	keys, err := o.keyCache()
+	if err != nil {
+		return nil, err
+	}
+	return keys.Key(kid)
+}
+
+func (o *oidcProvider) keyCache() (*KeyCache, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.keys != nil {
+		return o.keys, nil
+	}
+
+	discovery := struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+	url := strings.TrimSuffix(o.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := o.getJSON(url, &discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != o.config.Issuer {
+		return nil, fmt.Errorf("discovered issuer %q doesn't match %q", discovery.Issuer, o.config.Issuer)
+	}
+
+	o.keys = NewKeyCache(discovery.JWKSURI, o.client, minKeyRefreshInterval)
+	return o.keys, nil
+}
+
+func (o *oidcProvider) getJSON(url string, v interface{}) error {
+	resp, err := o.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
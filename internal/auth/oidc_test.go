@@ -0,0 +1,151 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/jfernstad/habitz/web/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+const testClientID = "habitz-test"
+
+// fakeIssuer is a local OIDC provider with discovery and a JWKS
+type fakeIssuer struct {
+	server     *httptest.Server
+	keys       []*auth.SigningKey
+	jwksServed int32
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	f := &fakeIssuer{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   f.server.URL,
+			"jwks_uri": f.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&f.jwksServed, 1)
+		set := auth.JWKSet{}
+		for _, k := range f.keys {
+			set.Keys = append(set.Keys, k.JWK())
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	f.server = httptest.NewServer(mux)
+
+	f.rotate(t)
+	return f
+}
+
+// rotate adds a new signing key, used for the next ID tokens
+func (f *fakeIssuer) rotate(t *testing.T) {
+	key, err := auth.ParseKeyPEM("key-"+string(rune('a'+len(f.keys))), ecKeyPEM(t))
+	assert.Nil(t, err)
+	f.keys = append([]*auth.SigningKey{key}, f.keys...)
+}
+
+func (f *fakeIssuer) idToken(t *testing.T, claims jwt.MapClaims) string {
+	base := jwt.MapClaims{
+		"iss":         f.server.URL,
+		"aud":         testClientID,
+		"sub":         "user-1",
+		"exp":         time.Now().Add(time.Minute).Unix(),
+		"email":       "tester@example.com",
+		"given_name":  "Tester",
+		"family_name": "McTestFace",
+	}
+	for k, v := range claims {
+		base[k] = v
+	}
+
+	token := jwt.NewWithClaims(f.keys[0].Method, base)
+	token.Header["kid"] = f.keys[0].ID
+	signed, err := token.SignedString(f.keys[0].Private)
+	assert.Nil(t, err)
+	return signed
+}
+
+func (f *fakeIssuer) provider(t *testing.T) auth.IdentityProvider {
+	provider, err := auth.NewOIDCProvider(auth.OIDCConfig{
+		Name:     "fake",
+		Issuer:   f.server.URL,
+		ClientID: testClientID,
+	})
+	assert.Nil(t, err)
+	return provider
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+
+	user, err := issuer.provider(t).Authenticate(issuer.idToken(t, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "fake", user.Provider)
+	assert.Equal(t, "user-1", user.ExternalID)
+	assert.Equal(t, "tester@example.com", user.Email)
+	assert.Equal(t, "Tester", user.Firstname)
+	assert.Equal(t, "McTestFace", user.Lastname)
+}
+
+func TestOIDCAudienceList(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+
+	token := issuer.idToken(t, jwt.MapClaims{"aud": []string{"other", testClientID}})
+	_, err := issuer.provider(t).Authenticate(token)
+	assert.Nil(t, err)
+}
+
+func TestOIDCRejectsInvalidTokens(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+	provider := issuer.provider(t)
+
+	for name, claims := range map[string]jwt.MapClaims{
+		"wrong audience": {"aud": "someone-else"},
+		"wrong issuer":   {"iss": "https://evil.example.com"},
+		"expired":        {"exp": time.Now().Add(-time.Minute).Unix()},
+		"no subject":     {"sub": ""},
+	} {
+		_, err := provider.Authenticate(issuer.idToken(t, claims))
+		assert.NotNil(t, err, name)
+	}
+
+	// Signed by someone else, with a key id the issuer doesn't know
+	other := newFakeIssuer(t)
+	defer other.server.Close()
+	_, err := provider.Authenticate(other.idToken(t, jwt.MapClaims{"iss": issuer.server.URL}))
+	assert.NotNil(t, err)
+}
+
+func TestOIDCCachesKeys(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+	provider := issuer.provider(t)
+
+	_, err := provider.Authenticate(issuer.idToken(t, nil))
+	assert.Nil(t, err)
+
+	// Known keys are cached
+	_, err = provider.Authenticate(issuer.idToken(t, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&issuer.jwksServed))
+
+	// Unknown key ids don't fetch the keys for every token
+	issuer.rotate(t)
+	for i := 0; i < 3; i++ {
+		_, err = provider.Authenticate(issuer.idToken(t, nil))
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&issuer.jwksServed))
+}
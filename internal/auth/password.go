@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72 // bcrypt ignores anything longer
+
+	passwordCost = 12
+)
+
+var ErrWeakPassword = errors.New("password must be between 8 and 72 characters")
+
+// Compared against when the account doesn't exist, so logins take the same time either way
+var (
+	dummyPasswordHash []byte
+	dummyPasswordOnce sync.Once
+)
+
+// HashPassword hashes a password with bcrypt for storage
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return "", ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword is true if the password matches the hash.
+// An empty hash, e.g. for an unknown account, never matches but takes as long as a real check.
+func CheckPassword(hash, password string) bool {
+	if hash == "" {
+		dummyPasswordOnce.Do(func() {
+			dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), passwordCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,59 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse battery staple")
+	assert.Nil(t, err)
+	assert.NotContains(t, hash, "correct horse")
+
+	assert.True(t, auth.CheckPassword(hash, "correct horse battery staple"))
+	assert.False(t, auth.CheckPassword(hash, "Correct horse battery staple"))
+	assert.False(t, auth.CheckPassword("", "correct horse battery staple"))
+}
+
+func TestWeakPasswords(t *testing.T) {
+	_, err := auth.HashPassword("short")
+	assert.Equal(t, auth.ErrWeakPassword, err)
+
+	long := make([]byte, auth.MaxPasswordLength+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	_, err = auth.HashPassword(string(long))
+	assert.Equal(t, auth.ErrWeakPassword, err)
+}
+
+func TestLoginLimiter(t *testing.T) {
+	limiter := auth.NewLoginLimiter(2, 100*time.Millisecond)
+
+	limiter.Failure("alice")
+	ok, _ := limiter.Allowed("alice")
+	assert.True(t, ok)
+
+	limiter.Failure("alice")
+	ok, retryAfter := limiter.Allowed("alice")
+	assert.False(t, ok)
+	assert.True(t, retryAfter > 0)
+
+	// Other keys aren't affected
+	ok, _ = limiter.Allowed("bob")
+	assert.True(t, ok)
+
+	// Failures expire after the window
+	time.Sleep(150 * time.Millisecond)
+	ok, _ = limiter.Allowed("alice")
+	assert.True(t, ok)
+
+	limiter.Failure("bob")
+	limiter.Failure("bob")
+	limiter.Reset("bob")
+	ok, _ = limiter.Allowed("bob")
+	assert.True(t, ok)
+}
@@ -0,0 +1,12 @@
+package auth
+
+import (
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// IdentityProvider verifies a credential from an external provider, e.g. an OIDC ID token,
+// and tells who the user is. The result is used to find or create the Habitz user.
+type IdentityProvider interface {
+	Name() string // Used in the login route, /auth/{name}
+	Authenticate(credential string) (*repository.ExternalUser, error)
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Keys that stopped trying are forgotten once this many are tracked
+const maxTrackedKeys = 10000
+
+// LoginLimiter counts failed login attempts per key, e.g. an email or IP address.
+// A key is blocked once it has `max` failures within `window`, until the window has passed.
+type LoginLimiter struct {
+	max    int
+	window time.Duration
+	now    func() time.Time
+
+	mu       sync.Mutex
+	failures map[string][]time.Time
+}
+
+func NewLoginLimiter(max int, window time.Duration) *LoginLimiter {
+	return &LoginLimiter{
+		max:      max,
+		window:   window,
+		now:      time.Now,
+		failures: map[string][]time.Time{},
+	}
+}
+
+// Allowed tells if the key may try again, otherwise how long until it may
+func (l *LoginLimiter) Allowed(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	recent := l.recent(key)
+	if len(recent) < l.max {
+		return true, 0
+	}
+	return false, recent[0].Add(l.window).Sub(l.now())
+}
+
+// Failure records a failed attempt
+func (l *LoginLimiter) Failure(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.failures) > maxTrackedKeys {
+		for k := range l.failures {
+			l.recent(k)
+		}
+	}
+
+	l.failures[key] = append(l.recent(key), l.now())
+}
+
+// Reset forgets the failures of a key, e.g. after a successful login
+func (l *LoginLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, key)
+}
+
+// recent drops failures outside the window, the lock must be held
+func (l *LoginLimiter) recent(key string) []time.Time {
+	since := l.now().Add(-l.window)
+
+	failures := l.failures[key]
+	for len(failures) > 0 && failures[0].Before(since) {
+		failures = failures[1:]
+	}
+
+	if len(failures) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = failures
+	return failures
+}
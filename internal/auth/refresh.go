@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// Access tokens are short lived, clients use their refresh token to get a new one
+	AccessTokenDuration  = 15 * time.Minute
+	RefreshTokenDuration = 60 * 24 * time.Hour
+
+	refreshTokenLength = 32
+	sessionIDLength    = 16
+)
+
+// NewRefreshToken creates a random refresh token, only its hash is stored
+func NewRefreshToken() (token string, hash string, err error) {
+	token, err = randomToken(refreshTokenLength)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken is how refresh tokens are looked up.
+// The tokens are long and random, a plain hash is enough.
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSessionID is the ID of a login session, used as `jti` in the access tokens
+func NewSessionID() (string, error) {
+	return randomToken(sessionIDLength)
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -10,18 +10,50 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// ShortDateFormat is the layout of dates without a time, e.g. "2021-03-01"
+const ShortDateFormat = "2006-01-02"
+
 func ShortDate(d time.Time) string {
-	return d.UTC().Truncate(24 * time.Hour).Format("2006-01-02")
+	return d.UTC().Truncate(24 * time.Hour).Format(ShortDateFormat)
+}
+
+// ShortDateIn formats the date as seen in the given location
+func ShortDateIn(d time.Time, loc *time.Location) string {
+	return d.In(loc).Format(ShortDateFormat)
 }
 
 func Today() string {
 	return ShortDate(time.Now())
 }
 
+// TodayIn is the date in the given location, e.g. the users timezone
+func TodayIn(loc *time.Location) string {
+	return ShortDateIn(time.Now(), loc)
+}
+
 func Weekday() string {
 	return strings.ToLower(time.Now().UTC().Truncate(24 * time.Hour).Weekday().String())
 }
 
+// WeekdayIn is the weekday in the given location, e.g. the users timezone
+func WeekdayIn(loc *time.Location) string {
+	return strings.ToLower(time.Now().In(loc).Weekday().String())
+}
+
+// Location loads an IANA timezone, falls back to UTC if empty or unknown.
+// "Local" is the timezone of the server and not the user's, it's UTC too.
+func Location(timezone string) *time.Location {
+	if timezone == "" || timezone == "Local" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
 // From https://stackoverflow.com/questions/22892120/how-to-generate-a-random-string-of-a-fixed-length-in-go
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
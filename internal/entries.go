@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// WeekdayOf returns the lowercase weekday of a short date, e.g. "monday"
+func WeekdayOf(date string) (string, error) {
+	d, err := time.Parse(ShortDateFormat, date)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(d.Weekday().String()), nil
+}
+
+// CreateDailyEntries makes sure there is an entry for every habit due on `date`.
+// Only missing entries are created. Returns all entries of that date.
+func CreateDailyEntries(ctx context.Context, hs HabitzServicer, userID, date string) ([]*repository.HabitEntry, error) {
+	due, err := DueHabits(ctx, hs, userID, date)
+	if err != nil {
+		return nil, err
+	}
+
+	return hs.EnsureHabitEntries(ctx, userID, date, due)
+}
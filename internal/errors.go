@@ -0,0 +1,10 @@
+package internal
+
+import "errors"
+
+// Errors returned by the HabitzServicer implementations
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrLastIdentity  = errors.New("last identity")
+)
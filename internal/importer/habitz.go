@@ -0,0 +1,68 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// habitzExport is the part of a Habitz JSON export that is imported, the profile is not
+type habitzExport struct {
+	Habits    []*repository.Habit              `json:"habits"`
+	Templates []*repository.WeekHabitTemplates `json:"templates"`
+	Entries   []struct {
+		HabitID    int        `json:"habit_id"`
+		Habit      string     `json:"habit"`
+		Date       string     `json:"date"`
+		Value      float64    `json:"value"`
+		Complete   bool       `json:"complete"`
+		CompleteAt *time.Time `json:"complete_at"`
+	} `json:"entries"`
+}
+
+// ParseHabitz reads a JSON export of Habitz, e.g. from another account or server
+func ParseHabitz(r io.Reader) (*repository.HabitImport, error) {
+	export := habitzExport{}
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("invalid habitz export: %w", err)
+	}
+
+	weekdays := map[int][]string{}
+	for _, t := range export.Templates {
+		weekdays[t.HabitID] = t.Weekdays
+	}
+
+	data := &repository.HabitImport{}
+	names := map[int]string{}
+
+	for _, h := range export.Habits {
+		names[h.ID] = h.Name
+
+		// IDs and owner are from the exporting account
+		habit := *h
+		habit.ID, habit.UserID, habit.TypeID = 0, "", nil
+		data.Habits = append(data.Habits, &repository.ImportedHabit{Habit: habit, Weekdays: weekdays[h.ID]})
+	}
+
+	for _, e := range export.Entries {
+		name, ok := names[e.HabitID]
+		if !ok {
+			name = e.Habit
+		}
+		data.Entries = append(data.Entries, &repository.ImportedEntry{
+			Habit:      name,
+			Date:       e.Date,
+			Value:      e.Value,
+			Complete:   e.Complete,
+			CompleteAt: e.CompleteAt,
+		})
+	}
+
+	if err := validate(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,89 @@
+// Package importer reads exports of Habitz and other habit trackers
+package importer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/recurrence"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// Supported import formats
+const (
+	FormatHabitz = "habitz" // GET /v1/export?format=json
+	FormatLoop   = "loop"   // Checkmarks.csv of Loop Habit Tracker
+)
+
+// MaxFileSize is the largest import, and the largest file unpacked from an archive
+const MaxFileSize = 10 << 20
+
+var weekdays = map[string]bool{
+	"monday": true, "tuesday": true, "wednesday": true, "thursday": true,
+	"friday": true, "saturday": true, "sunday": true,
+}
+
+// validate checks the imported data like the API checks new habitz, and makes sure
+// every habit is created before its first entry, so its history counts in streaks and stats
+func validate(data *repository.HabitImport) error {
+	names := map[string]*repository.ImportedHabit{}
+	habits := []*repository.ImportedHabit{}
+
+	for _, h := range data.Habits {
+		h.Name = strings.TrimSpace(h.Name)
+		if h.Name == "" {
+			return fmt.Errorf("habit without a name")
+		}
+
+		// The same habit twice, e.g. with different case
+		if _, ok := names[strings.ToLower(h.Name)]; ok {
+			continue
+		}
+		names[strings.ToLower(h.Name)] = h
+		habits = append(habits, h)
+
+		switch h.Kind {
+		case "", repository.HabitKindCheck:
+			h.Kind, h.Target, h.Unit = repository.HabitKindCheck, 1, ""
+		case repository.HabitKindQuantity:
+			if h.Target <= 0 {
+				return fmt.Errorf("habit %q: target must be greater than 0", h.Name)
+			}
+		default:
+			return fmt.Errorf("habit %q: invalid kind %q", h.Name, h.Kind)
+		}
+
+		if h.Recurrence != "" {
+			rule, err := recurrence.Parse(h.Recurrence)
+			if err != nil {
+				return fmt.Errorf("habit %q: %w", h.Name, err)
+			}
+			h.Recurrence = rule.String()
+			h.Weekdays = nil
+		}
+
+		for i, weekday := range h.Weekdays {
+			h.Weekdays[i] = strings.ToLower(weekday)
+			if !weekdays[h.Weekdays[i]] {
+				return fmt.Errorf("habit %q: invalid weekday %q", h.Name, weekday)
+			}
+		}
+	}
+	data.Habits = habits
+
+	for _, e := range data.Entries {
+		e.Habit = strings.TrimSpace(e.Habit)
+		date, err := time.Parse(internal.ShortDateFormat, e.Date)
+		if err != nil {
+			return fmt.Errorf("habit %q: invalid date %q", e.Habit, e.Date)
+		}
+
+		if h, ok := names[strings.ToLower(e.Habit)]; ok && (h.CreatedAt.IsZero() || date.Before(h.CreatedAt)) {
+			h.CreatedAt = date
+		}
+	}
+
+	return nil
+}
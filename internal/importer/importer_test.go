@@ -0,0 +1,143 @@
+package importer_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jfernstad/habitz/web/internal/importer"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+const loopCheckmarks = `Date,Meditate,Gym,Water,
+2021-03-03,2,1,2.500,
+2021-03-02,0,2,-1,
+2021-03-01,-1,0,0.000,
+`
+
+func TestParseLoopCheckmarks(t *testing.T) {
+	data, err := importer.ParseLoop([]byte(loopCheckmarks))
+	assert.Nil(t, err)
+
+	assert.Len(t, data.Habits, 3)
+	meditate := data.Habits[0]
+	assert.Equal(t, "Meditate", meditate.Name)
+	assert.Equal(t, repository.HabitKindCheck, meditate.Kind)
+	assert.Len(t, meditate.Weekdays, 7)
+	assert.Equal(t, "2021-03-02", meditate.CreatedAt.Format("2006-01-02"))
+	assert.Equal(t, repository.HabitKindQuantity, data.Habits[2].Kind)
+
+	entries := map[string]*repository.ImportedEntry{}
+	for _, e := range data.Entries {
+		entries[e.Habit+" "+e.Date] = e
+	}
+	assert.Len(t, entries, 6)
+	assert.True(t, entries["Meditate 2021-03-03"].Complete)
+	assert.False(t, entries["Meditate 2021-03-02"].Complete)
+	assert.Nil(t, entries["Gym 2021-03-03"]) // Not needed that day
+	assert.Equal(t, 2.5, entries["Water 2021-03-03"].Value)
+	assert.True(t, entries["Water 2021-03-03"].Complete)
+	assert.False(t, entries["Water 2021-03-01"].Complete)
+}
+
+func TestParseLoopArchive(t *testing.T) {
+	buf := bytes.Buffer{}
+	archive := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"Habits.csv":              "Position,Name,Question,Description,NumRepetitions,Interval,Color\n001,Meditate,,,1,1,#FF0000\n002,Gym,,,3,7,#00FF00\n003,Water,,,1,2,#0000FF\n",
+		"Checkmarks.csv":          loopCheckmarks,
+		"001 Meditate/Scores.csv": "ignored",
+	} {
+		f, err := archive.Create(name)
+		assert.Nil(t, err)
+		f.Write([]byte(content))
+	}
+	assert.Nil(t, archive.Close())
+
+	data, err := importer.ParseLoop(buf.Bytes())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "", data.Habits[0].Recurrence)
+	assert.Len(t, data.Habits[0].Weekdays, 7)
+	assert.Equal(t, "FREQ=WEEKLY;X-TIMES=3", data.Habits[1].Recurrence)
+	assert.Empty(t, data.Habits[1].Weekdays)
+	assert.Equal(t, "FREQ=DAILY;INTERVAL=2", data.Habits[2].Recurrence)
+}
+
+// Files unpacked from an archive are limited like uploads
+func TestParseLoopArchiveTooLarge(t *testing.T) {
+	for _, name := range []string{"Habits.csv", "Checkmarks.csv"} {
+		buf := bytes.Buffer{}
+		archive := zip.NewWriter(&buf)
+		for file, content := range map[string]string{
+			"Habits.csv":     "Position,Name,Question,Description,NumRepetitions,Interval,Color\n001,Meditate,,,1,1,#FF0000\n",
+			"Checkmarks.csv": loopCheckmarks,
+		} {
+			if file == name {
+				content = strings.Repeat("a", importer.MaxFileSize+1)
+			}
+			f, err := archive.Create(file)
+			assert.Nil(t, err)
+			f.Write([]byte(content))
+		}
+		assert.Nil(t, archive.Close())
+		assert.True(t, buf.Len() < importer.MaxFileSize)
+
+		_, err := importer.ParseLoop(buf.Bytes())
+		if assert.NotNil(t, err, name) {
+			assert.Contains(t, err.Error(), name+" is larger than 10 MB")
+		}
+	}
+}
+
+func TestParseHabitz(t *testing.T) {
+	export := `{
+		"exported_at": "2021-03-10T10:00:00Z",
+		"profile": {"id": "u1", "name": "Alice"},
+		"habits": [
+			{"id": 7, "user_id": "u1", "name": "Run", "created_at": "2021-03-01T08:00:00Z", "kind": "check", "target": 1},
+			{"id": 8, "user_id": "u1", "name": "Read", "created_at": "2021-03-01T08:00:00Z", "kind": "quantity", "target": 20, "unit": "pages", "rrule": "FREQ=WEEKLY;X-TIMES=2"}
+		],
+		"templates": [{"habit_id": 7, "weekdays": ["monday", "friday"]}],
+		"entries": [
+			{"id": 1, "habit_id": 7, "habit": "Run", "date": "2021-02-26", "complete": true, "complete_at": "2021-02-26T07:00:00Z"},
+			{"id": 2, "habit_id": 8, "habit": "Read", "date": "2021-03-01", "value": 25, "complete": true}
+		]
+	}`
+
+	data, err := importer.ParseHabitz(strings.NewReader(export))
+	assert.Nil(t, err)
+
+	assert.Len(t, data.Habits, 2)
+	run := data.Habits[0]
+	assert.Equal(t, 0, run.ID)
+	assert.Equal(t, "", run.UserID)
+	assert.Equal(t, []string{"monday", "friday"}, run.Weekdays)
+	assert.Equal(t, "2021-02-26", run.CreatedAt.Format("2006-01-02")) // The first entry is older
+	assert.Equal(t, "FREQ=WEEKLY;X-TIMES=2", data.Habits[1].Recurrence)
+
+	assert.Len(t, data.Entries, 2)
+	assert.Equal(t, "Run", data.Entries[0].Habit)
+	assert.NotNil(t, data.Entries[0].CompleteAt)
+	assert.Equal(t, 25.0, data.Entries[1].Value)
+}
+
+func TestParseInvalidImports(t *testing.T) {
+	for name, export := range map[string]string{
+		"invalid kind":    `{"habits": [{"name": "Run", "kind": "sometimes"}]}`,
+		"invalid weekday": `{"habits": [{"id": 1, "name": "Run"}], "templates": [{"habit_id": 1, "weekdays": ["someday"]}]}`,
+		"invalid rrule":   `{"habits": [{"name": "Run", "rrule": "FREQ=HOURLY"}]}`,
+		"invalid date":    `{"habits": [{"id": 1, "name": "Run"}], "entries": [{"habit_id": 1, "date": "yesterday"}]}`,
+		"not json":        `Date,Run`,
+	} {
+		_, err := importer.ParseHabitz(strings.NewReader(export))
+		assert.NotNil(t, err, name)
+	}
+
+	_, err := importer.ParseLoop([]byte("Name,Run\n"))
+	assert.NotNil(t, err)
+	_, err = importer.ParseLoop([]byte("Date,Run,\n2021-03-01,7,\n"))
+	assert.NotNil(t, err)
+}
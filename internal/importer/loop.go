@@ -0,0 +1,237 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// Loop Habit Tracker checkmark values
+const (
+	loopUnknown   = -1
+	loopNo        = 0
+	loopYesAuto   = 1 // Not needed that day, the habit is done often enough
+	loopYesManual = 2
+	loopSkip      = 3
+)
+
+var allWeekdays = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
+// ParseLoop reads an export of Loop Habit Tracker, either the zip archive or only its Checkmarks.csv.
+// The zip archive has Habits.csv with how often the habitz repeat, without it every habit is daily.
+// Numerical habitz become quantity habitz with a target of 1.
+func ParseLoop(data []byte) (*repository.HabitImport, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return parseLoopCheckmarks(bytes.NewReader(data), nil)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid loop export: %w", err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		if path.Dir(f.Name) == "." {
+			files[f.Name] = f
+		}
+	}
+	if files["Checkmarks.csv"] == nil {
+		return nil, fmt.Errorf("invalid loop export: Checkmarks.csv is missing")
+	}
+
+	var rules map[string]string
+	if f := files["Habits.csv"]; f != nil {
+		content, err := readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if rules, err = parseLoopHabits(bytes.NewReader(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := readZipFile(files["Checkmarks.csv"])
+	if err != nil {
+		return nil, err
+	}
+	return parseLoopCheckmarks(bytes.NewReader(content), rules)
+}
+
+// readZipFile reads a file of the archive, at most MaxFileSize of it.
+// The upload is limited, but a small archive can unpack to gigabytes.
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	content, err := ioutil.ReadAll(io.LimitReader(rc, MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid loop export: %w", err)
+	}
+	if len(content) > MaxFileSize {
+		return nil, fmt.Errorf("invalid loop export: %s is larger than 10 MB", f.Name)
+	}
+	return content, nil
+}
+
+// parseLoopHabits maps habit names to recurrence rules, daily habitz have no rule
+func parseLoopHabits(r io.Reader) (map[string]string, error) {
+	rows, err := readLoopCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("invalid loop export: Habits.csv is empty")
+	}
+
+	column := map[string]int{}
+	for i, name := range rows[0] {
+		column[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"Name", "NumRepetitions", "Interval"} {
+		if _, ok := column[name]; !ok {
+			return nil, fmt.Errorf("invalid loop export: Habits.csv has no %s column", name)
+		}
+	}
+
+	rules := map[string]string{}
+	for _, row := range rows[1:] {
+		if len(row) <= column["Name"] || len(row) <= column["NumRepetitions"] || len(row) <= column["Interval"] {
+			continue
+		}
+		times, err1 := strconv.Atoi(row[column["NumRepetitions"]])
+		interval, err2 := strconv.Atoi(row[column["Interval"]])
+		if err1 != nil || err2 != nil || times < 1 || interval < 1 {
+			return nil, fmt.Errorf("invalid loop export: habit %q has an invalid frequency", row[column["Name"]])
+		}
+		rules[strings.TrimSpace(row[column["Name"]])] = loopRule(times, interval)
+	}
+	return rules, nil
+}
+
+// loopRule describes "times per interval days" as a recurrence rule
+func loopRule(times, interval int) string {
+	switch {
+	case times == 1 && interval == 1:
+		return ""
+	case times == 1:
+		return "FREQ=DAILY;INTERVAL=" + strconv.Itoa(interval)
+	case interval == 7:
+		return "FREQ=WEEKLY;X-TIMES=" + strconv.Itoa(times)
+	case interval == 30 || interval == 31:
+		return "FREQ=MONTHLY;X-TIMES=" + strconv.Itoa(times)
+	}
+	return "" // No rule for e.g. 2 times in 3 days, it's close enough to daily
+}
+
+// parseLoopCheckmarks reads a date column followed by one column per habit
+func parseLoopCheckmarks(r io.Reader, rules map[string]string) (*repository.HabitImport, error) {
+	rows, err := readLoopCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || len(rows[0]) < 2 || strings.TrimSpace(rows[0][0]) != "Date" {
+		return nil, fmt.Errorf("invalid loop export: expected a Date column followed by habitz")
+	}
+
+	data := &repository.HabitImport{}
+	for _, name := range rows[0][1:] {
+		habit := &repository.ImportedHabit{Habit: repository.Habit{Name: strings.TrimSpace(name)}}
+		if rule := rules[habit.Name]; rule != "" {
+			habit.Recurrence = rule
+		} else {
+			habit.Weekdays = append([]string{}, allWeekdays...)
+		}
+		data.Habits = append(data.Habits, habit)
+	}
+
+	for _, row := range rows[1:] {
+		date := strings.TrimSpace(row[0])
+
+		for i, value := range row[1:] {
+			if i >= len(data.Habits) {
+				break
+			}
+			habit := data.Habits[i]
+
+			entry, err := loopEntry(habit, strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid loop export: %s, %s: %w", date, habit.Name, err)
+			}
+			if entry == nil {
+				continue
+			}
+			entry.Habit, entry.Date = habit.Name, date
+			data.Entries = append(data.Entries, entry)
+		}
+	}
+
+	if err := validate(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// loopEntry converts a checkmark, days that weren't tracked have no entry
+func loopEntry(habit *repository.ImportedHabit, value string) (*repository.ImportedEntry, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	// Numerical habitz have decimal values
+	if strings.Contains(value, ".") {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		if v < 0 {
+			return nil, nil
+		}
+		habit.Kind, habit.Target = repository.HabitKindQuantity, 1
+		return &repository.ImportedEntry{Value: v, Complete: v >= habit.Target}, nil
+	}
+
+	checkmark, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch checkmark {
+	case loopYesManual:
+		return &repository.ImportedEntry{Value: 1, Complete: true}, nil
+	case loopNo:
+		return &repository.ImportedEntry{}, nil
+	case loopUnknown, loopYesAuto, loopSkip:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown checkmark %q", value)
+}
+
+// readLoopCSV reads CSV files where every line ends with a comma
+func readLoopCSV(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid loop export: %w", err)
+	}
+
+	for i, row := range rows {
+		if len(row) > 0 && row[len(row)-1] == "" {
+			rows[i] = row[:len(row)-1]
+		}
+	}
+	return rows, nil
+}
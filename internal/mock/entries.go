@@ -0,0 +1,315 @@
+package mock
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// Only the first conflicts are listed in the result, all of them are counted
+const maxReportedConflicts = 100
+
+// entry returns a stored entry together with the current name and target of its habit
+func (s *store) entry(e repository.HabitEntry) *repository.HabitEntry {
+	habit := s.habits[e.HabitID]
+	e.Habit = habit.Name
+	e.TypeID = habit.TypeID
+	e.Kind = habit.Kind
+	e.Target = habit.Target
+	e.Unit = habit.Unit
+	return &e
+}
+
+// userEntries lists the matching entries of the user, ordered by date
+func (s *store) userEntries(userID string, match func(e repository.HabitEntry) bool) []*repository.HabitEntry {
+	entries := []*repository.HabitEntry{}
+	for _, e := range s.entries {
+		if e.UserID == userID && match(e) {
+			entries = append(entries, s.entry(e))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Date != entries[j].Date {
+			return entries[i].Date < entries[j].Date
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// hasEntry is true when the habit has an entry on the date already
+func (s *store) hasEntry(userID string, habitID int, date string) bool {
+	for _, e := range s.entries {
+		if e.UserID == userID && e.HabitID == habitID && e.Date == date {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *store) createEntry(e repository.HabitEntry) *repository.HabitEntry {
+	s.lastEntryID++
+	e.ID = s.lastEntryID
+	s.entries[e.ID] = e
+	return s.entry(e)
+}
+
+func (m *HabitzService) HabitEntries(ctx context.Context, userID string, date string) ([]*repository.HabitEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.s.userEntries(userID, func(e repository.HabitEntry) bool { return e.Date == date }), nil
+}
+
+// HabitEntry finds an entry of the user, other users entries are not found
+func (m *HabitzService) HabitEntry(ctx context.Context, userID string, id int) (*repository.HabitEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.s.entries[id]
+	if !ok || e.UserID != userID {
+		return nil, nil
+	}
+	return m.s.entry(e), nil
+}
+
+// HabitEntriesBetween returns all entries from `from` to `to`, both dates included.
+// Entries are ordered by date, use limit and offset to page through them.
+func (m *HabitzService) HabitEntriesBetween(ctx context.Context, userID string, from, to string, limit, offset int) ([]*repository.HabitEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := m.s.userEntries(userID, func(e repository.HabitEntry) bool { return e.Date >= from && e.Date <= to })
+	if offset >= len(entries) {
+		return []*repository.HabitEntry{}, nil
+	}
+	entries = entries[offset:]
+	if limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// EachHabitEntry calls fn with every entry of the user, oldest first.
+// Stops at the first error returned by fn.
+func (m *HabitzService) EachHabitEntry(ctx context.Context, userID string, fn func(*repository.HabitEntry) error) error {
+	m.mu.Lock()
+	entries := m.s.userEntries(userID, func(e repository.HabitEntry) bool { return true })
+	m.mu.Unlock()
+
+	// fn may use the service, so the lock isn't held while calling it
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *HabitzService) CreateHabitEntry(ctx context.Context, userID, date, weekday string, habitID int) (*repository.HabitEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.s.habit(userID, habitID); !ok {
+		return nil, internal.ErrNotFound
+	}
+	if m.s.hasEntry(userID, habitID, date) {
+		return nil, internal.ErrAlreadyExists
+	}
+
+	return m.s.createEntry(repository.HabitEntry{
+		UserID:  userID,
+		Weekday: weekday,
+		HabitID: habitID,
+		Date:    date,
+	}), nil
+}
+
+// EnsureHabitEntries creates the missing entries of the habitz on `date`, only habitz of the user get entries.
+// Returns all entries of that date.
+func (m *HabitzService) EnsureHabitEntries(ctx context.Context, userID, date string, habitIDs []int) ([]*repository.HabitEntry, error) {
+	weekday, err := internal.WeekdayOf(date)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, habitID := range habitIDs {
+		if _, ok := m.s.habit(userID, habitID); !ok || m.s.hasEntry(userID, habitID, date) {
+			continue
+		}
+
+		m.s.createEntry(repository.HabitEntry{
+			UserID:  userID,
+			Weekday: weekday,
+			HabitID: habitID,
+			Date:    date,
+		})
+	}
+
+	return m.s.userEntries(userID, func(e repository.HabitEntry) bool { return e.Date == date }), nil
+}
+
+func (m *HabitzService) RemoveEntry(ctx context.Context, userID string, habitID int, date string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, e := range m.s.entries {
+		if e.UserID == userID && e.HabitID == habitID && e.Date == date {
+			delete(m.s.entries, id)
+		}
+	}
+	return nil
+}
+
+// UpdateHabitEntry completes an entry of the user, other users entries are not found
+func (m *HabitzService) UpdateHabitEntry(ctx context.Context, userID string, id int, complete bool) (*repository.HabitEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.s.entries[id]
+	if !ok || e.UserID != userID {
+		return nil, internal.ErrNotFound
+	}
+
+	e.Complete = complete
+	if complete {
+		completeAt := now()
+		e.CompleteAt = &completeAt
+	}
+	m.s.entries[id] = e
+
+	return m.s.entry(e), nil
+}
+
+// SetHabitEntryValue records the value of a quantitative entry.
+// The entry is complete once the value reaches the target of the habit.
+func (m *HabitzService) SetHabitEntryValue(ctx context.Context, userID string, id int, value float64) (*repository.HabitEntry, error) {
+	return m.updateHabitEntryValue(userID, id, func(float64) float64 { return value })
+}
+
+// IncrementHabitEntry adds `delta` to the value of the entry, e.g. one more glass of water
+func (m *HabitzService) IncrementHabitEntry(ctx context.Context, userID string, id int, delta float64) (*repository.HabitEntry, error) {
+	return m.updateHabitEntryValue(userID, id, func(value float64) float64 { return value + delta })
+}
+
+func (m *HabitzService) updateHabitEntryValue(userID string, id int, value func(float64) float64) (*repository.HabitEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.s.entries[id]
+	if !ok || e.UserID != userID {
+		return nil, internal.ErrNotFound
+	}
+
+	e.Value = math.Max(0, value(e.Value))
+	reached := e.Value >= m.s.habits[e.HabitID].Target
+	if reached && !e.Complete {
+		completeAt := now()
+		e.CompleteAt = &completeAt
+	}
+	e.Complete = reached
+	m.s.entries[id] = e
+
+	return m.s.entry(e), nil
+}
+
+// Import adds habitz, templates and entries all at once.
+// Habitz are matched by name, entries that already exist are conflicts and left as they are.
+// A dry run does the same work on a copy of the data.
+func (m *HabitzService) Import(ctx context.Context, userID string, data *repository.HabitImport, dryRun bool) (*repository.ImportResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.s.clone()
+	result := &repository.ImportResult{
+		DryRun:         dryRun,
+		HabitsCreated:  []string{},
+		HabitsExisting: []string{},
+		Conflicts:      []repository.ImportConflict{},
+	}
+
+	habits := map[string]repository.Habit{}
+	for _, h := range s.habits {
+		if h.UserID == userID {
+			habits[strings.ToLower(h.Name)] = h
+		}
+	}
+
+	for _, h := range data.Habits {
+		key := strings.ToLower(h.Name)
+
+		habit, ok := habits[key]
+		if ok {
+			result.HabitsExisting = append(result.HabitsExisting, h.Name)
+		} else {
+			imported := h.Habit
+			if imported.CreatedAt.IsZero() {
+				imported.CreatedAt = now()
+			}
+			imported.TypeID = nil
+
+			habit = s.createHabit(userID, imported)
+			habits[key] = habit
+			result.HabitsCreated = append(result.HabitsCreated, h.Name)
+		}
+
+		// Habitz with a recurrence rule don't use templates
+		if habit.Recurrence != "" {
+			continue
+		}
+
+		for _, weekday := range h.Weekdays {
+			if s.addTemplate(userID, weekday, habit.ID) {
+				result.TemplatesCreated++
+			}
+		}
+	}
+
+	for _, entry := range data.Entries {
+		habit, ok := habits[strings.ToLower(entry.Habit)]
+		if !ok {
+			addConflict(result, entry, "unknown habit")
+			continue
+		}
+
+		if s.hasEntry(userID, habit.ID, entry.Date) {
+			addConflict(result, entry, "entry exists")
+			continue
+		}
+
+		weekday, err := internal.WeekdayOf(entry.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		s.createEntry(repository.HabitEntry{
+			UserID:     userID,
+			Weekday:    weekday,
+			HabitID:    habit.ID,
+			Date:       entry.Date,
+			Complete:   entry.Complete,
+			CompleteAt: entry.CompleteAt,
+			Value:      entry.Value,
+		})
+		result.EntriesCreated++
+	}
+
+	if !dryRun {
+		m.s = s
+	}
+	return result, nil
+}
+
+func addConflict(result *repository.ImportResult, entry *repository.ImportedEntry, reason string) {
+	result.EntriesConflicting++
+	if len(result.Conflicts) < maxReportedConflicts {
+		result.Conflicts = append(result.Conflicts, repository.ImportConflict{Habit: entry.Habit, Date: entry.Date, Reason: reason})
+	}
+}
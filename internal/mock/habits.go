@@ -0,0 +1,408 @@
+package mock
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// habit finds a habit of the user, other users habitz are not found
+func (s *store) habit(userID string, id int) (repository.Habit, bool) {
+	habit, ok := s.habits[id]
+	if !ok || habit.UserID != userID {
+		return repository.Habit{}, false
+	}
+	return habit, true
+}
+
+// nameTaken is true if another habit of the user has the name, ignoring case
+func (s *store) nameTaken(userID, name string, exceptID int) bool {
+	for _, h := range s.habits {
+		if h.UserID == userID && h.ID != exceptID && strings.EqualFold(h.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *store) createHabit(userID string, habit repository.Habit) repository.Habit {
+	s.lastHabitID++
+	habit.ID = s.lastHabitID
+	habit.UserID = userID
+	s.habits[habit.ID] = habit
+	return habit
+}
+
+func (s *store) removeTemplates(remove func(t repository.WeekdayHabitTemplate) bool) int {
+	kept := s.templates[:0]
+	for _, t := range s.templates {
+		if !remove(t) {
+			kept = append(kept, t)
+		}
+	}
+	removed := len(s.templates) - len(kept)
+	s.templates = kept
+	return removed
+}
+
+func (m *HabitzService) Habits(ctx context.Context, userID string, includeArchived bool) ([]*repository.Habit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	habits := []*repository.Habit{}
+	for _, h := range m.s.habits {
+		if h.UserID != userID || (h.ArchivedAt != nil && !includeArchived) {
+			continue
+		}
+		habit := h
+		habits = append(habits, &habit)
+	}
+	sort.Slice(habits, func(i, j int) bool { return habits[i].ID < habits[j].ID })
+	return habits, nil
+}
+
+func (m *HabitzService) Habit(ctx context.Context, userID string, id int) (*repository.Habit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	habit, ok := m.s.habit(userID, id)
+	if !ok {
+		return nil, nil
+	}
+	return &habit, nil
+}
+
+// HabitWithName finds a habit by name, ignoring case
+func (m *HabitzService) HabitWithName(ctx context.Context, userID, name string) (*repository.Habit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, h := range m.s.habits {
+		if h.UserID == userID && strings.EqualFold(h.Name, name) {
+			habit := h
+			return &habit, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *HabitzService) CreateHabit(ctx context.Context, userID string, habit *repository.Habit) (*repository.Habit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.s.nameTaken(userID, habit.Name, 0) {
+		return nil, internal.ErrAlreadyExists
+	}
+
+	created := m.s.createHabit(userID, repository.Habit{
+		Name:        habit.Name,
+		Description: habit.Description,
+		CreatedAt:   now(),
+		Kind:        habit.Kind,
+		Target:      habit.Target,
+		Unit:        habit.Unit,
+	})
+	return &created, nil
+}
+
+// UpdateHabit renames a habit and changes its target.
+// Templates and entries reference the habit ID, so they keep their history.
+func (m *HabitzService) UpdateHabit(ctx context.Context, userID string, habit *repository.Habit) (*repository.Habit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.s.habit(userID, habit.ID)
+	if !ok {
+		return nil, internal.ErrNotFound
+	}
+	if m.s.nameTaken(userID, habit.Name, habit.ID) {
+		return nil, internal.ErrAlreadyExists
+	}
+
+	stored.Name = habit.Name
+	stored.Description = habit.Description
+	stored.Kind = habit.Kind
+	stored.Target = habit.Target
+	stored.Unit = habit.Unit
+	m.s.habits[habit.ID] = stored
+
+	return &stored, nil
+}
+
+// ArchiveHabit hides a habit from the schedule, its history is kept
+func (m *HabitzService) ArchiveHabit(ctx context.Context, userID string, id int, archived bool) (*repository.Habit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	habit, ok := m.s.habit(userID, id)
+	if !ok {
+		return nil, internal.ErrNotFound
+	}
+
+	habit.ArchivedAt = nil
+	if archived {
+		archivedAt := now()
+		habit.ArchivedAt = &archivedAt
+	}
+	m.s.habits[id] = habit
+
+	return &habit, nil
+}
+
+// SetHabitType assigns the habit to a type, nil removes the type.
+// Both the habit and the type must belong to the user.
+func (m *HabitzService) SetHabitType(ctx context.Context, userID string, id int, typeID *int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var newTypeID *int
+	if typeID != nil {
+		habitType, ok := m.s.habitTypes[*typeID]
+		if !ok || habitType.UserID != userID {
+			return internal.ErrNotFound
+		}
+		newTypeID = &habitType.ID
+	}
+
+	habit, ok := m.s.habit(userID, id)
+	if !ok {
+		return internal.ErrNotFound
+	}
+	habit.TypeID = newTypeID
+	m.s.habits[id] = habit
+	return nil
+}
+
+// SetHabitRecurrence schedules the habit with a recurrence rule, counted from `start`.
+// A rule replaces the weekday templates of the habit, an empty rule removes it.
+func (m *HabitzService) SetHabitRecurrence(ctx context.Context, userID string, id int, rrule, start string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	habit, ok := m.s.habit(userID, id)
+	if !ok {
+		return internal.ErrNotFound
+	}
+	habit.Recurrence = rrule
+	habit.RecurrenceStart = start
+	m.s.habits[id] = habit
+
+	if rrule != "" {
+		m.s.removeTemplates(func(t repository.WeekdayHabitTemplate) bool { return t.HabitID == id })
+	}
+	return nil
+}
+
+// RemoveHabit deletes a habit together with its templates and entries
+func (m *HabitzService) RemoveHabit(ctx context.Context, userID string, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.s.habit(userID, id); !ok {
+		return internal.ErrNotFound
+	}
+
+	delete(m.s.habits, id)
+	m.s.removeTemplates(func(t repository.WeekdayHabitTemplate) bool { return t.HabitID == id })
+	for entryID, e := range m.s.entries {
+		if e.HabitID == id {
+			delete(m.s.entries, entryID)
+		}
+	}
+	return nil
+}
+
+func (m *HabitzService) HabitTypes(ctx context.Context, userID string) ([]*repository.HabitType, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	types := []*repository.HabitType{}
+	for _, t := range m.s.habitTypes {
+		if t.UserID == userID {
+			habitType := t
+			types = append(types, &habitType)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if types[i].SortOrder != types[j].SortOrder {
+			return types[i].SortOrder < types[j].SortOrder
+		}
+		return strings.ToLower(types[i].Name) < strings.ToLower(types[j].Name)
+	})
+	return types, nil
+}
+
+func (m *HabitzService) HabitType(ctx context.Context, userID string, id int) (*repository.HabitType, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	habitType, ok := m.s.habitTypes[id]
+	if !ok || habitType.UserID != userID {
+		return nil, nil
+	}
+	return &habitType, nil
+}
+
+// typeNameTaken is true if another type of the user has the name, ignoring case
+func (s *store) typeNameTaken(userID, name string, exceptID int) bool {
+	for _, t := range s.habitTypes {
+		if t.UserID == userID && t.ID != exceptID && strings.EqualFold(t.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *HabitzService) CreateHabitType(ctx context.Context, userID string, habitType *repository.HabitType) (*repository.HabitType, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.s.typeNameTaken(userID, habitType.Name, 0) {
+		return nil, internal.ErrAlreadyExists
+	}
+
+	m.s.lastTypeID++
+	created := repository.HabitType{
+		ID:        m.s.lastTypeID,
+		UserID:    userID,
+		Name:      habitType.Name,
+		Color:     habitType.Color,
+		SortOrder: habitType.SortOrder,
+	}
+	m.s.habitTypes[created.ID] = created
+	return &created, nil
+}
+
+func (m *HabitzService) UpdateHabitType(ctx context.Context, userID string, habitType *repository.HabitType) (*repository.HabitType, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.s.habitTypes[habitType.ID]
+	if !ok || stored.UserID != userID {
+		return nil, internal.ErrNotFound
+	}
+	if m.s.typeNameTaken(userID, habitType.Name, habitType.ID) {
+		return nil, internal.ErrAlreadyExists
+	}
+
+	stored.Name = habitType.Name
+	stored.Color = habitType.Color
+	stored.SortOrder = habitType.SortOrder
+	m.s.habitTypes[stored.ID] = stored
+	return &stored, nil
+}
+
+// RemoveHabitType deletes the type, its habitz are kept without a type
+func (m *HabitzService) RemoveHabitType(ctx context.Context, userID string, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	habitType, ok := m.s.habitTypes[id]
+	if !ok || habitType.UserID != userID {
+		return internal.ErrNotFound
+	}
+
+	delete(m.s.habitTypes, id)
+	for habitID, h := range m.s.habits {
+		if h.UserID == userID && h.TypeID != nil && *h.TypeID == id {
+			h.TypeID = nil
+			m.s.habits[habitID] = h
+		}
+	}
+	return nil
+}
+
+// weekdayTemplates lists the templates of active habitz, ordered by habit
+func (s *store) weekdayTemplates(userID string, match func(t repository.WeekdayHabitTemplate) bool) []*repository.WeekdayHabitTemplate {
+	templates := []*repository.WeekdayHabitTemplate{}
+	for _, t := range s.templates {
+		habit, ok := s.habit(userID, t.HabitID)
+		if t.UserID != userID || !ok || habit.ArchivedAt != nil || !match(t) {
+			continue
+		}
+
+		tmpl := t
+		tmpl.Habit = habit.Name
+		tmpl.TypeID = habit.TypeID
+		templates = append(templates, &tmpl)
+	}
+	sort.SliceStable(templates, func(i, j int) bool { return templates[i].HabitID < templates[j].HabitID })
+	return templates
+}
+
+func (m *HabitzService) Templates(ctx context.Context, userID string) ([]*repository.WeekHabitTemplates, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.s.weekTemplates(userID), nil
+}
+
+// weekTemplates has one template per habit with all of its weekdays
+func (s *store) weekTemplates(userID string) []*repository.WeekHabitTemplates {
+	all := func(t repository.WeekdayHabitTemplate) bool { return true }
+
+	userTemplates := []*repository.WeekHabitTemplates{}
+	for _, tmpl := range s.weekdayTemplates(userID, all) {
+		n := len(userTemplates)
+		if n > 0 && userTemplates[n-1].HabitID == tmpl.HabitID {
+			userTemplates[n-1].Weekdays = append(userTemplates[n-1].Weekdays, tmpl.Weekday)
+			continue
+		}
+
+		userTemplates = append(userTemplates, &repository.WeekHabitTemplates{
+			UserID:   tmpl.UserID,
+			HabitID:  tmpl.HabitID,
+			Habit:    tmpl.Habit,
+			TypeID:   tmpl.TypeID,
+			Weekdays: []string{tmpl.Weekday},
+		})
+	}
+	return userTemplates
+}
+
+func (m *HabitzService) WeekdayTemplates(ctx context.Context, userID, weekday string) ([]*repository.WeekdayHabitTemplate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.s.weekdayTemplates(userID, func(t repository.WeekdayHabitTemplate) bool { return t.Weekday == weekday }), nil
+}
+
+// addTemplate schedules the habit on the weekday, false if it's scheduled already
+func (s *store) addTemplate(userID, weekday string, habitID int) bool {
+	for _, t := range s.templates {
+		if t.UserID == userID && t.Weekday == weekday && t.HabitID == habitID {
+			return false
+		}
+	}
+	s.templates = append(s.templates, repository.WeekdayHabitTemplate{UserID: userID, Weekday: weekday, HabitID: habitID})
+	return true
+}
+
+func (m *HabitzService) CreateTemplate(ctx context.Context, userID, weekday string, habitID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.s.habit(userID, habitID); !ok {
+		return internal.ErrNotFound
+	}
+	if !m.s.addTemplate(userID, weekday, habitID) {
+		return internal.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (m *HabitzService) RemoveTemplate(ctx context.Context, userID, weekday string, habitID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := m.s.removeTemplates(func(t repository.WeekdayHabitTemplate) bool {
+		return t.UserID == userID && t.Weekday == weekday && t.HabitID == habitID
+	})
+	if removed == 0 {
+		return internal.ErrNotFound
+	}
+	return nil
+}
@@ -1,23 +1,552 @@
+// Package mock has an in-memory HabitzServicer, for tests and running the server without a database.
 package mock
 
 import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal"
 	"github.com/jfernstad/habitz/web/internal/repository"
 )
 
-type HabitzService struct{}
+// HabitzService keeps everything in memory, it's safe for concurrent use.
+// Like the databases it only returns copies, changing them doesn't change the stored data.
+type HabitzService struct {
+	mu sync.Mutex
+	s  *store
+}
+
+// store is all data of the service, copied for imports so they can be rolled back
+type store struct {
+	users        map[string]repository.User
+	external     map[externalKey]repository.Identity
+	local        map[string]repository.LocalCredentials // By lowercase email
+	sessions     map[string]repository.Session
+	habitTypes   map[int]repository.HabitType
+	habits       map[int]repository.Habit
+	templates    []repository.WeekdayHabitTemplate // In the order they were created
+	entries      map[int]repository.HabitEntry
+	materialized map[string]string
 
-func (m *HabitzService) Users() ([]string, error) { return nil, nil }
-func (m *HabitzService) Templates(user, weekday string) ([]*repository.WeekHabitTemplates, error) {
-	return nil, nil
+	lastTypeID  int
+	lastHabitID int
+	lastEntryID int
 }
-func (m *HabitzService) CreateTemplate(user, weekday, habit string) error { return nil }
-func (m *HabitzService) RemoveTemplate(user, weekday, habit string) error { return nil }
-func (m *HabitzService) HabitEntries(user string, date string) ([]*repository.HabitEntry, error) {
-	return nil, nil
+
+type externalKey struct {
+	provider string
+	id       string
 }
-func (m *HabitzService) CreateHabitEntry(user, weekday, habit string) (*repository.HabitEntry, error) {
-	return nil, nil
+
+func NewHabitzService() internal.HabitzServicer {
+	return &HabitzService{s: newStore()}
+}
+
+func newStore() *store {
+	return &store{
+		users:        map[string]repository.User{},
+		external:     map[externalKey]repository.Identity{},
+		local:        map[string]repository.LocalCredentials{},
+		sessions:     map[string]repository.Session{},
+		habitTypes:   map[int]repository.HabitType{},
+		habits:       map[int]repository.Habit{},
+		entries:      map[int]repository.HabitEntry{},
+		materialized: map[string]string{},
+	}
+}
+
+// clone copies the maps, the values are replaced rather than changed so they can be shared
+func (s *store) clone() *store {
+	c := *s
+	c.users = map[string]repository.User{}
+	for k, v := range s.users {
+		c.users[k] = v
+	}
+	c.external = map[externalKey]repository.Identity{}
+	for k, v := range s.external {
+		c.external[k] = v
+	}
+	c.local = map[string]repository.LocalCredentials{}
+	for k, v := range s.local {
+		c.local[k] = v
+	}
+	c.sessions = map[string]repository.Session{}
+	for k, v := range s.sessions {
+		c.sessions[k] = v
+	}
+	c.habitTypes = map[int]repository.HabitType{}
+	for k, v := range s.habitTypes {
+		c.habitTypes[k] = v
+	}
+	c.habits = map[int]repository.Habit{}
+	for k, v := range s.habits {
+		c.habits[k] = v
+	}
+	c.templates = append([]repository.WeekdayHabitTemplate(nil), s.templates...)
+	c.entries = map[int]repository.HabitEntry{}
+	for k, v := range s.entries {
+		c.entries[k] = v
+	}
+	c.materialized = map[string]string{}
+	for k, v := range s.materialized {
+		c.materialized[k] = v
+	}
+	return &c
+}
+
+// userCopy doesn't share the preferences, they are decoded in place
+func userCopy(u repository.User) *repository.User {
+	u.Preferences = append(repository.Preferences(nil), u.Preferences...)
+	return &u
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+func newUserID() string {
+	return "u" + internal.NewRandomString(12)
+}
+
+func (m *HabitzService) Users(ctx context.Context) ([]string, error) {
+	users, _ := m.AllUsers(ctx)
+
+	names := []string{}
+	for _, u := range users {
+		names = append(names, u.Firstname)
+	}
+	return names, nil
+}
+
+func (m *HabitzService) AllUsers(ctx context.Context) ([]*repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := []*repository.User{}
+	for _, u := range m.s.users {
+		users = append(users, userCopy(u))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+func (m *HabitzService) User(ctx context.Context, userID string) (*repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.s.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	return userCopy(user), nil
+}
+
+func (m *HabitzService) UserWithExternalID(ctx context.Context, externalID string, provider string) (*repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	identity, ok := m.s.external[externalKey{provider, externalID}]
+	if !ok {
+		return nil, nil
+	}
+	user, ok := m.s.users[identity.UserID]
+	if !ok {
+		return nil, nil
+	}
+	return userCopy(user), nil
+}
+
+func (m *HabitzService) SetUserTimezone(ctx context.Context, userID, timezone string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.s.users[userID]; ok {
+		user.Timezone = timezone
+		m.s.users[userID] = user
+	}
+	return nil
+}
+
+// UpdateUser stores the profile of a user, the email and admin flag aren't changed
+func (m *HabitzService) UpdateUser(ctx context.Context, user *repository.User) (*repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.s.users[user.ID]
+	if !ok {
+		return nil, internal.ErrNotFound
+	}
+
+	stored.Firstname = user.Firstname
+	stored.Lastname = user.Lastname
+	stored.ProfileImageURL = user.ProfileImageURL
+	stored.Timezone = user.Timezone
+	stored.Preferences = append(repository.Preferences(nil), user.Preferences...)
+	if len(stored.Preferences) == 0 {
+		stored.Preferences = repository.Preferences("{}")
+	}
+	m.s.users[user.ID] = stored
+
+	return userCopy(stored), nil
+}
+
+func (m *HabitzService) SetUserAdmin(ctx context.Context, userID string, admin bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.s.users[userID]
+	if !ok {
+		return internal.ErrNotFound
+	}
+	user.Admin = admin
+	m.s.users[userID] = user
+	return nil
+}
+
+// RemoveUser deletes the account and everything that belongs to it
+func (m *HabitzService) RemoveUser(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.s.users[userID]; !ok {
+		return internal.ErrNotFound
+	}
+
+	delete(m.s.users, userID)
+	delete(m.s.materialized, userID)
+	for k, v := range m.s.external {
+		if v.UserID == userID {
+			delete(m.s.external, k)
+		}
+	}
+	for k, v := range m.s.local {
+		if v.UserID == userID {
+			delete(m.s.local, k)
+		}
+	}
+	for k, v := range m.s.sessions {
+		if v.UserID == userID {
+			delete(m.s.sessions, k)
+		}
+	}
+	for k, v := range m.s.habitTypes {
+		if v.UserID == userID {
+			delete(m.s.habitTypes, k)
+		}
+	}
+	for k, v := range m.s.habits {
+		if v.UserID == userID {
+			delete(m.s.habits, k)
+		}
+	}
+	for k, v := range m.s.entries {
+		if v.UserID == userID {
+			delete(m.s.entries, k)
+		}
+	}
+	m.s.removeTemplates(func(t repository.WeekdayHabitTemplate) bool { return t.UserID == userID })
+
+	return nil
+}
+
+// newUser stores a user with the defaults of the database
+func (s *store) newUser(user repository.User) repository.User {
+	user.ID = newUserID()
+	user.Preferences = repository.Preferences("{}")
+	user.Admin = false
+	s.users[user.ID] = user
+	return user
+}
+
+func (m *HabitzService) CreateExternalUser(ctx context.Context, ext *repository.ExternalUser) (*repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := externalKey{ext.Provider, ext.ExternalID}
+	if _, ok := m.s.external[key]; ok {
+		return nil, internal.ErrAlreadyExists
+	}
+
+	user := m.s.newUser(ext.User)
+	linkedAt := now()
+	m.s.external[key] = repository.Identity{
+		Provider:   ext.Provider,
+		ExternalID: ext.ExternalID,
+		UserID:     user.ID,
+		Email:      ext.Email,
+		LinkedAt:   &linkedAt,
+	}
+
+	ext.User.ID = user.ID
+	return &ext.User, nil
 }
-func (m *HabitzService) UpdateHabitEntry(id int, complete bool) (*repository.HabitEntry, error) {
+
+// Identities lists the external identities and local credentials a user can log in with
+func (m *HabitzService) Identities(ctx context.Context, userID string) ([]*repository.Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	identities := []*repository.Identity{}
+	for _, v := range m.s.external {
+		if v.UserID == userID {
+			identity := v
+			identities = append(identities, &identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		a, b := identities[i], identities[j]
+		if !a.LinkedAt.Equal(*b.LinkedAt) {
+			return a.LinkedAt.Before(*b.LinkedAt)
+		}
+		return a.Provider < b.Provider
+	})
+
+	for _, c := range m.s.local {
+		if c.UserID == userID {
+			createdAt := c.CreatedAt
+			identities = append(identities, &repository.Identity{
+				Provider:   repository.ProviderLocal,
+				ExternalID: c.Email,
+				UserID:     c.UserID,
+				Email:      c.Email,
+				LinkedAt:   &createdAt,
+			})
+		}
+	}
+
+	return identities, nil
+}
+
+// LinkIdentity lets a user log in with another provider.
+// Returns ErrAlreadyExists if the identity belongs to an account already.
+func (m *HabitzService) LinkIdentity(ctx context.Context, userID string, ext *repository.ExternalUser) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := externalKey{ext.Provider, ext.ExternalID}
+	if _, ok := m.s.external[key]; ok {
+		return internal.ErrAlreadyExists
+	}
+
+	linkedAt := now()
+	m.s.external[key] = repository.Identity{
+		Provider:   ext.Provider,
+		ExternalID: ext.ExternalID,
+		UserID:     userID,
+		Email:      ext.Email,
+		LinkedAt:   &linkedAt,
+	}
+	return nil
+}
+
+// UnlinkIdentity removes an identity, or the local credentials for the "local" provider.
+// Returns ErrLastIdentity rather than leaving the user without a way to log in.
+func (m *HabitzService) UnlinkIdentity(ctx context.Context, userID, provider, externalID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, v := range m.s.external {
+		if v.UserID == userID {
+			count++
+		}
+	}
+	for _, c := range m.s.local {
+		if c.UserID == userID {
+			count++
+		}
+	}
+
+	if provider == repository.ProviderLocal {
+		key := strings.ToLower(externalID)
+		if c, ok := m.s.local[key]; !ok || c.UserID != userID {
+			return internal.ErrNotFound
+		}
+		if count <= 1 {
+			return internal.ErrLastIdentity
+		}
+		delete(m.s.local, key)
+		return nil
+	}
+
+	key := externalKey{provider, externalID}
+	if v, ok := m.s.external[key]; !ok || v.UserID != userID {
+		return internal.ErrNotFound
+	}
+	if count <= 1 {
+		return internal.ErrLastIdentity
+	}
+	delete(m.s.external, key)
+	return nil
+}
+
+// CreateLocalUser creates a user that logs in with email and password.
+// Returns ErrAlreadyExists if the email is taken.
+func (m *HabitzService) CreateLocalUser(ctx context.Context, user *repository.User, passwordHash string) (*repository.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.ToLower(user.Email)
+	if _, ok := m.s.local[key]; ok {
+		return nil, internal.ErrAlreadyExists
+	}
+
+	created := m.s.newUser(*user)
+	m.s.local[key] = repository.LocalCredentials{
+		Email:        user.Email,
+		UserID:       created.ID,
+		PasswordHash: passwordHash,
+		CreatedAt:    now(),
+	}
+
+	result := *user
+	result.ID = created.ID
+	return &result, nil
+}
+
+// LocalCredentials finds the login of an email, ignoring case
+func (m *HabitzService) LocalCredentials(ctx context.Context, email string) (*repository.LocalCredentials, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	creds, ok := m.s.local[strings.ToLower(email)]
+	if !ok {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// CreateLocalCredentials adds an email and password login to an existing user.
+// Returns ErrAlreadyExists if the email is taken or the user has a password already.
+func (m *HabitzService) CreateLocalCredentials(ctx context.Context, userID, email, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.ToLower(email)
+	if _, ok := m.s.local[key]; ok {
+		return internal.ErrAlreadyExists
+	}
+	for _, c := range m.s.local {
+		if c.UserID == userID {
+			return internal.ErrAlreadyExists
+		}
+	}
+
+	m.s.local[key] = repository.LocalCredentials{
+		Email:        email,
+		UserID:       userID,
+		PasswordHash: passwordHash,
+		CreatedAt:    now(),
+	}
+	return nil
+}
+
+func (m *HabitzService) CreateSession(ctx context.Context, session *repository.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.s.sessions[session.ID]; ok {
+		return internal.ErrAlreadyExists
+	}
+	for _, s := range m.s.sessions {
+		if s.RefreshTokenHash == session.RefreshTokenHash {
+			return internal.ErrAlreadyExists
+		}
+	}
+
+	stored := *session
+	stored.RevokedAt = nil
+	m.s.sessions[session.ID] = stored
+	return nil
+}
+
+func (m *HabitzService) Session(ctx context.Context, id string) (*repository.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// SessionWithRefreshToken finds the session a refresh token was issued for
+func (m *HabitzService) SessionWithRefreshToken(ctx context.Context, hash string) (*repository.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, session := range m.s.sessions {
+		if session.RefreshTokenHash == hash {
+			return &session, nil
+		}
+	}
 	return nil, nil
 }
+
+// RotateSession replaces the refresh token of a session, the old token can't be used again.
+// Returns ErrNotFound if the old token was already rotated, e.g. by a concurrent refresh.
+func (m *HabitzService) RotateSession(ctx context.Context, id, oldHash, newHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.s.sessions[id]
+	if !ok || session.RefreshTokenHash != oldHash || session.RevokedAt != nil {
+		return internal.ErrNotFound
+	}
+
+	session.RefreshTokenHash = newHash
+	session.ExpiresAt = expiresAt
+	m.s.sessions[id] = session
+	return nil
+}
+
+// RevokeSession signs out a single device
+func (m *HabitzService) RevokeSession(ctx context.Context, userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.s.sessions[id]
+	if !ok || session.UserID != userID || session.RevokedAt != nil {
+		return internal.ErrNotFound
+	}
+
+	revokedAt := now()
+	session.RevokedAt = &revokedAt
+	m.s.sessions[id] = session
+	return nil
+}
+
+// RevokeAllSessions signs out all devices of the user
+func (m *HabitzService) RevokeAllSessions(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revokedAt := now()
+	for id, session := range m.s.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &revokedAt
+			m.s.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+// MaterializedThrough is the last date the scheduler created entries for, empty if never
+func (m *HabitzService) MaterializedThrough(ctx context.Context, userID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.s.materialized[userID], nil
+}
+
+func (m *HabitzService) SetMaterializedThrough(ctx context.Context, userID, date string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.s.materialized[userID] = date
+	return nil
+}
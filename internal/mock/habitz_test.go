@@ -0,0 +1,59 @@
+package mock_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/mock"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/jfernstad/habitz/web/internal/servicetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	servicetest.Run(t, func(t *testing.T) (internal.HabitzServicer, func()) {
+		return mock.NewHabitzService(), func() {}
+	})
+}
+
+func TestConcurrentEntries(t *testing.T) {
+	hs := mock.NewHabitzService()
+
+	user, err := hs.CreateLocalUser(context.Background(), &repository.User{Email: "alice@example.com"}, "hash")
+	assert.Nil(t, err)
+	habit, err := hs.CreateHabit(context.Background(), user.ID, &repository.Habit{Name: "Water", Kind: repository.HabitKindQuantity, Target: 100})
+	assert.Nil(t, err)
+	entry, err := hs.CreateHabitEntry(context.Background(), user.ID, "2021-03-01", "monday", habit.ID)
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hs.IncrementHabitEntry(context.Background(), user.ID, entry.ID, 1)
+			hs.HabitEntries(context.Background(), user.ID, "2021-03-01")
+		}()
+	}
+	wg.Wait()
+
+	entries, err := hs.HabitEntries(context.Background(), user.ID, "2021-03-01")
+	assert.Nil(t, err)
+	assert.Equal(t, 50.0, entries[0].Value)
+}
+
+func TestReturnsCopies(t *testing.T) {
+	hs := mock.NewHabitzService()
+
+	user, err := hs.CreateLocalUser(context.Background(), &repository.User{Email: "alice@example.com"}, "hash")
+	assert.Nil(t, err)
+	habit, err := hs.CreateHabit(context.Background(), user.ID, &repository.Habit{Name: "Run", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+
+	habit.Name = "Walk"
+	stored, err := hs.Habit(context.Background(), user.ID, habit.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Run", stored.Name)
+}
@@ -0,0 +1,251 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+func (m *habitzService) Habits(ctx context.Context, userID string, includeArchived bool) ([]*repository.Habit, error) {
+	query := psql.Habits().
+		Where(sq.Eq{"user_id": userID})
+
+	if !includeArchived {
+		query = query.Where("archived_at IS NULL")
+	}
+
+	habitsQuery, args, _ := query.OrderBy("id").ToSql()
+
+	m.log(ctx, "Habits: "+habitsQuery+" >> "+userID)
+
+	habits := []*repository.Habit{}
+	if err := m.db.SelectContext(ctx, &habits, habitsQuery, args...); err != nil {
+		return nil, err
+	}
+
+	return habits, nil
+}
+
+func (m *habitzService) Habit(ctx context.Context, userID string, id int) (*repository.Habit, error) {
+	return m.habitWhere(ctx, sq.Eq{"user_id": userID, "id": id})
+}
+
+// HabitWithName finds a habit by name, ignoring case
+func (m *habitzService) HabitWithName(ctx context.Context, userID, name string) (*repository.Habit, error) {
+	return m.habitWhere(ctx, sq.And{sq.Eq{"user_id": userID}, sq.Expr("lower(name) = lower(?)", name)})
+}
+
+// ownsHabit returns ErrNotFound unless the habit belongs to the user
+func (m *habitzService) ownsHabit(ctx context.Context, userID string, id int) error {
+	habit, err := m.Habit(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if habit == nil {
+		return internal.ErrNotFound
+	}
+	return nil
+}
+
+func (m *habitzService) habitWhere(ctx context.Context, where sq.Sqlizer) (*repository.Habit, error) {
+	habitQuery, args, _ := psql.Habits().Where(where).ToSql()
+
+	m.log(ctx, "Habit: "+habitQuery)
+
+	habit := repository.Habit{}
+	if err := m.db.QueryRowxContext(ctx, habitQuery, args...).StructScan(&habit); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &habit, nil
+}
+
+func (m *habitzService) CreateHabit(ctx context.Context, userID string, habit *repository.Habit) (*repository.Habit, error) {
+	insert, args, _ := psql.Insert("habits").
+		Columns("user_id", "name", "description", "created_at", "kind", "target", "unit").
+		Values(userID, habit.Name, habit.Description, time.Now().UTC(), habit.Kind, habit.Target, habit.Unit).
+		Suffix("RETURNING id").
+		ToSql()
+
+	m.log(ctx, "CreateHabit: "+insert+" >> "+userID+", "+habit.Name)
+
+	var id int
+	if err := m.db.GetContext(ctx, &id, insert, args...); err != nil {
+		if isUniqueViolation(err) {
+			return nil, internal.ErrAlreadyExists
+		}
+		return nil, err
+	}
+
+	return m.Habit(ctx, userID, id)
+}
+
+// UpdateHabit renames a habit and changes its target.
+// Templates and entries reference the habit ID, so they keep their history.
+func (m *habitzService) UpdateHabit(ctx context.Context, userID string, habit *repository.Habit) (*repository.Habit, error) {
+	update, args, _ := psql.Update("habits").
+		Set("name", habit.Name).
+		Set("description", habit.Description).
+		Set("kind", habit.Kind).
+		Set("target", habit.Target).
+		Set("unit", habit.Unit).
+		Where(sq.Eq{"user_id": userID, "id": habit.ID}).
+		ToSql()
+
+	m.log(ctx, "UpdateHabit: "+update+" >> "+userID+", "+strconv.Itoa(habit.ID)+", "+habit.Name)
+
+	res, err := m.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, internal.ErrAlreadyExists
+		}
+		return nil, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, internal.ErrNotFound
+	}
+
+	return m.Habit(ctx, userID, habit.ID)
+}
+
+// ArchiveHabit hides a habit from the schedule, its history is kept
+func (m *habitzService) ArchiveHabit(ctx context.Context, userID string, id int, archived bool) (*repository.Habit, error) {
+	var archivedAt *time.Time
+	if archived {
+		now := time.Now().UTC()
+		archivedAt = &now
+	}
+
+	update, args, _ := psql.Update("habits").
+		Set("archived_at", archivedAt).
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	m.log(ctx, "ArchiveHabit: "+update+" >> "+userID+", "+strconv.Itoa(id)+", "+strconv.FormatBool(archived))
+
+	res, err := m.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, internal.ErrNotFound
+	}
+
+	return m.Habit(ctx, userID, id)
+}
+
+// SetHabitType assigns the habit to a type, nil removes the type.
+// Both the habit and the type must belong to the user.
+func (m *habitzService) SetHabitType(ctx context.Context, userID string, id int, typeID *int) error {
+	if typeID != nil {
+		habitType, err := m.HabitType(ctx, userID, *typeID)
+		if err != nil {
+			return err
+		}
+		if habitType == nil {
+			return internal.ErrNotFound
+		}
+	}
+
+	update, args, _ := psql.Update("habits").
+		Set("type_id", typeID).
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	m.log(ctx, "SetHabitType: "+update+" >> "+userID+", "+strconv.Itoa(id))
+
+	res, err := m.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+	return nil
+}
+
+// SetHabitRecurrence schedules the habit with a recurrence rule, counted from `start`.
+// A rule replaces the weekday templates of the habit, an empty rule removes it.
+func (m *habitzService) SetHabitRecurrence(ctx context.Context, userID string, id int, rrule, start string) error {
+	m.log(ctx, "SetHabitRecurrence: >> "+userID+", "+strconv.Itoa(id)+", "+rrule+", "+start)
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	update, args, _ := psql.Update("habits").
+		Set("recurrence", rrule).
+		Set("recurrence_start", start).
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	res, err := tx.ExecContext(ctx, update, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+
+	if rrule != "" {
+		remove, args, _ := psql.Delete("habit_templates").
+			Where(sq.Eq{"user_id": userID, "habit_id": id}).
+			ToSql()
+
+		if _, err := tx.ExecContext(ctx, remove, args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveHabit deletes a habit together with its templates and entries
+func (m *habitzService) RemoveHabit(ctx context.Context, userID string, id int) error {
+	m.log(ctx, "RemoveHabit: >> "+userID+", "+strconv.Itoa(id))
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"habit_templates", "habit_entries"} {
+		remove, args, _ := psql.Delete(table).
+			Where(sq.Eq{"user_id": userID, "habit_id": id}).
+			ToSql()
+
+		if _, err := tx.ExecContext(ctx, remove, args...); err != nil {
+			return err
+		}
+	}
+
+	remove, args, _ := psql.Delete("habits").
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	res, err := tx.ExecContext(ctx, remove, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,554 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/queries"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// psql builds queries with $1, $2, ... placeholders
+var psql = queries.New(sq.Dollar)
+
+type habitzService struct {
+	db    *sqlx.DB
+	debug bool
+}
+
+func NewHabitzService(db *sqlx.DB, debug bool) internal.HabitzServicer {
+	hs := &habitzService{
+		db:    db,
+		debug: debug,
+	}
+
+	applied, err := Migrate(db)
+	if err != nil {
+		log.Fatal("migrate: ", err)
+	}
+
+	for _, mig := range applied {
+		log.Printf("Applied migration %d: %s\n", mig.Version, mig.Description)
+	}
+
+	return hs
+}
+
+// log prints queries in debug mode, with the ID of the request that made them
+func (m *habitzService) log(ctx context.Context, msg string) {
+	if !m.debug {
+		return
+	}
+
+	if reqID := middleware.GetReqID(ctx); reqID != "" {
+		msg = "[" + reqID + "] " + msg
+	}
+	log.Println("sql: " + msg)
+}
+
+// isUniqueViolation is true when a row with the same unique or primary key already exists
+func isUniqueViolation(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
+
+func newUserID() string {
+	return "u" + internal.NewRandomString(12)
+}
+
+func (m *habitzService) Users(ctx context.Context) ([]string, error) {
+	usersQuery, _, _ := psql.Select("firstname").From("users").OrderBy("id").ToSql()
+
+	m.log(ctx, "Users: "+usersQuery)
+
+	users := []string{}
+	if err := m.db.SelectContext(ctx, &users, usersQuery); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (m *habitzService) AllUsers(ctx context.Context) ([]*repository.User, error) {
+	usersQuery, _, _ := psql.Select("*").From("users").OrderBy("id").ToSql()
+
+	m.log(ctx, "AllUsers: "+usersQuery)
+
+	users := []*repository.User{}
+	if err := m.db.SelectContext(ctx, &users, usersQuery); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (m *habitzService) User(ctx context.Context, userID string) (*repository.User, error) {
+	userQuery, args, _ := psql.Select("*").
+		From("users").Where(sq.Eq{"id": userID}).
+		ToSql()
+
+	m.log(ctx, "User: "+userQuery+" >> "+userID)
+
+	return m.userWhere(ctx, userQuery, args...)
+}
+
+func (m *habitzService) userWhere(ctx context.Context, userQuery string, args ...interface{}) (*repository.User, error) {
+	user := repository.User{}
+	if err := m.db.QueryRowxContext(ctx, userQuery, args...).StructScan(&user); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m *habitzService) SetUserTimezone(ctx context.Context, userID, timezone string) error {
+	update, args, _ := psql.Update("users").
+		Set("timezone", timezone).
+		Where(sq.Eq{"id": userID}).
+		ToSql()
+
+	m.log(ctx, "SetUserTimezone: "+update+" >> "+userID+", "+timezone)
+
+	_, err := m.db.ExecContext(ctx, update, args...)
+	return err
+}
+
+func (m *habitzService) UserWithExternalID(ctx context.Context, externalID string, provider string) (*repository.User, error) {
+	userQuery, args, _ := psql.Select("u.*").
+		From("users u").
+		Join("external_users e ON e.user_id = u.id").
+		Where(sq.Eq{"e.id": externalID, "e.provider": provider}).
+		ToSql()
+
+	m.log(ctx, "UserWithExternalID: "+userQuery)
+
+	return m.userWhere(ctx, userQuery, args...)
+}
+
+func (m *habitzService) CreateExternalUser(ctx context.Context, ext *repository.ExternalUser) (*repository.User, error) {
+	newUserID := newUserID()
+
+	m.log(ctx, "CreateExternalUser: >> "+newUserID+", "+ext.Firstname)
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insert, args, _ := psql.Insert("users").
+		Columns("id", "firstname", "lastname", "email", "profile_image", "timezone").
+		Values(newUserID, ext.Firstname, ext.Lastname, ext.Email, ext.ProfileImageURL, ext.Timezone).
+		ToSql()
+
+	if _, err := tx.ExecContext(ctx, insert, args...); err != nil {
+		return nil, err
+	}
+
+	insert, args, _ = psql.Insert("external_users").
+		Columns("id", "provider", "user_id", "email", "linked_at").
+		Values(ext.ExternalID, ext.Provider, newUserID, ext.Email, time.Now().UTC()).
+		ToSql()
+
+	if _, err := tx.ExecContext(ctx, insert, args...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	ext.User.ID = newUserID
+	return &ext.User, nil
+}
+
+func (m *habitzService) Templates(ctx context.Context, userID string) ([]*repository.WeekHabitTemplates, error) {
+	templatesQuery, args, _ := psql.Templates().
+		Where(sq.Eq{"t.user_id": userID}).
+		OrderBy("h.id", "t.weekday").
+		ToSql()
+
+	m.log(ctx, "Templates: "+templatesQuery+" >> "+userID)
+
+	templates := []*repository.WeekdayHabitTemplate{}
+	if err := m.db.SelectContext(ctx, &templates, templatesQuery, args...); err != nil {
+		return nil, err
+	}
+
+	// One template per habit with all of its weekdays, rows of a habit are next to each other
+	userTemplates := []*repository.WeekHabitTemplates{}
+	for _, tmpl := range templates {
+		n := len(userTemplates)
+		if n > 0 && userTemplates[n-1].HabitID == tmpl.HabitID {
+			userTemplates[n-1].Weekdays = append(userTemplates[n-1].Weekdays, tmpl.Weekday)
+			continue
+		}
+
+		userTemplates = append(userTemplates, &repository.WeekHabitTemplates{
+			UserID:   tmpl.UserID,
+			HabitID:  tmpl.HabitID,
+			Habit:    tmpl.Habit,
+			TypeID:   tmpl.TypeID,
+			Weekdays: []string{tmpl.Weekday},
+		})
+	}
+
+	return userTemplates, nil
+}
+
+func (m *habitzService) WeekdayTemplates(ctx context.Context, userID, weekday string) ([]*repository.WeekdayHabitTemplate, error) {
+	templatesQuery, args, _ := psql.Templates().
+		Where(sq.Eq{"t.user_id": userID, "t.weekday": weekday}).
+		OrderBy("h.id").
+		ToSql()
+
+	m.log(ctx, "WeekdayTemplates: "+templatesQuery+" >> "+userID+", "+weekday)
+
+	templates := []*repository.WeekdayHabitTemplate{}
+	if err := m.db.SelectContext(ctx, &templates, templatesQuery, args...); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (m *habitzService) CreateTemplate(ctx context.Context, userID, weekday string, habitID int) error {
+	if err := m.ownsHabit(ctx, userID, habitID); err != nil {
+		return err
+	}
+
+	insert, args, _ := psql.Insert("habit_templates").
+		Columns("user_id", "weekday", "habit_id").Values(userID, weekday, habitID).
+		ToSql()
+
+	m.log(ctx, "CreateTemplate: "+insert+" >> "+userID+", "+weekday+", "+strconv.Itoa(habitID))
+
+	_, err := m.db.ExecContext(ctx, insert, args...)
+	return err
+}
+
+func (m *habitzService) RemoveTemplate(ctx context.Context, userID, weekday string, habitID int) error {
+	remove, args, _ := psql.Delete("habit_templates").
+		Where(sq.Eq{"user_id": userID, "weekday": weekday, "habit_id": habitID}).
+		ToSql()
+
+	m.log(ctx, "RemoveTemplate: "+remove+" >> "+userID+", "+weekday+", "+strconv.Itoa(habitID))
+
+	res, err := m.db.ExecContext(ctx, remove, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+	return nil
+}
+
+func (m *habitzService) RemoveEntry(ctx context.Context, userID string, habitID int, date string) error {
+	remove, args, _ := psql.Delete("habit_entries").
+		Where(sq.Eq{"user_id": userID, "date": date, "habit_id": habitID}).
+		ToSql()
+
+	m.log(ctx, "RemoveEntry: "+remove+" >> "+userID+", "+date+", "+strconv.Itoa(habitID))
+
+	_, err := m.db.ExecContext(ctx, remove, args...)
+	return err
+}
+
+func (m *habitzService) entries(ctx context.Context, entriesQuery string, args ...interface{}) ([]*repository.HabitEntry, error) {
+	entries := []*repository.HabitEntry{}
+	if err := m.db.SelectContext(ctx, &entries, entriesQuery, args...); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (m *habitzService) entry(ctx context.Context, userID string, id int) (*repository.HabitEntry, error) {
+	entryQuery, args, _ := psql.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.id": id}).
+		ToSql()
+
+	entry := repository.HabitEntry{}
+	if err := m.db.QueryRowxContext(ctx, entryQuery, args...).StructScan(&entry); err != nil {
+		return nil, err
+	}
+
+	m.log(ctx, fmt.Sprintf(" - %+v", entry))
+
+	return &entry, nil
+}
+
+// HabitEntry finds an entry of the user, other users entries are not found
+func (m *habitzService) HabitEntry(ctx context.Context, userID string, id int) (*repository.HabitEntry, error) {
+	m.log(ctx, "HabitEntry: "+userID+", "+strconv.Itoa(id))
+
+	entry, err := m.entry(ctx, userID, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return entry, err
+}
+
+func (m *habitzService) HabitEntries(ctx context.Context, userID string, date string) ([]*repository.HabitEntry, error) {
+	entriesQuery, args, _ := psql.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.date": date}).
+		OrderBy("e.id").
+		ToSql()
+
+	m.log(ctx, "HabitEntries: "+entriesQuery+" >> "+userID+", "+date)
+
+	return m.entries(ctx, entriesQuery, args...)
+}
+
+// EachHabitEntry calls fn with every entry of the user, oldest first, one row at a time.
+// Stops at the first error returned by fn.
+func (m *habitzService) EachHabitEntry(ctx context.Context, userID string, fn func(*repository.HabitEntry) error) error {
+	entriesQuery, args, _ := psql.Entries().
+		Where(sq.Eq{"e.user_id": userID}).
+		OrderBy("e.date", "e.id").
+		ToSql()
+
+	m.log(ctx, "EachHabitEntry: "+entriesQuery+" >> "+userID)
+
+	rows, err := m.db.QueryxContext(ctx, entriesQuery, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry repository.HabitEntry
+		if err = rows.StructScan(&entry); err != nil {
+			return err
+		}
+		if err = fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// HabitEntriesBetween returns all entries from `from` to `to`, both dates included.
+// Entries are ordered by date, use limit and offset to page through them.
+func (m *habitzService) HabitEntriesBetween(ctx context.Context, userID string, from, to string, limit, offset int) ([]*repository.HabitEntry, error) {
+	entriesQuery, args, _ := psql.Entries().
+		Where(sq.Eq{"e.user_id": userID}).
+		Where(sq.GtOrEq{"e.date": from}).
+		Where(sq.LtOrEq{"e.date": to}).
+		OrderBy("e.date", "e.id").
+		Limit(uint64(limit)).
+		Offset(uint64(offset)).
+		ToSql()
+
+	m.log(ctx, "HabitEntriesBetween: "+entriesQuery+" >> "+userID+", "+from+", "+to)
+
+	return m.entries(ctx, entriesQuery, args...)
+}
+
+func (m *habitzService) CreateHabitEntry(ctx context.Context, userID, date, weekday string, habitID int) (*repository.HabitEntry, error) {
+	if err := m.ownsHabit(ctx, userID, habitID); err != nil {
+		return nil, err
+	}
+
+	insert, args, _ := psql.Insert("habit_entries").
+		Columns("user_id", "weekday", "habit_id", "date", "complete").
+		Values(userID, weekday, habitID, date, false).
+		Suffix("RETURNING id").
+		ToSql()
+
+	m.log(ctx, "CreateHabitEntry: "+insert+" >> "+userID+", "+date+", "+weekday+", "+strconv.Itoa(habitID))
+
+	var id int
+	err := m.db.GetContext(ctx, &id, insert, args...)
+	if isUniqueViolation(err) {
+		return nil, internal.ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return m.entry(ctx, userID, id)
+}
+
+// EnsureHabitEntries creates the missing entries of the habitz on `date` in one transaction.
+// Existing entries are left as they are, so concurrent calls don't create duplicates.
+// Only habitz of the user get entries. Returns all entries of that date.
+func (m *habitzService) EnsureHabitEntries(ctx context.Context, userID, date string, habitIDs []int) ([]*repository.HabitEntry, error) {
+	weekday, err := internal.WeekdayOf(date)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if len(habitIDs) > 0 {
+		insert, args, _ := psql.Insert("habit_entries").
+			Columns("user_id", "weekday", "habit_id", "date", "complete").
+			Select(sq.Select().
+				Column("?", userID).
+				Column("?", weekday).
+				Column("id").
+				Column("?", date).
+				Column("false").
+				From("habits").
+				Where(sq.Eq{"user_id": userID, "id": habitIDs})).
+			Suffix("ON CONFLICT DO NOTHING").
+			ToSql()
+
+		m.log(ctx, "EnsureHabitEntries: "+insert+" >> "+userID+", "+date+", "+fmt.Sprint(habitIDs))
+
+		if _, err := tx.ExecContext(ctx, insert, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	entriesQuery, args, _ := psql.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.date": date}).
+		OrderBy("e.id").
+		ToSql()
+
+	entries := []*repository.HabitEntry{}
+	if err := tx.SelectContext(ctx, &entries, entriesQuery, args...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// UpdateHabitEntry completes an entry of the user, other users entries are not found
+func (m *habitzService) UpdateHabitEntry(ctx context.Context, userID string, id int, complete bool) (*repository.HabitEntry, error) {
+	query := psql.Update("habit_entries").
+		Set("complete", complete)
+
+	// Also update timestamp
+	if complete {
+		query = query.Set("complete_at", time.Now().UTC())
+	}
+
+	update, args, _ := query.
+		Where(sq.Eq{"user_id": userID, "id": id}).ToSql()
+
+	m.log(ctx, "UpdateHabitEntry: "+update+" >> "+userID+", "+strconv.Itoa(id)+", "+strconv.FormatBool(complete))
+
+	res, err := m.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, internal.ErrNotFound
+	}
+
+	return m.entry(ctx, userID, id)
+}
+
+// SetHabitEntryValue records the value of a quantitative entry.
+// The entry is complete once the value reaches the target of the habit.
+func (m *habitzService) SetHabitEntryValue(ctx context.Context, userID string, id int, value float64) (*repository.HabitEntry, error) {
+	return m.updateHabitEntryValue(ctx, userID, id, sq.Expr("CAST(? AS DOUBLE PRECISION)", value))
+}
+
+// IncrementHabitEntry adds `delta` to the value of the entry, e.g. one more glass of water
+func (m *habitzService) IncrementHabitEntry(ctx context.Context, userID string, id int, delta float64) (*repository.HabitEntry, error) {
+	return m.updateHabitEntryValue(ctx, userID, id, sq.Expr("value + CAST(? AS DOUBLE PRECISION)", delta))
+}
+
+func (m *habitzService) updateHabitEntryValue(ctx context.Context, userID string, id int, value sq.Sqlizer) (*repository.HabitEntry, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	valueSQL, valueArgs, _ := value.ToSql()
+	update, args, _ := psql.Update("habit_entries").
+		Set("value", sq.Expr("GREATEST(0, "+valueSQL+")", valueArgs...)).
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	m.log(ctx, "updateHabitEntryValue: "+update+" >> "+userID+", "+strconv.Itoa(id))
+
+	res, err := tx.ExecContext(ctx, update, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, internal.ErrNotFound
+	}
+
+	// Complete once the target is reached, `complete` on the right hand side is the previous value
+	target := "(SELECT target FROM habits WHERE habits.id = habit_entries.habit_id)"
+	update, args, _ = psql.Update("habit_entries").
+		Set("complete_at", sq.Expr("CASE WHEN NOT complete AND value >= "+target+" THEN ? ELSE complete_at END", time.Now().UTC())).
+		Set("complete", sq.Expr("value >= "+target)).
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	if _, err := tx.ExecContext(ctx, update, args...); err != nil {
+		return nil, err
+	}
+
+	entryQuery, args, _ := psql.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.id": id}).
+		ToSql()
+
+	entry := repository.HabitEntry{}
+	if err := tx.QueryRowxContext(ctx, entryQuery, args...).StructScan(&entry); err != nil {
+		return nil, err
+	}
+
+	m.log(ctx, fmt.Sprintf(" - %+v", entry))
+
+	return &entry, tx.Commit()
+}
+
+// MaterializedThrough is the last date the scheduler created entries for, empty if never
+func (m *habitzService) MaterializedThrough(ctx context.Context, userID string) (string, error) {
+	query, args, _ := psql.Select("materialized_through").
+		From("scheduler_runs").
+		Where(sq.Eq{"user_id": userID}).
+		ToSql()
+
+	var date string
+	if err := m.db.GetContext(ctx, &date, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return date, nil
+}
+
+func (m *habitzService) SetMaterializedThrough(ctx context.Context, userID, date string) error {
+	query, args, _ := psql.Insert("scheduler_runs").
+		Columns("user_id", "materialized_through").
+		Values(userID, date).
+		Suffix("ON CONFLICT(user_id) DO UPDATE SET materialized_through = excluded.materialized_through").
+		ToSql()
+
+	m.log(ctx, "SetMaterializedThrough: "+query+" >> "+userID+", "+date)
+
+	_, err := m.db.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// Identities lists the external identities and local credentials a user can log in with
+func (m *habitzService) Identities(ctx context.Context, userID string) ([]*repository.Identity, error) {
+	externalQuery, args, _ := psql.Select("provider", "id AS external_id", "user_id", "email", "linked_at").
+		From("external_users").
+		Where(sq.Eq{"user_id": userID}).
+		OrderBy("linked_at NULLS FIRST", "provider").
+		ToSql()
+
+	m.log(ctx, "Identities: "+externalQuery+" >> "+userID)
+
+	identities := []*repository.Identity{}
+	if err := m.db.SelectContext(ctx, &identities, externalQuery, args...); err != nil {
+		return nil, err
+	}
+
+	creds := []*repository.LocalCredentials{}
+	localQuery, args, _ := psql.Select("email", "user_id", "password_hash", "created_at").
+		From("local_credentials").
+		Where(sq.Eq{"user_id": userID}).
+		ToSql()
+
+	if err := m.db.SelectContext(ctx, &creds, localQuery, args...); err != nil {
+		return nil, err
+	}
+
+	for _, c := range creds {
+		createdAt := c.CreatedAt
+		identities = append(identities, &repository.Identity{
+			Provider:   repository.ProviderLocal,
+			ExternalID: c.Email,
+			UserID:     c.UserID,
+			Email:      c.Email,
+			LinkedAt:   &createdAt,
+		})
+	}
+
+	return identities, nil
+}
+
+// LinkIdentity lets a user log in with another provider.
+// Returns ErrAlreadyExists if the identity belongs to an account already.
+func (m *habitzService) LinkIdentity(ctx context.Context, userID string, ext *repository.ExternalUser) error {
+	insert, args, _ := psql.Insert("external_users").
+		Columns("id", "provider", "user_id", "email", "linked_at").
+		Values(ext.ExternalID, ext.Provider, userID, ext.Email, time.Now().UTC()).
+		ToSql()
+
+	m.log(ctx, "LinkIdentity: "+insert+" >> "+userID+", "+ext.Provider)
+
+	if _, err := m.db.ExecContext(ctx, insert, args...); err != nil {
+		if isUniqueViolation(err) {
+			return internal.ErrAlreadyExists
+		}
+		return err
+	}
+	return nil
+}
+
+// UnlinkIdentity removes an identity, or the local credentials for the "local" provider.
+// Returns ErrLastIdentity rather than leaving the user without a way to log in.
+func (m *habitzService) UnlinkIdentity(ctx context.Context, userID, provider, externalID string) error {
+	m.log(ctx, "UnlinkIdentity: >> "+userID+", "+provider)
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Concurrent unlinks of the user wait for each other, or both could count the other identity
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return err
+	}
+
+	count, err := identityCount(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	var remove sq.DeleteBuilder
+	if provider == repository.ProviderLocal {
+		remove = psql.Delete("local_credentials").
+			Where(sq.Eq{"user_id": userID}).
+			Where("lower(email) = lower(?)", externalID)
+	} else {
+		remove = psql.Delete("external_users").Where(sq.Eq{"user_id": userID, "provider": provider, "id": externalID})
+	}
+
+	// Check that the identity exists before refusing to remove it
+	deleteQuery, args, _ := remove.ToSql()
+	res, err := tx.ExecContext(ctx, deleteQuery, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+	if count <= 1 {
+		return internal.ErrLastIdentity
+	}
+
+	return tx.Commit()
+}
+
+func identityCount(ctx context.Context, tx *sqlx.Tx, userID string) (int, error) {
+	var count int
+	err := tx.GetContext(ctx, &count, `SELECT
+		(SELECT COUNT(*) FROM external_users WHERE user_id = $1) +
+		(SELECT COUNT(*) FROM local_credentials WHERE user_id = $1)`, userID)
+	return count, err
+}
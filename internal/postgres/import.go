@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// Only the first conflicts are listed in the result, all of them are counted
+const maxReportedConflicts = 100
+
+type importedHabit struct {
+	id         int
+	recurrence string
+}
+
+// Import adds habitz, templates and entries in a single transaction.
+// Habitz are matched by name, entries that already exist are conflicts and left as they are.
+// A dry run does the same work and rolls it back.
+func (m *habitzService) Import(ctx context.Context, userID string, data *repository.HabitImport, dryRun bool) (*repository.ImportResult, error) {
+	m.log(ctx, "Import: >> "+userID+", "+strconv.Itoa(len(data.Habits))+" habitz, "+
+		strconv.Itoa(len(data.Entries))+" entries, dry run "+strconv.FormatBool(dryRun))
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &repository.ImportResult{
+		DryRun:         dryRun,
+		HabitsCreated:  []string{},
+		HabitsExisting: []string{},
+		Conflicts:      []repository.ImportConflict{},
+	}
+
+	habits, err := importHabitz(ctx, tx, userID, data.Habits, result)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range data.Entries {
+		habit, ok := habits[strings.ToLower(entry.Habit)]
+		if !ok {
+			addConflict(result, entry, "unknown habit")
+			continue
+		}
+
+		created, err := importEntry(ctx, tx, userID, habit.id, entry)
+		if err != nil {
+			return nil, err
+		}
+		if !created {
+			addConflict(result, entry, "entry exists")
+			continue
+		}
+		result.EntriesCreated++
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// importHabitz creates the missing habitz and their templates, returns all habitz by lowercase name
+func importHabitz(ctx context.Context, tx *sqlx.Tx, userID string, imported []*repository.ImportedHabit, result *repository.ImportResult) (map[string]importedHabit, error) {
+	existing := []*repository.Habit{}
+	habitsQuery, args, _ := psql.Select("id", "name", "recurrence").
+		From("habits").
+		Where(sq.Eq{"user_id": userID}).
+		ToSql()
+	if err := tx.SelectContext(ctx, &existing, habitsQuery, args...); err != nil {
+		return nil, err
+	}
+
+	habits := map[string]importedHabit{}
+	for _, h := range existing {
+		habits[strings.ToLower(h.Name)] = importedHabit{id: h.ID, recurrence: h.Recurrence}
+	}
+
+	for _, h := range imported {
+		key := strings.ToLower(h.Name)
+
+		habit, ok := habits[key]
+		if ok {
+			result.HabitsExisting = append(result.HabitsExisting, h.Name)
+		} else {
+			createdAt := h.CreatedAt
+			if createdAt.IsZero() {
+				createdAt = time.Now()
+			}
+
+			insert, args, _ := psql.Insert("habits").
+				Columns("user_id", "name", "description", "created_at", "archived_at", "kind", "target", "unit",
+					"recurrence", "recurrence_start").
+				Values(userID, h.Name, h.Description, createdAt.UTC(), h.ArchivedAt, h.Kind, h.Target, h.Unit,
+					h.Recurrence, h.RecurrenceStart).
+				Suffix("RETURNING id").
+				ToSql()
+
+			var id int
+			if err := tx.GetContext(ctx, &id, insert, args...); err != nil {
+				return nil, err
+			}
+
+			habit = importedHabit{id: id, recurrence: h.Recurrence}
+			habits[key] = habit
+			result.HabitsCreated = append(result.HabitsCreated, h.Name)
+		}
+
+		// Habitz with a recurrence rule don't use templates
+		if habit.recurrence != "" {
+			continue
+		}
+
+		for _, weekday := range h.Weekdays {
+			insert, args, _ := psql.Insert("habit_templates").
+				Columns("user_id", "weekday", "habit_id").
+				Values(userID, weekday, habit.id).
+				Suffix("ON CONFLICT DO NOTHING").
+				ToSql()
+
+			res, err := tx.ExecContext(ctx, insert, args...)
+			if err != nil {
+				return nil, err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				result.TemplatesCreated++
+			}
+		}
+	}
+
+	return habits, nil
+}
+
+// importEntry creates the entry unless the habit has an entry that day already
+func importEntry(ctx context.Context, tx *sqlx.Tx, userID string, habitID int, entry *repository.ImportedEntry) (bool, error) {
+	var count int
+	err := tx.GetContext(ctx, &count, "SELECT COUNT(*) FROM habit_entries WHERE user_id = $1 AND habit_id = $2 AND date = $3",
+		userID, habitID, entry.Date)
+	if err != nil || count > 0 {
+		return false, err
+	}
+
+	weekday, err := internal.WeekdayOf(entry.Date)
+	if err != nil {
+		return false, err
+	}
+
+	insert, args, _ := psql.Insert("habit_entries").
+		Columns("user_id", "weekday", "habit_id", "date", "complete", "complete_at", "value").
+		Values(userID, weekday, habitID, entry.Date, entry.Complete, entry.CompleteAt, entry.Value).
+		ToSql()
+
+	if _, err := tx.ExecContext(ctx, insert, args...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func addConflict(result *repository.ImportResult, entry *repository.ImportedEntry, reason string) {
+	result.EntriesConflicting++
+	if len(result.Conflicts) < maxReportedConflicts {
+		result.Conflicts = append(result.Conflicts, repository.ImportConflict{Habit: entry.Habit, Date: entry.Date, Reason: reason})
+	}
+}
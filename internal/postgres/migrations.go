@@ -0,0 +1,205 @@
+package postgres
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration is a versioned schema change.
+// Never change a released migration, add a new one to the end of `migrations`.
+type Migration struct {
+	Version     int
+	Description string
+	statements  []string
+}
+
+const createSchemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version(
+	version INTEGER PRIMARY KEY,
+	description TEXT,
+	applied_at TIMESTAMPTZ
+);
+`
+
+// The schema matches the SQLite schema after all of its migrations.
+// Names and emails are unique ignoring case, like COLLATE NOCASE in SQLite.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		statements: []string{
+			`CREATE TABLE users(
+				id TEXT PRIMARY KEY,
+				firstname TEXT NOT NULL DEFAULT '',
+				lastname TEXT NOT NULL DEFAULT '',
+				email TEXT NOT NULL DEFAULT '',
+				profile_image TEXT NOT NULL DEFAULT '',
+				timezone TEXT NOT NULL DEFAULT '',
+				preferences JSONB NOT NULL DEFAULT '{}',
+				is_admin BOOLEAN NOT NULL DEFAULT false
+			)`,
+			`CREATE TABLE external_users(
+				id TEXT NOT NULL,
+				provider TEXT NOT NULL,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				email TEXT NOT NULL DEFAULT '',
+				linked_at TIMESTAMPTZ,
+				PRIMARY KEY (provider, id)
+			)`,
+			`CREATE INDEX external_users_user_id ON external_users(user_id)`,
+			`CREATE TABLE local_credentials(
+				email TEXT NOT NULL,
+				user_id TEXT NOT NULL UNIQUE REFERENCES users(id),
+				password_hash TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX local_credentials_email ON local_credentials(lower(email))`,
+			`CREATE TABLE sessions(
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				refresh_token_hash TEXT NOT NULL UNIQUE,
+				created_at TIMESTAMPTZ NOT NULL,
+				expires_at TIMESTAMPTZ NOT NULL,
+				revoked_at TIMESTAMPTZ
+			)`,
+			`CREATE INDEX sessions_user_id ON sessions(user_id)`,
+			`CREATE TABLE habit_types(
+				id SERIAL PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				name TEXT NOT NULL,
+				color TEXT NOT NULL DEFAULT '',
+				sort_order INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE UNIQUE INDEX habit_types_user_name ON habit_types(user_id, lower(name))`,
+			`CREATE TABLE habits(
+				id SERIAL PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				name TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMPTZ NOT NULL,
+				archived_at TIMESTAMPTZ,
+				type_id INTEGER REFERENCES habit_types(id),
+				kind TEXT NOT NULL DEFAULT 'check',
+				target DOUBLE PRECISION NOT NULL DEFAULT 1,
+				unit TEXT NOT NULL DEFAULT '',
+				recurrence TEXT NOT NULL DEFAULT '',
+				recurrence_start TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE UNIQUE INDEX habits_user_name ON habits(user_id, lower(name))`,
+			`CREATE TABLE habit_templates(
+				user_id TEXT NOT NULL REFERENCES users(id),
+				weekday TEXT NOT NULL,
+				habit_id INTEGER NOT NULL REFERENCES habits(id),
+				PRIMARY KEY (user_id, weekday, habit_id)
+			)`,
+			`CREATE TABLE habit_entries(
+				id SERIAL PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users(id),
+				weekday TEXT NOT NULL,
+				date TEXT NOT NULL,
+				habit_id INTEGER NOT NULL REFERENCES habits(id),
+				complete BOOLEAN NOT NULL DEFAULT false,
+				complete_at TIMESTAMPTZ,
+				value DOUBLE PRECISION NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX habit_entries_user_date ON habit_entries(user_id, date)`,
+			`CREATE TABLE scheduler_runs(
+				user_id TEXT PRIMARY KEY REFERENCES users(id),
+				materialized_through TEXT NOT NULL
+			)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "one entry per habit and day",
+		statements: []string{
+			// Keep the most complete entry of duplicates, they were created by concurrent requests
+			`DELETE FROM habit_entries WHERE id NOT IN (
+				SELECT (SELECT d.id FROM habit_entries d
+					WHERE d.user_id = e.user_id AND d.habit_id = e.habit_id AND d.date = e.date
+					ORDER BY d.complete DESC, d.value DESC, d.id LIMIT 1)
+				FROM habit_entries e GROUP BY e.user_id, e.habit_id, e.date
+			)`,
+			`CREATE UNIQUE INDEX habit_entries_user_habit_date ON habit_entries(user_id, habit_id, date)`,
+		},
+	},
+}
+
+// SchemaVersion is the latest migration applied to the database, 0 for a new database
+func SchemaVersion(db *sqlx.DB) (int, error) {
+	if _, err := db.Exec(createSchemaVersionTable); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
+	return version, err
+}
+
+// PendingMigrations lists the migrations not yet applied to the database
+func PendingMigrations(db *sqlx.DB) ([]Migration, error) {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, mig := range migrations {
+		if mig.Version > version {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations in a single transaction.
+// Either every migration is applied or none of them.
+func Migrate(db *sqlx.DB) ([]Migration, error) {
+	pending, err := PendingMigrations(db)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Servers starting at the same time wait for each other
+	if _, err := tx.Exec("LOCK TABLE schema_version IN EXCLUSIVE MODE"); err != nil {
+		return nil, err
+	}
+
+	var version int
+	if err := tx.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version"); err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, mig := range pending {
+		if mig.Version <= version {
+			continue
+		}
+
+		for _, stmt := range mig.statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return nil, fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Description, err)
+			}
+		}
+
+		_, err := tx.Exec("INSERT INTO schema_version(version, description, applied_at) VALUES ($1, $2, $3)",
+			mig.Version, mig.Description, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		applied = append(applied, mig)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
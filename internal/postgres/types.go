@@ -0,0 +1,124 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+func (m *habitzService) HabitTypes(ctx context.Context, userID string) ([]*repository.HabitType, error) {
+	typesQuery, args, _ := psql.HabitTypes().
+		Where(sq.Eq{"user_id": userID}).
+		OrderBy("sort_order", "lower(name)").
+		ToSql()
+
+	m.log(ctx, "HabitTypes: "+typesQuery+" >> "+userID)
+
+	types := []*repository.HabitType{}
+	if err := m.db.SelectContext(ctx, &types, typesQuery, args...); err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+func (m *habitzService) HabitType(ctx context.Context, userID string, id int) (*repository.HabitType, error) {
+	typeQuery, args, _ := psql.HabitTypes().
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	m.log(ctx, "HabitType: "+typeQuery+" >> "+userID+", "+strconv.Itoa(id))
+
+	habitType := repository.HabitType{}
+	if err := m.db.QueryRowxContext(ctx, typeQuery, args...).StructScan(&habitType); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &habitType, nil
+}
+
+func (m *habitzService) CreateHabitType(ctx context.Context, userID string, habitType *repository.HabitType) (*repository.HabitType, error) {
+	insert, args, _ := psql.Insert("habit_types").
+		Columns("user_id", "name", "color", "sort_order").
+		Values(userID, habitType.Name, habitType.Color, habitType.SortOrder).
+		Suffix("RETURNING id").
+		ToSql()
+
+	m.log(ctx, "CreateHabitType: "+insert+" >> "+userID+", "+habitType.Name)
+
+	var id int
+	if err := m.db.GetContext(ctx, &id, insert, args...); err != nil {
+		if isUniqueViolation(err) {
+			return nil, internal.ErrAlreadyExists
+		}
+		return nil, err
+	}
+
+	return m.HabitType(ctx, userID, id)
+}
+
+func (m *habitzService) UpdateHabitType(ctx context.Context, userID string, habitType *repository.HabitType) (*repository.HabitType, error) {
+	update, args, _ := psql.Update("habit_types").
+		Set("name", habitType.Name).
+		Set("color", habitType.Color).
+		Set("sort_order", habitType.SortOrder).
+		Where(sq.Eq{"user_id": userID, "id": habitType.ID}).
+		ToSql()
+
+	m.log(ctx, "UpdateHabitType: "+update+" >> "+userID+", "+strconv.Itoa(habitType.ID))
+
+	res, err := m.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, internal.ErrAlreadyExists
+		}
+		return nil, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, internal.ErrNotFound
+	}
+
+	return m.HabitType(ctx, userID, habitType.ID)
+}
+
+// RemoveHabitType deletes the type, its habitz are kept without a type
+func (m *habitzService) RemoveHabitType(ctx context.Context, userID string, id int) error {
+	m.log(ctx, "RemoveHabitType: >> "+userID+", "+strconv.Itoa(id))
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	update, args, _ := psql.Update("habits").
+		Set("type_id", nil).
+		Where(sq.Eq{"user_id": userID, "type_id": id}).
+		ToSql()
+
+	if _, err := tx.ExecContext(ctx, update, args...); err != nil {
+		return err
+	}
+
+	remove, args, _ := psql.Delete("habit_types").
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	res, err := tx.ExecContext(ctx, remove, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// UpdateUser stores the profile of a user, the email and admin flag aren't changed
+func (m *habitzService) UpdateUser(ctx context.Context, user *repository.User) (*repository.User, error) {
+	update, args, _ := psql.Update("users").
+		Set("firstname", user.Firstname).
+		Set("lastname", user.Lastname).
+		Set("profile_image", user.ProfileImageURL).
+		Set("timezone", user.Timezone).
+		Set("preferences", user.Preferences).
+		Where(sq.Eq{"id": user.ID}).
+		ToSql()
+
+	m.log(ctx, "UpdateUser: "+update+" >> "+user.ID)
+
+	res, err := m.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, internal.ErrNotFound
+	}
+
+	return m.User(ctx, user.ID)
+}
+
+func (m *habitzService) SetUserAdmin(ctx context.Context, userID string, admin bool) error {
+	update, args, _ := psql.Update("users").
+		Set("is_admin", admin).
+		Where(sq.Eq{"id": userID}).
+		ToSql()
+
+	m.log(ctx, "SetUserAdmin: "+update+" >> "+userID)
+
+	res, err := m.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+	return nil
+}
+
+// userTables have a user_id column, the user is removed from all of them.
+// Tables are listed before the tables they reference.
+var userTables = []string{
+	"habit_entries",
+	"habit_templates",
+	"habits",
+	"habit_types",
+	"scheduler_runs",
+	"sessions",
+	"external_users",
+	"local_credentials",
+}
+
+// RemoveUser deletes the account and everything that belongs to it
+func (m *habitzService) RemoveUser(ctx context.Context, userID string) error {
+	m.log(ctx, "RemoveUser: >> "+userID)
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range userTables {
+		remove, args, _ := psql.Delete(table).Where(sq.Eq{"user_id": userID}).ToSql()
+		if _, err := tx.ExecContext(ctx, remove, args...); err != nil {
+			return err
+		}
+	}
+
+	remove, args, _ := psql.Delete("users").Where(sq.Eq{"id": userID}).ToSql()
+	res, err := tx.ExecContext(ctx, remove, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,48 @@
+// Package queries builds the SQL the databases have in common.
+// The databases only differ in their placeholders, `?` for SQLite and `$1` for Postgres.
+package queries
+
+import sq "github.com/Masterminds/squirrel"
+
+// Builder starts statements with the placeholders of a database
+type Builder struct {
+	sq.StatementBuilderType
+}
+
+func New(format sq.PlaceholderFormat) Builder {
+	return Builder{sq.StatementBuilder.PlaceholderFormat(format)}
+}
+
+// Habits selects the columns of repository.Habit
+func (b Builder) Habits() sq.SelectBuilder {
+	return b.Select("id", "user_id", "name", "description", "created_at", "archived_at", "type_id", "kind", "target", "unit", "recurrence", "recurrence_start").
+		From("habits")
+}
+
+// HabitTypes selects the columns of repository.HabitType
+func (b Builder) HabitTypes() sq.SelectBuilder {
+	return b.Select("id", "user_id", "name", "color", "sort_order").
+		From("habit_types")
+}
+
+// Templates selects templates of active habitz together with the habit name, aliased `t` and `h`
+func (b Builder) Templates() sq.SelectBuilder {
+	return b.Select("t.user_id", "t.weekday", "t.habit_id", "h.name AS habit", "h.type_id").
+		From("habit_templates t").
+		Join("habits h ON h.id = t.habit_id").
+		Where("h.archived_at IS NULL")
+}
+
+// Entries selects habit entries together with the current name of their habit, aliased `e` and `h`
+func (b Builder) Entries() sq.SelectBuilder {
+	return b.Select("e.id", "e.user_id", "e.weekday", "e.date", "e.habit_id", "h.name AS habit", "h.type_id",
+		"h.kind", "e.value", "h.target", "h.unit", "e.complete", "e.complete_at").
+		From("habit_entries e").
+		Join("habits h ON h.id = e.habit_id")
+}
+
+// Sessions selects the columns of repository.Session
+func (b Builder) Sessions() sq.SelectBuilder {
+	return b.Select("id", "user_id", "refresh_token_hash", "created_at", "expires_at", "revoked_at").
+		From("sessions")
+}
@@ -0,0 +1,27 @@
+package queries_test
+
+import (
+	"testing"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jfernstad/habitz/web/internal/queries"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceholders(t *testing.T) {
+	for _, tc := range []struct {
+		format sq.PlaceholderFormat
+		where  string
+	}{
+		{sq.Question, "WHERE h.archived_at IS NULL AND t.user_id = ? AND t.weekday = ?"},
+		{sq.Dollar, "WHERE h.archived_at IS NULL AND t.user_id = $1 AND t.weekday = $2"},
+	} {
+		query, args, err := queries.New(tc.format).Templates().
+			Where(sq.Eq{"t.user_id": "u1"}).
+			Where(sq.Eq{"t.weekday": "monday"}).
+			ToSql()
+		assert.Nil(t, err)
+		assert.Contains(t, query, tc.where)
+		assert.Equal(t, []interface{}{"u1", "monday"}, args)
+	}
+}
@@ -0,0 +1,314 @@
+// Package recurrence implements the subset of RFC 5545 recurrence rules (RRULE) used by habitz.
+//
+// Supported parts are FREQ (DAILY, WEEKLY, MONTHLY), INTERVAL, BYDAY and BYMONTHDAY.
+// The extension X-TIMES=n makes a rule a quota, e.g. "FREQ=WEEKLY;X-TIMES=3" is three times a week on any days.
+//
+// Examples:
+//
+//	FREQ=DAILY;INTERVAL=3             every third day
+//	FREQ=WEEKLY;INTERVAL=2;BYDAY=MO   every other monday
+//	FREQ=MONTHLY;BYDAY=1MO            first monday of the month
+//	FREQ=MONTHLY;BYMONTHDAY=-1        last day of the month
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Day is a BYDAY value, e.g. "MO" or "1MO" (first monday) or "-1FR" (last friday)
+type Day struct {
+	Weekday time.Weekday
+	N       int // 0 means every such weekday
+}
+
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	ByDay      []Day
+	ByMonthDay []int
+	Times      int // X-TIMES, number of times per period on any days. 0 if not a quota.
+}
+
+// Parse reads a recurrence rule, with or without the "RRULE:" prefix
+func Parse(rrule string) (*Rule, error) {
+	rrule = strings.TrimPrefix(strings.TrimSpace(strings.ToUpper(rrule)), "RRULE:")
+	if rrule == "" {
+		return nil, errors.New("empty rule")
+	}
+
+	r := &Rule{Interval: 1}
+
+	for _, part := range strings.Split(rrule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid part %q", part)
+		}
+		key, value := kv[0], kv[1]
+
+		switch key {
+		case "FREQ":
+			switch f := Frequency(value); f {
+			case Daily, Weekly, Monthly:
+				r.Freq = f
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			i, err := strconv.Atoi(value)
+			if err != nil || i < 1 {
+				return nil, fmt.Errorf("invalid INTERVAL %q", value)
+			}
+			r.Interval = i
+		case "BYDAY":
+			for _, v := range strings.Split(value, ",") {
+				day, err := parseDay(v)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, day)
+			}
+		case "BYMONTHDAY":
+			for _, v := range strings.Split(value, ",") {
+				d, err := strconv.Atoi(v)
+				if err != nil || d == 0 || d < -31 || d > 31 {
+					return nil, fmt.Errorf("invalid BYMONTHDAY %q", v)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, d)
+			}
+		case "X-TIMES":
+			t, err := strconv.Atoi(value)
+			if err != nil || t < 1 {
+				return nil, fmt.Errorf("invalid X-TIMES %q", value)
+			}
+			r.Times = t
+		default:
+			return nil, fmt.Errorf("unsupported part %q", key)
+		}
+	}
+
+	return r, r.validate()
+}
+
+func parseDay(v string) (Day, error) {
+	if len(v) < 2 {
+		return Day{}, fmt.Errorf("invalid BYDAY %q", v)
+	}
+
+	weekday, ok := weekdays[v[len(v)-2:]]
+	if !ok {
+		return Day{}, fmt.Errorf("invalid BYDAY %q", v)
+	}
+
+	day := Day{Weekday: weekday}
+	if ordinal := v[:len(v)-2]; ordinal != "" {
+		n, err := strconv.Atoi(ordinal)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return Day{}, fmt.Errorf("invalid BYDAY %q", v)
+		}
+		day.N = n
+	}
+	return day, nil
+}
+
+func (r *Rule) validate() error {
+	if r.Freq == "" {
+		return errors.New("FREQ is required")
+	}
+
+	if len(r.ByMonthDay) > 0 && r.Freq != Monthly {
+		return errors.New("BYMONTHDAY is only supported with FREQ=MONTHLY")
+	}
+
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != Monthly {
+			return errors.New("BYDAY with an ordinal is only supported with FREQ=MONTHLY")
+		}
+	}
+
+	if r.Times > 0 {
+		if r.Freq == Daily {
+			return errors.New("X-TIMES is not supported with FREQ=DAILY")
+		}
+		if len(r.ByDay) > 0 || len(r.ByMonthDay) > 0 {
+			return errors.New("X-TIMES can't be combined with BYDAY or BYMONTHDAY")
+		}
+	}
+
+	return nil
+}
+
+// IsQuota is true for rules that are due a number of times per period, on any days
+func (r *Rule) IsQuota() bool {
+	return r.Times > 0
+}
+
+// Occurs reports whether `date` is an occurrence of a rule that started on `start`.
+// For quota rules every day of an active period is an occurrence.
+// Only the date part of the times are used.
+func (r *Rule) Occurs(start, date time.Time) bool {
+	start, date = truncate(start), truncate(date)
+	if date.Before(start) {
+		return false
+	}
+
+	switch r.Freq {
+	case Daily:
+		days := int(date.Sub(start).Hours() / 24)
+		return days%r.Interval == 0 && r.matchesWeekday(date)
+
+	case Weekly:
+		weeks := int(weekStart(date).Sub(weekStart(start)).Hours() / (24 * 7))
+		if weeks%r.Interval != 0 {
+			return false
+		}
+		if r.IsQuota() {
+			return true
+		}
+		if len(r.ByDay) == 0 {
+			return date.Weekday() == start.Weekday()
+		}
+		return r.matchesWeekday(date)
+
+	case Monthly:
+		months := (date.Year()-start.Year())*12 + int(date.Month()-start.Month())
+		if months%r.Interval != 0 {
+			return false
+		}
+		if r.IsQuota() {
+			return true
+		}
+		if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+			return date.Day() == start.Day()
+		}
+		// BYDAY is limited by BYMONTHDAY when both are set, e.g. only friday the 13th
+		return (len(r.ByMonthDay) == 0 || r.matchesMonthDay(date)) &&
+			(len(r.ByDay) == 0 || r.matchesMonthWeekday(date))
+	}
+
+	return false
+}
+
+// PeriodStart is the first day of the period (week or month) that `date` belongs to.
+// Quota rules count completions within a period.
+func (r *Rule) PeriodStart(date time.Time) time.Time {
+	date = truncate(date)
+
+	switch r.Freq {
+	case Weekly:
+		return weekStart(date)
+	case Monthly:
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return date
+}
+
+func (r *Rule) matchesWeekday(date time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, d := range r.ByDay {
+		if d.Weekday == date.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMonthDay(date time.Time) bool {
+	last := daysInMonth(date)
+	for _, d := range r.ByMonthDay {
+		if d == date.Day() || (d < 0 && last+d+1 == date.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMonthWeekday(date time.Time) bool {
+	for _, d := range r.ByDay {
+		if d.Weekday != date.Weekday() {
+			continue
+		}
+
+		switch {
+		case d.N == 0:
+			return true
+		case d.N > 0 && (date.Day()-1)/7+1 == d.N:
+			return true
+		case d.N < 0 && (daysInMonth(date)-date.Day())/7+1 == -d.N:
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the rule in its canonical form
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+
+	if len(r.ByDay) > 0 {
+		days := []string{}
+		for _, d := range r.ByDay {
+			day := strings.ToUpper(d.Weekday.String()[:2])
+			if d.N != 0 {
+				day = strconv.Itoa(d.N) + day
+			}
+			days = append(days, day)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+
+	if len(r.ByMonthDay) > 0 {
+		days := []string{}
+		for _, d := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(d))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+
+	if r.Times > 0 {
+		parts = append(parts, "X-TIMES="+strconv.Itoa(r.Times))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+func truncate(d time.Time) time.Time {
+	return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// weekStart is the monday of the week, RFC 5545 weeks start on monday by default
+func weekStart(d time.Time) time.Time {
+	offset := (int(d.Weekday()) + 6) % 7
+	return truncate(d).AddDate(0, 0, -offset)
+}
+
+func daysInMonth(d time.Time) int {
+	return time.Date(d.Year(), d.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
@@ -0,0 +1,96 @@
+package recurrence_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal/recurrence"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+// occurrences lists the dates in [from, to] the rule occurs on
+func occurrences(t *testing.T, rrule, start, from, to string) []string {
+	rule, err := recurrence.Parse(rrule)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+
+	dates := []string{}
+	for d := date(from); !d.After(date(to)); d = d.AddDate(0, 0, 1) {
+		if rule.Occurs(date(start), d) {
+			dates = append(dates, d.Format("2006-01-02"))
+		}
+	}
+	return dates
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, rrule := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=DAILY;X-TIMES=3",
+		"FREQ=WEEKLY;X-TIMES=3;BYDAY=MO",
+		"FREQ=DAILY;COUNT=3",
+	} {
+		_, err := recurrence.Parse(rrule)
+		assert.Error(t, err, rrule)
+	}
+}
+
+func TestCanonicalString(t *testing.T) {
+	rule, err := recurrence.Parse("rrule:freq=monthly;byday=1mo,-1fr;interval=1")
+	assert.NoError(t, err)
+	assert.Equal(t, "FREQ=MONTHLY;BYDAY=1MO,-1FR", rule.String())
+}
+
+func TestEveryNDays(t *testing.T) {
+	dates := occurrences(t, "FREQ=DAILY;INTERVAL=3", "2021-03-01", "2021-02-25", "2021-03-10")
+	assert.Equal(t, []string{"2021-03-01", "2021-03-04", "2021-03-07", "2021-03-10"}, dates)
+}
+
+func TestEveryOtherWeek(t *testing.T) {
+	// 2021-03-03 is a wednesday, weeks start on monday
+	dates := occurrences(t, "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", "2021-03-03", "2021-03-01", "2021-03-21")
+	assert.Equal(t, []string{"2021-03-05", "2021-03-15", "2021-03-19"}, dates)
+}
+
+func TestWeeklyDefaultsToStartWeekday(t *testing.T) {
+	dates := occurrences(t, "FREQ=WEEKLY", "2021-03-03", "2021-03-01", "2021-03-17")
+	assert.Equal(t, []string{"2021-03-03", "2021-03-10", "2021-03-17"}, dates)
+}
+
+func TestFirstMondayOfTheMonth(t *testing.T) {
+	dates := occurrences(t, "FREQ=MONTHLY;BYDAY=1MO", "2021-01-01", "2021-03-01", "2021-05-31")
+	assert.Equal(t, []string{"2021-03-01", "2021-04-05", "2021-05-03"}, dates)
+}
+
+func TestLastDayOfTheMonth(t *testing.T) {
+	dates := occurrences(t, "FREQ=MONTHLY;BYMONTHDAY=-1", "2021-01-01", "2021-02-01", "2021-04-30")
+	assert.Equal(t, []string{"2021-02-28", "2021-03-31", "2021-04-30"}, dates)
+}
+
+func TestFridayThe13th(t *testing.T) {
+	dates := occurrences(t, "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13", "2021-01-01", "2021-01-01", "2021-12-31")
+	assert.Equal(t, []string{"2021-08-13"}, dates)
+}
+
+func TestQuotaOccursEveryDay(t *testing.T) {
+	rule, err := recurrence.Parse("FREQ=WEEKLY;X-TIMES=3")
+	assert.NoError(t, err)
+	assert.True(t, rule.IsQuota())
+
+	dates := occurrences(t, "FREQ=WEEKLY;X-TIMES=3", "2021-03-03", "2021-03-01", "2021-03-07")
+	assert.Equal(t, []string{"2021-03-03", "2021-03-04", "2021-03-05", "2021-03-06", "2021-03-07"}, dates)
+
+	assert.Equal(t, date("2021-03-01"), rule.PeriodStart(date("2021-03-07")))
+}
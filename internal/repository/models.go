@@ -1,37 +1,121 @@
 package repository
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
+// Kinds of habitz
+const (
+	HabitKindCheck    = "check"    // Done or not done
+	HabitKindQuantity = "quantity" // Done when the value reaches the target, e.g. 8 glasses of water
+)
+
+type Habit struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	Name        string     `json:"name" db:"name"`
+	Description string     `json:"description" db:"description"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty" db:"archived_at"`
+	TypeID      *int       `json:"type_id,omitempty" db:"type_id"`
+	Kind        string     `json:"kind" db:"kind"`
+	Target      float64    `json:"target" db:"target"`
+	Unit        string     `json:"unit,omitempty" db:"unit"`
+
+	// Habitz scheduled with a recurrence rule (RRULE) instead of weekday templates
+	Recurrence      string `json:"rrule,omitempty" db:"recurrence"`
+	RecurrenceStart string `json:"rrule_start,omitempty" db:"recurrence_start"`
+}
+
+type HabitType struct {
+	ID        int    `json:"id" db:"id"`
+	UserID    string `json:"user_id" db:"user_id"`
+	Name      string `json:"name" db:"name"`
+	Color     string `json:"color" db:"color"`
+	SortOrder int    `json:"sort_order" db:"sort_order"`
+}
+
 type WeekdayHabitTemplate struct {
 	UserID  string `json:"user_id" db:"user_id"`
 	Weekday string `json:"weekday" db:"weekday"`
+	HabitID int    `json:"habit_id" db:"habit_id"`
 	Habit   string `json:"habit" db:"habit"`
+	TypeID  *int   `json:"type_id,omitempty" db:"type_id"`
 }
 
 type WeekHabitTemplates struct {
 	UserID   string   `json:"user_id" db:"user_id"`
 	Weekdays []string `json:"weekdays" db:"weekdays"`
+	HabitID  int      `json:"habit_id" db:"habit_id"`
 	Habit    string   `json:"habit" db:"habit"`
+	TypeID   *int     `json:"type_id,omitempty" db:"type_id"`
 }
 
 type HabitEntry struct {
 	ID         int        `json:"id" db:"id"`
 	UserID     string     `json:"user_id" db:"user_id"`
 	Weekday    string     `json:"weekday" db:"weekday"`
+	HabitID    int        `json:"habit_id" db:"habit_id"`
 	Habit      string     `json:"habit" db:"habit"`
+	TypeID     *int       `json:"type_id,omitempty" db:"type_id"`
+	Kind       string     `json:"kind" db:"kind"`
+	Value      float64    `json:"value" db:"value"`
+	Target     float64    `json:"target" db:"target"`
+	Unit       string     `json:"unit,omitempty" db:"unit"`
 	Complete   bool       `json:"complete" db:"complete"`
 	Date       string     `json:"date,omitempty" db:"date"`
 	CompleteAt *time.Time `json:"complete_at,omitempty" db:"complete_at"`
 }
 
 type User struct {
-	ID              string `json:"id" db:"id"`
-	Email           string `json:"email" db:"email"`
-	Firstname       string `json:"name" db:"firstname"`
-	Lastname        string `json:"lastname" db:"lastname"`
-	ProfileImageURL string `json:"profile_image" db:"profile_image"`
+	ID              string      `json:"id" db:"id"`
+	Email           string      `json:"email" db:"email"`
+	Firstname       string      `json:"name" db:"firstname"`
+	Lastname        string      `json:"lastname" db:"lastname"`
+	ProfileImageURL string      `json:"profile_image" db:"profile_image"`
+	Timezone        string      `json:"timezone" db:"timezone"`
+	Preferences     Preferences `json:"preferences" db:"preferences"`
+	Admin           bool        `json:"admin,omitempty" db:"is_admin"`
+}
+
+// Preferences is a JSON object owned by the clients, e.g. theme or first day of week
+type Preferences json.RawMessage
+
+func (p Preferences) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("{}"), nil
+	}
+	return p, nil
+}
+
+func (p *Preferences) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[0:0], data...)
+	return nil
+}
+
+// Scan reads the preferences from a TEXT column
+func (p *Preferences) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*p = Preferences(v)
+	case []byte:
+		*p = append(Preferences(nil), v...)
+	case nil:
+		*p = nil
+	default:
+		return fmt.Errorf("can't scan %T into preferences", src)
+	}
+	return nil
+}
+
+func (p Preferences) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return "{}", nil
+	}
+	return string(p), nil
 }
 
 type ExternalUser struct {
@@ -39,3 +123,96 @@ type ExternalUser struct {
 	Provider   string `json:"auth_provider" db:"auth_provider"`
 	ExternalID string `json:"external_id" db:"external_id"`
 }
+
+// ProviderLocal identifies email and password logins among the identities
+const ProviderLocal = "local"
+
+// Identity is a way to log in to an account, an external provider or email and password.
+// For local credentials the external ID is the email.
+type Identity struct {
+	Provider   string     `json:"provider" db:"provider"`
+	ExternalID string     `json:"external_id" db:"external_id"`
+	UserID     string     `json:"-" db:"user_id"`
+	Email      string     `json:"email" db:"email"`
+	LinkedAt   *time.Time `json:"linked_at,omitempty" db:"linked_at"`
+}
+
+// LocalCredentials is an email and password login, without an external provider
+type LocalCredentials struct {
+	Email        string    `json:"email" db:"email"`
+	UserID       string    `json:"user_id" db:"user_id"`
+	PasswordHash string    `json:"-" db:"password_hash"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// Session is a login on one device, access tokens are issued for a session until it's revoked
+type Session struct {
+	ID               string     `json:"id" db:"id"`
+	UserID           string     `json:"user_id" db:"user_id"`
+	RefreshTokenHash string     `json:"-" db:"refresh_token_hash"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt        time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+type HabitStreak struct {
+	HabitID int    `json:"habit_id"`
+	Habit   string `json:"habit"`
+	Current int    `json:"current"`
+	Longest int    `json:"longest"`
+}
+
+type HabitStats struct {
+	HabitID             int     `json:"habit_id"`
+	Habit               string  `json:"habit"`
+	ScheduledDays       int     `json:"scheduled_days"`
+	CompletedDays       int     `json:"completed_days"`
+	CompletionRate      float64 `json:"completion_rate"`
+	AverageCompleteTime string  `json:"average_complete_time,omitempty"` // HH:MM in the users timezone
+
+	// Habitz due a number of times per period, their completion rate is per period
+	Quota *QuotaStats `json:"quota,omitempty"`
+}
+
+// QuotaStats counts the periods of a quota habit, e.g. weeks of a 3 times a week habit
+type QuotaStats struct {
+	Periods          int `json:"periods"`
+	CompletedPeriods int `json:"completed_periods"`
+}
+
+// HabitImport is data from a Habitz export or another tracker, added to an account in one go
+type HabitImport struct {
+	Habits  []*ImportedHabit
+	Entries []*ImportedEntry
+}
+
+// ImportedHabit is matched to an existing habit by name, new habitz are created
+type ImportedHabit struct {
+	Habit
+	Weekdays []string
+}
+
+type ImportedEntry struct {
+	Habit      string // Name of the habit
+	Date       string
+	Value      float64
+	Complete   bool
+	CompleteAt *time.Time
+}
+
+// ImportResult tells what an import created, or would create in a dry run
+type ImportResult struct {
+	DryRun             bool             `json:"dry_run"`
+	HabitsCreated      []string         `json:"habits_created"`
+	HabitsExisting     []string         `json:"habits_existing"`
+	TemplatesCreated   int              `json:"templates_created"`
+	EntriesCreated     int              `json:"entries_created"`
+	EntriesConflicting int              `json:"entries_conflicting"`
+	Conflicts          []ImportConflict `json:"conflicts"` // The first ones, if there are many
+}
+
+type ImportConflict struct {
+	Habit  string `json:"habit"`
+	Date   string `json:"date,omitempty"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal/recurrence"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// Schedule decides on which days a habit is due.
+// Habitz are either scheduled on weekdays with templates, or with a recurrence rule.
+type Schedule struct {
+	weekdays map[string]bool
+	rule     *recurrence.Rule
+	start    time.Time
+}
+
+// WeekdaySchedule schedules a habit on the given lowercase weekdays
+func WeekdaySchedule(weekdays []string) *Schedule {
+	return &Schedule{weekdays: weekdaySet(weekdays)}
+}
+
+// HabitSchedule uses the recurrence rule of the habit, or the weekdays of its templates if it has none
+func HabitSchedule(habit *repository.Habit, weekdays []string) (*Schedule, error) {
+	if habit == nil || habit.Recurrence == "" {
+		return WeekdaySchedule(weekdays), nil
+	}
+
+	rule, err := recurrence.Parse(habit.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := time.Parse(ShortDateFormat, habit.RecurrenceStart)
+	if err != nil {
+		start = habit.CreatedAt
+	}
+
+	return &Schedule{rule: rule, start: start}, nil
+}
+
+// IsQuota is true for habitz due a number of times per period on any days, e.g. 3 times a week
+func (s *Schedule) IsQuota() bool {
+	return s.rule != nil && s.rule.IsQuota()
+}
+
+// QuotaReached is true once a quota habit has been completed enough times in a period
+func (s *Schedule) QuotaReached(completed int) bool {
+	return s.IsQuota() && completed >= s.rule.Times
+}
+
+// Scheduled reports whether the habit is planned on `d`.
+// Quota habitz are never planned on a specific day.
+func (s *Schedule) Scheduled(d time.Time) bool {
+	if s.rule == nil {
+		return s.weekdays[strings.ToLower(d.Weekday().String())]
+	}
+	return !s.rule.IsQuota() && s.rule.Occurs(s.start, d)
+}
+
+// Due reports whether the habit should have an entry on `d`.
+// Quota habitz are due every day of the period until they have been completed `completed` times.
+func (s *Schedule) Due(d time.Time, completed int) bool {
+	if !s.IsQuota() {
+		return s.Scheduled(d)
+	}
+	return s.rule.Occurs(s.start, d) && completed < s.rule.Times
+}
+
+// PeriodStart is the first day quota habitz count completions from, for a habit due on `d`
+func (s *Schedule) PeriodStart(d time.Time) time.Time {
+	if s.rule == nil {
+		return d
+	}
+	return s.rule.PeriodStart(d)
+}
+
+// DueHabits returns the IDs of the habitz due on `date`, in the order of the schedule.
+// Weekday templates are used for habitz without a recurrence rule.
+func DueHabits(ctx context.Context, hs HabitzServicer, userID, date string) ([]int, error) {
+	d, err := time.Parse(ShortDateFormat, date)
+	if err != nil {
+		return nil, err
+	}
+
+	weekday, err := WeekdayOf(date)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := hs.WeekdayTemplates(ctx, userID, weekday)
+	if err != nil {
+		return nil, err
+	}
+
+	due := []int{}
+	for _, t := range templates {
+		due = append(due, t.HabitID)
+	}
+
+	habits, err := hs.Habits(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, habit := range habits {
+		if habit.Recurrence == "" {
+			continue
+		}
+
+		schedule, err := HabitSchedule(habit, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		completed := 0
+		if schedule.IsQuota() {
+			from := ShortDate(schedule.PeriodStart(d))
+			if completed, err = completedBetween(ctx, hs, userID, habit.ID, from, date); err != nil {
+				return nil, err
+			}
+		}
+
+		if schedule.Due(d, completed) {
+			due = append(due, habit.ID)
+		}
+	}
+
+	return due, nil
+}
+
+// completedBetween counts the completed entries of a habit from `from` up to, but not including, `to`
+func completedBetween(ctx context.Context, hs HabitzServicer, userID string, habitID int, from, to string) (int, error) {
+	const pageSize = 1000
+
+	completed := 0
+	for offset := 0; ; offset += pageSize {
+		entries, err := hs.HabitEntriesBetween(ctx, userID, from, to, pageSize, offset)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, e := range entries {
+			if e.HabitID == habitID && e.Complete && e.Date < to {
+				completed++
+			}
+		}
+
+		if len(entries) < pageSize {
+			return completed, nil
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package internal_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/mock"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDueHabits(t *testing.T) {
+	ctx := context.Background()
+	hs := mock.NewHabitzService()
+
+	user, err := hs.CreateLocalUser(ctx, &repository.User{Email: "due@example.com", Timezone: "UTC"}, "")
+	assert.Nil(t, err)
+
+	// 2021-03-01 is a monday
+	created := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	_, err = hs.Import(ctx, user.ID, &repository.HabitImport{
+		Habits: []*repository.ImportedHabit{
+			{Habit: repository.Habit{Name: "Run", Kind: repository.HabitKindCheck, Target: 1, CreatedAt: created}, Weekdays: []string{"monday"}},
+			{Habit: repository.Habit{Name: "Read", Kind: repository.HabitKindCheck, Target: 1, CreatedAt: created, Recurrence: "FREQ=DAILY;INTERVAL=2", RecurrenceStart: "2021-03-01"}},
+			{Habit: repository.Habit{Name: "Swim", Kind: repository.HabitKindCheck, Target: 1, CreatedAt: created, Recurrence: "FREQ=WEEKLY;X-TIMES=2"}},
+		},
+		Entries: []*repository.ImportedEntry{
+			{Habit: "Swim", Date: "2021-03-01", Complete: true},
+			{Habit: "Swim", Date: "2021-03-02", Complete: true},
+		},
+	}, false)
+	assert.Nil(t, err)
+
+	habits, err := hs.Habits(ctx, user.ID, false)
+	assert.Nil(t, err)
+	ids := map[string]int{}
+	for _, h := range habits {
+		ids[h.Name] = h.ID
+	}
+	run, read, swim := ids["Run"], ids["Read"], ids["Swim"]
+
+	for date, want := range map[string][]int{
+		"2021-03-01": {run, read, swim},
+		"2021-03-02": {swim},       // Swim is completed once before today
+		"2021-03-03": {read},       // Swim is completed twice this week
+		"2021-03-04": {},           // Nothing planned
+		"2021-03-08": {run, swim},  // A new week
+		"2021-03-09": {read, swim}, // Every other day continues over the week
+	} {
+		due, err := internal.DueHabits(ctx, hs, user.ID, date)
+		assert.Nil(t, err, date)
+		assert.Equal(t, want, due, date)
+	}
+
+	_, err = internal.DueHabits(ctx, hs, user.ID, "tomorrow")
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"time"
 
 	"github.com/jfernstad/habitz/web/internal/repository"
@@ -11,18 +12,73 @@ type Services struct {
 }
 
 type HabitzServicer interface {
-	Users() ([]string, error) // Obsolete?
-	UserWithExternalID(externalID string, provider string) (*repository.User, error)
+	Users(ctx context.Context) ([]string, error) // Obsolete?
+	AllUsers(ctx context.Context) ([]*repository.User, error)
+	User(ctx context.Context, userID string) (*repository.User, error)
+	UserWithExternalID(ctx context.Context, externalID string, provider string) (*repository.User, error)
+	SetUserTimezone(ctx context.Context, userID, timezone string) error
+	UpdateUser(ctx context.Context, user *repository.User) (*repository.User, error)
+	SetUserAdmin(ctx context.Context, userID string, admin bool) error
+	RemoveUser(ctx context.Context, userID string) error
 
-	CreateExternalUser(external *repository.ExternalUser) (*repository.User, error)
+	CreateExternalUser(ctx context.Context, external *repository.ExternalUser) (*repository.User, error)
 
-	Templates(user string) ([]*repository.WeekHabitTemplates, error)
-	WeekdayTemplates(user, weekday string) ([]*repository.WeekdayHabitTemplate, error)
-	CreateTemplate(user, weekday, habit string) error
-	RemoveTemplate(user, weekday, habit string) error
-	RemoveEntry(user, habit string, date time.Time) error
+	// A user can log in with several identities, the last one can't be removed
+	Identities(ctx context.Context, user string) ([]*repository.Identity, error)
+	LinkIdentity(ctx context.Context, user string, external *repository.ExternalUser) error
+	UnlinkIdentity(ctx context.Context, user, provider, externalID string) error
 
-	HabitEntries(user string, date string) ([]*repository.HabitEntry, error)
-	CreateHabitEntry(user, weekday, habit string) (*repository.HabitEntry, error)
-	UpdateHabitEntry(id int, complete bool) (*repository.HabitEntry, error)
+	Habits(ctx context.Context, user string, includeArchived bool) ([]*repository.Habit, error)
+	Habit(ctx context.Context, user string, id int) (*repository.Habit, error)
+	HabitWithName(ctx context.Context, user, name string) (*repository.Habit, error)
+	CreateHabit(ctx context.Context, user string, habit *repository.Habit) (*repository.Habit, error)
+	UpdateHabit(ctx context.Context, user string, habit *repository.Habit) (*repository.Habit, error)
+	ArchiveHabit(ctx context.Context, user string, id int, archived bool) (*repository.Habit, error)
+	RemoveHabit(ctx context.Context, user string, id int) error
+	SetHabitType(ctx context.Context, user string, habitID int, typeID *int) error
+	SetHabitRecurrence(ctx context.Context, user string, habitID int, rrule, start string) error
+
+	HabitTypes(ctx context.Context, user string) ([]*repository.HabitType, error)
+	HabitType(ctx context.Context, user string, id int) (*repository.HabitType, error)
+	CreateHabitType(ctx context.Context, user string, habitType *repository.HabitType) (*repository.HabitType, error)
+	UpdateHabitType(ctx context.Context, user string, habitType *repository.HabitType) (*repository.HabitType, error)
+	RemoveHabitType(ctx context.Context, user string, id int) error
+
+	Templates(ctx context.Context, user string) ([]*repository.WeekHabitTemplates, error)
+	WeekdayTemplates(ctx context.Context, user, weekday string) ([]*repository.WeekdayHabitTemplate, error)
+	CreateTemplate(ctx context.Context, user, weekday string, habitID int) error
+	RemoveTemplate(ctx context.Context, user, weekday string, habitID int) error
+	RemoveEntry(ctx context.Context, user string, habitID int, date string) error
+
+	HabitEntries(ctx context.Context, user string, date string) ([]*repository.HabitEntry, error)
+	// The entry with the ID, nil unless it belongs to the user
+	HabitEntry(ctx context.Context, user string, id int) (*repository.HabitEntry, error)
+	HabitEntriesBetween(ctx context.Context, user string, from, to string, limit, offset int) ([]*repository.HabitEntry, error)
+	EachHabitEntry(ctx context.Context, user string, fn func(*repository.HabitEntry) error) error
+	CreateHabitEntry(ctx context.Context, user, date, weekday string, habitID int) (*repository.HabitEntry, error)
+	// Creates the missing entries of the habitz on a date and returns all entries of that date
+	EnsureHabitEntries(ctx context.Context, user, date string, habitIDs []int) ([]*repository.HabitEntry, error)
+	UpdateHabitEntry(ctx context.Context, user string, id int, complete bool) (*repository.HabitEntry, error)
+	SetHabitEntryValue(ctx context.Context, user string, id int, value float64) (*repository.HabitEntry, error)
+	IncrementHabitEntry(ctx context.Context, user string, id int, delta float64) (*repository.HabitEntry, error)
+
+	// Imports are all or nothing, a dry run reports what would be created
+	Import(ctx context.Context, user string, data *repository.HabitImport, dryRun bool) (*repository.ImportResult, error)
+
+	// The scheduler keeps track of the last date it created entries for
+	MaterializedThrough(ctx context.Context, user string) (string, error)
+	SetMaterializedThrough(ctx context.Context, user, date string) error
+
+	// Email and password accounts
+	CreateLocalUser(ctx context.Context, user *repository.User, passwordHash string) (*repository.User, error)
+	LocalCredentials(ctx context.Context, email string) (*repository.LocalCredentials, error)
+	CreateLocalCredentials(ctx context.Context, user, email, passwordHash string) error
+
+	// Login sessions, refresh tokens are only stored hashed
+	CreateSession(ctx context.Context, session *repository.Session) error
+	Session(ctx context.Context, id string) (*repository.Session, error)
+	SessionWithRefreshToken(ctx context.Context, hash string) (*repository.Session, error)
+	RotateSession(ctx context.Context, id, oldHash, newHash string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, user, id string) error
+	RevokeAllSessions(ctx context.Context, user string) error
 }
@@ -0,0 +1,793 @@
+// Package servicetest is a conformance test suite for implementations of internal.HabitzServicer.
+// Every database backend runs it, so they behave the same towards the endpoints.
+package servicetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewService creates a service with an empty database, close releases the database
+type NewService func(t *testing.T) (hs internal.HabitzServicer, close func())
+
+// The tests call services without a deadline
+var ctx = context.Background()
+
+// Run runs every conformance test against services created by newService
+func Run(t *testing.T, newService NewService) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, hs internal.HabitzServicer)
+	}{
+		{"LocalUsers", testLocalUsers},
+		{"UpdateUser", testUpdateUser},
+		{"RemoveUser", testRemoveUser},
+		{"LinkedIdentities", testLinkedIdentities},
+		{"Habits", testHabits},
+		{"HabitTypes", testHabitTypes},
+		{"Templates", testTemplates},
+		{"Entries", testEntries},
+		{"EntryValues", testEntryValues},
+		{"EachHabitEntry", testEachHabitEntry},
+		{"EnsureHabitEntries", testEnsureHabitEntries},
+		{"OtherUsersEntriesAreNotFound", testOtherUsersEntriesAreNotFound},
+		{"OtherUsersHabitsAreNotFound", testOtherUsersHabitsAreNotFound},
+		{"OtherUsersTypesAreNotFound", testOtherUsersTypesAreNotFound},
+		{"Sessions", testSessions},
+		{"MaterializedThrough", testMaterializedThrough},
+		{"Import", testImport},
+	}
+
+	for _, tt := range tests {
+		test := tt.test
+		t.Run(tt.name, func(t *testing.T) {
+			hs, close := newService(t)
+			defer close()
+			test(t, hs)
+		})
+	}
+}
+
+// newUser creates a user, the databases may require users to exist before their habitz
+func newUser(t *testing.T, hs internal.HabitzServicer, name string) string {
+	user, err := hs.CreateLocalUser(ctx, &repository.User{Email: name + "@example.com", Firstname: name}, "hash")
+	if err != nil {
+		t.Fatal("create user: ", err)
+	}
+	return user.ID
+}
+
+// scheduledHabit sets up a habit scheduled on mondays with an entry on 2021-03-01
+func scheduledHabit(t *testing.T, hs internal.HabitzServicer, userID string) (*repository.Habit, *repository.HabitEntry) {
+	habit, err := hs.CreateHabit(ctx, userID, &repository.Habit{Name: "Run", Kind: repository.HabitKindCheck, Target: 1})
+	if err != nil {
+		t.Fatal("create habit: ", err)
+	}
+	assert.Nil(t, hs.CreateTemplate(ctx, userID, "monday", habit.ID))
+
+	entry, err := hs.CreateHabitEntry(ctx, userID, "2021-03-01", "monday", habit.ID)
+	if err != nil {
+		t.Fatal("create entry: ", err)
+	}
+	return habit, entry
+}
+
+func testLocalUsers(t *testing.T, hs internal.HabitzServicer) {
+	user, err := hs.CreateLocalUser(ctx, &repository.User{Email: "Alice@example.com", Firstname: "Alice"}, "hash")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, user.ID)
+
+	// Emails are unique, ignoring case
+	_, err = hs.CreateLocalUser(ctx, &repository.User{Email: "alice@EXAMPLE.com"}, "other")
+	assert.Equal(t, internal.ErrAlreadyExists, err)
+
+	creds, err := hs.LocalCredentials(ctx, "alice@example.com")
+	assert.Nil(t, err)
+	assert.Equal(t, user.ID, creds.UserID)
+	assert.Equal(t, "hash", creds.PasswordHash)
+
+	stored, err := hs.User(ctx, user.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Alice", stored.Firstname)
+
+	creds, err = hs.LocalCredentials(ctx, "bob@example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, creds)
+
+	stored, err = hs.User(ctx, "nobody")
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+
+	users, err := hs.AllUsers(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, users, 1)
+}
+
+func testUpdateUser(t *testing.T, hs internal.HabitzServicer) {
+	userID := newUser(t, hs, "alice")
+
+	stored, err := hs.User(ctx, userID)
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(stored.Preferences))
+	assert.False(t, stored.Admin)
+
+	stored.Lastname = "Liddell"
+	stored.Preferences = repository.Preferences(`{"theme":"dark"}`)
+	updated, err := hs.UpdateUser(ctx, stored)
+	assert.Nil(t, err)
+	assert.Equal(t, "Liddell", updated.Lastname)
+	assert.JSONEq(t, `{"theme":"dark"}`, string(updated.Preferences))
+
+	assert.Nil(t, hs.SetUserTimezone(ctx, userID, "Europe/Stockholm"))
+	assert.Nil(t, hs.SetUserAdmin(ctx, userID, true))
+	stored, err = hs.User(ctx, userID)
+	assert.Nil(t, err)
+	assert.True(t, stored.Admin)
+	assert.Equal(t, "Europe/Stockholm", stored.Timezone)
+
+	_, err = hs.UpdateUser(ctx, &repository.User{ID: "nobody"})
+	assert.Equal(t, internal.ErrNotFound, err)
+	assert.Equal(t, internal.ErrNotFound, hs.SetUserAdmin(ctx, "nobody", true))
+}
+
+func testRemoveUser(t *testing.T, hs internal.HabitzServicer) {
+	user, err := hs.CreateExternalUser(ctx, &repository.ExternalUser{Provider: "google", ExternalID: "1234"})
+	assert.Nil(t, err)
+	assert.Nil(t, hs.CreateLocalCredentials(ctx, user.ID, "bob@example.com", "hash"))
+
+	habitType, err := hs.CreateHabitType(ctx, user.ID, &repository.HabitType{Name: "Health"})
+	assert.Nil(t, err)
+	habit, _ := scheduledHabit(t, hs, user.ID)
+	assert.Nil(t, hs.SetHabitType(ctx, user.ID, habit.ID, &habitType.ID))
+	assert.Nil(t, hs.SetMaterializedThrough(ctx, user.ID, "2021-03-01"))
+	assert.Nil(t, hs.CreateSession(ctx, &repository.Session{
+		ID:               "phone",
+		UserID:           user.ID,
+		RefreshTokenHash: "hash",
+		CreatedAt:        time.Now(),
+		ExpiresAt:        time.Now().Add(time.Hour),
+	}))
+
+	// Someone else's habitz stay
+	aliceID := newUser(t, hs, "alice")
+	_, aliceEntry := scheduledHabit(t, hs, aliceID)
+
+	assert.Nil(t, hs.RemoveUser(ctx, user.ID))
+	assert.Equal(t, internal.ErrNotFound, hs.RemoveUser(ctx, user.ID))
+
+	stored, err := hs.User(ctx, user.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+
+	stored, err = hs.UserWithExternalID(ctx, "1234", "google")
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+
+	creds, err := hs.LocalCredentials(ctx, "bob@example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, creds)
+
+	habits, err := hs.Habits(ctx, user.ID, true)
+	assert.Nil(t, err)
+	assert.Empty(t, habits)
+
+	entries, err := hs.HabitEntries(ctx, aliceID, aliceEntry.Date)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func testLinkedIdentities(t *testing.T, hs internal.HabitzServicer) {
+	google := &repository.ExternalUser{
+		User:       repository.User{Email: "alice@gmail.com"},
+		Provider:   "google",
+		ExternalID: "1234",
+	}
+	user, err := hs.CreateExternalUser(ctx, google)
+	assert.Nil(t, err)
+	bobID := newUser(t, hs, "bob")
+
+	// The same subject at another provider is another identity
+	keycloak := &repository.ExternalUser{Provider: "keycloak", ExternalID: "1234"}
+	assert.Nil(t, hs.LinkIdentity(ctx, user.ID, keycloak))
+	assert.Equal(t, internal.ErrAlreadyExists, hs.LinkIdentity(ctx, bobID, keycloak))
+
+	linked, err := hs.UserWithExternalID(ctx, "1234", "keycloak")
+	assert.Nil(t, err)
+	assert.Equal(t, user.ID, linked.ID)
+
+	assert.Nil(t, hs.CreateLocalCredentials(ctx, user.ID, "alice@example.com", "hash"))
+	assert.Equal(t, internal.ErrAlreadyExists, hs.CreateLocalCredentials(ctx, user.ID, "other@example.com", "hash"))
+
+	identities, err := hs.Identities(ctx, user.ID)
+	assert.Nil(t, err)
+	assert.Len(t, identities, 3)
+	assert.Equal(t, repository.ProviderLocal, identities[2].Provider)
+	assert.Equal(t, "alice@example.com", identities[2].ExternalID)
+
+	assert.Equal(t, internal.ErrNotFound, hs.UnlinkIdentity(ctx, bobID, "google", "1234"))
+	assert.Nil(t, hs.UnlinkIdentity(ctx, user.ID, "google", "1234"))
+	assert.Nil(t, hs.UnlinkIdentity(ctx, user.ID, repository.ProviderLocal, "alice@example.com"))
+
+	// The last identity stays
+	assert.Equal(t, internal.ErrLastIdentity, hs.UnlinkIdentity(ctx, user.ID, "keycloak", "1234"))
+
+	identities, err = hs.Identities(ctx, user.ID)
+	assert.Nil(t, err)
+	assert.Len(t, identities, 1)
+	assert.Equal(t, "keycloak", identities[0].Provider)
+
+	// Of two concurrent unlinks only one goes through, the user keeps an identity
+	assert.Nil(t, hs.CreateLocalCredentials(ctx, user.ID, "alice@example.com", "hash"))
+	errs := make(chan error, 2)
+	go func() { errs <- hs.UnlinkIdentity(ctx, user.ID, "keycloak", "1234") }()
+	go func() { errs <- hs.UnlinkIdentity(ctx, user.ID, repository.ProviderLocal, "alice@example.com") }()
+	results := []error{<-errs, <-errs}
+	assert.Contains(t, results, nil)
+	assert.Contains(t, results, internal.ErrLastIdentity)
+
+	identities, err = hs.Identities(ctx, user.ID)
+	assert.Nil(t, err)
+	assert.Len(t, identities, 1)
+}
+
+func testHabits(t *testing.T, hs internal.HabitzServicer) {
+	userID := newUser(t, hs, "alice")
+
+	habit, err := hs.CreateHabit(ctx, userID, &repository.Habit{Name: "Run", Description: "5k", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "Run", habit.Name)
+	assert.Equal(t, "5k", habit.Description)
+	assert.False(t, habit.CreatedAt.IsZero())
+
+	// Names are unique, ignoring case
+	_, err = hs.CreateHabit(ctx, userID, &repository.Habit{Name: "run", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Equal(t, internal.ErrAlreadyExists, err)
+
+	found, err := hs.HabitWithName(ctx, userID, "RUN")
+	assert.Nil(t, err)
+	assert.Equal(t, habit.ID, found.ID)
+
+	water, err := hs.CreateHabit(ctx, userID, &repository.Habit{Name: "Water", Kind: repository.HabitKindQuantity, Target: 8, Unit: "glasses"})
+	assert.Nil(t, err)
+
+	_, err = hs.UpdateHabit(ctx, userID, &repository.Habit{ID: water.ID, Name: "RUN", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Equal(t, internal.ErrAlreadyExists, err)
+
+	water.Target = 10
+	updated, err := hs.UpdateHabit(ctx, userID, water)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, updated.Target)
+	assert.Equal(t, "glasses", updated.Unit)
+
+	archived, err := hs.ArchiveHabit(ctx, userID, habit.ID, true)
+	assert.Nil(t, err)
+	assert.NotNil(t, archived.ArchivedAt)
+
+	habits, err := hs.Habits(ctx, userID, false)
+	assert.Nil(t, err)
+	assert.Len(t, habits, 1)
+
+	habits, err = hs.Habits(ctx, userID, true)
+	assert.Nil(t, err)
+	assert.Len(t, habits, 2)
+
+	restored, err := hs.ArchiveHabit(ctx, userID, habit.ID, false)
+	assert.Nil(t, err)
+	assert.Nil(t, restored.ArchivedAt)
+
+	// A recurrence rule replaces the weekday templates
+	assert.Nil(t, hs.CreateTemplate(ctx, userID, "monday", habit.ID))
+	assert.Nil(t, hs.SetHabitRecurrence(ctx, userID, habit.ID, "FREQ=DAILY;INTERVAL=2", "2021-03-01"))
+	found, err = hs.Habit(ctx, userID, habit.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "FREQ=DAILY;INTERVAL=2", found.Recurrence)
+	assert.Equal(t, "2021-03-01", found.RecurrenceStart)
+
+	templates, err := hs.Templates(ctx, userID)
+	assert.Nil(t, err)
+	assert.Empty(t, templates)
+
+	_, err = hs.CreateHabitEntry(ctx, userID, "2021-03-01", "monday", habit.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, hs.RemoveHabit(ctx, userID, habit.ID))
+	assert.Equal(t, internal.ErrNotFound, hs.RemoveHabit(ctx, userID, habit.ID))
+
+	found, err = hs.Habit(ctx, userID, habit.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	entries, err := hs.HabitEntries(ctx, userID, "2021-03-01")
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
+
+func testHabitTypes(t *testing.T, hs internal.HabitzServicer) {
+	userID := newUser(t, hs, "alice")
+
+	work, err := hs.CreateHabitType(ctx, userID, &repository.HabitType{Name: "Work", Color: "#0000ff", SortOrder: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, "#0000ff", work.Color)
+
+	health, err := hs.CreateHabitType(ctx, userID, &repository.HabitType{Name: "health"})
+	assert.Nil(t, err)
+	_, err = hs.CreateHabitType(ctx, userID, &repository.HabitType{Name: "Fitness"})
+	assert.Nil(t, err)
+
+	_, err = hs.CreateHabitType(ctx, userID, &repository.HabitType{Name: "WORK"})
+	assert.Equal(t, internal.ErrAlreadyExists, err)
+
+	// Ordered by sort order, then by name ignoring case
+	types, err := hs.HabitTypes(ctx, userID)
+	assert.Nil(t, err)
+	names := []string{}
+	for _, habitType := range types {
+		names = append(names, habitType.Name)
+	}
+	assert.Equal(t, []string{"Fitness", "health", "Work"}, names)
+
+	health.Name = "Health"
+	health.SortOrder = 2
+	updated, err := hs.UpdateHabitType(ctx, userID, health)
+	assert.Nil(t, err)
+	assert.Equal(t, "Health", updated.Name)
+	assert.Equal(t, 2, updated.SortOrder)
+
+	health.Name = "work"
+	_, err = hs.UpdateHabitType(ctx, userID, health)
+	assert.Equal(t, internal.ErrAlreadyExists, err)
+
+	habit, err := hs.CreateHabit(ctx, userID, &repository.Habit{Name: "Run", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+	assert.Nil(t, hs.SetHabitType(ctx, userID, habit.ID, &work.ID))
+
+	found, err := hs.Habit(ctx, userID, habit.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, work.ID, *found.TypeID)
+
+	// The habit stays without a type
+	assert.Nil(t, hs.RemoveHabitType(ctx, userID, work.ID))
+	assert.Equal(t, internal.ErrNotFound, hs.RemoveHabitType(ctx, userID, work.ID))
+
+	found, err = hs.Habit(ctx, userID, habit.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, found.TypeID)
+}
+
+func testTemplates(t *testing.T, hs internal.HabitzServicer) {
+	userID := newUser(t, hs, "alice")
+
+	run, err := hs.CreateHabit(ctx, userID, &repository.Habit{Name: "Run", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+	read, err := hs.CreateHabit(ctx, userID, &repository.Habit{Name: "Read", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+
+	assert.Nil(t, hs.CreateTemplate(ctx, userID, "monday", run.ID))
+	assert.Nil(t, hs.CreateTemplate(ctx, userID, "friday", run.ID))
+	assert.Nil(t, hs.CreateTemplate(ctx, userID, "monday", read.ID))
+
+	templates, err := hs.Templates(ctx, userID)
+	assert.Nil(t, err)
+	assert.Len(t, templates, 2)
+	assert.Equal(t, run.ID, templates[0].HabitID)
+	assert.Equal(t, "Run", templates[0].Habit)
+	assert.ElementsMatch(t, []string{"monday", "friday"}, templates[0].Weekdays)
+	assert.Equal(t, []string{"monday"}, templates[1].Weekdays)
+
+	monday, err := hs.WeekdayTemplates(ctx, userID, "monday")
+	assert.Nil(t, err)
+	assert.Len(t, monday, 2)
+
+	// Archived habitz aren't scheduled
+	_, err = hs.ArchiveHabit(ctx, userID, read.ID, true)
+	assert.Nil(t, err)
+	monday, err = hs.WeekdayTemplates(ctx, userID, "monday")
+	assert.Nil(t, err)
+	assert.Len(t, monday, 1)
+
+	assert.Nil(t, hs.RemoveTemplate(ctx, userID, "friday", run.ID))
+	assert.Equal(t, internal.ErrNotFound, hs.RemoveTemplate(ctx, userID, "friday", run.ID))
+
+	friday, err := hs.WeekdayTemplates(ctx, userID, "friday")
+	assert.Nil(t, err)
+	assert.Empty(t, friday)
+}
+
+func testEntries(t *testing.T, hs internal.HabitzServicer) {
+	userID := newUser(t, hs, "alice")
+	habit, entry := scheduledHabit(t, hs, userID)
+
+	assert.Equal(t, "Run", entry.Habit)
+	assert.Equal(t, habit.ID, entry.HabitID)
+	assert.Equal(t, "2021-03-01", entry.Date)
+	assert.Equal(t, "monday", entry.Weekday)
+	assert.False(t, entry.Complete)
+	assert.Nil(t, entry.CompleteAt)
+
+	found, err := hs.HabitEntry(ctx, userID, entry.ID)
+	assert.Nil(t, err)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, entry.ID, found.ID)
+		assert.Equal(t, "Run", found.Habit)
+		assert.Equal(t, repository.HabitKindCheck, found.Kind)
+	}
+
+	found, err = hs.HabitEntry(ctx, userID, entry.ID+100)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	updated, err := hs.UpdateHabitEntry(ctx, userID, entry.ID, true)
+	assert.Nil(t, err)
+	assert.True(t, updated.Complete)
+	assert.NotNil(t, updated.CompleteAt)
+
+	updated, err = hs.UpdateHabitEntry(ctx, userID, entry.ID, false)
+	assert.Nil(t, err)
+	assert.False(t, updated.Complete)
+
+	for _, date := range []string{"2021-03-15", "2021-03-08"} {
+		_, err := hs.CreateHabitEntry(ctx, userID, date, "monday", habit.ID)
+		assert.Nil(t, err)
+	}
+
+	entries, err := hs.HabitEntriesBetween(ctx, userID, "2021-03-01", "2021-03-10", 10, 0)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+
+	entries, err = hs.HabitEntriesBetween(ctx, userID, "2021-03-01", "2021-03-31", 1, 1)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "2021-03-08", entries[0].Date)
+
+	assert.Nil(t, hs.RemoveEntry(ctx, userID, habit.ID, "2021-03-08"))
+	entries, err = hs.HabitEntries(ctx, userID, "2021-03-08")
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
+
+func testEnsureHabitEntries(t *testing.T, hs internal.HabitzServicer) {
+	userID := newUser(t, hs, "alice")
+	otherID := newUser(t, hs, "bob")
+	run, entry := scheduledHabit(t, hs, userID)
+	other, _ := scheduledHabit(t, hs, otherID)
+
+	read, err := hs.CreateHabit(ctx, userID, &repository.Habit{Name: "Read", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+
+	// Only one entry per habit and day
+	_, err = hs.CreateHabitEntry(ctx, userID, "2021-03-01", "monday", run.ID)
+	assert.Equal(t, internal.ErrAlreadyExists, err)
+
+	_, err = hs.UpdateHabitEntry(ctx, userID, entry.ID, true)
+	assert.Nil(t, err)
+
+	// Existing entries are left as they are, other users habitz are ignored
+	for i := 0; i < 2; i++ {
+		entries, err := hs.EnsureHabitEntries(ctx, userID, "2021-03-01", []int{run.ID, read.ID, other.ID})
+		assert.Nil(t, err)
+		if assert.Len(t, entries, 2) {
+			assert.Equal(t, entry.ID, entries[0].ID)
+			assert.True(t, entries[0].Complete)
+			assert.Equal(t, read.ID, entries[1].HabitID)
+			assert.Equal(t, "monday", entries[1].Weekday)
+		}
+	}
+
+	entries, err := hs.HabitEntries(ctx, otherID, "2021-03-01")
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+
+	entries, err = hs.EnsureHabitEntries(ctx, userID, "2021-03-02", nil)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+
+	// Concurrent requests for the same day create each entry once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := hs.EnsureHabitEntries(ctx, userID, "2021-03-08", []int{run.ID, read.ID})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	entries, err = hs.HabitEntries(ctx, userID, "2021-03-08")
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+
+	// The created entry is returned, not the latest entry of any user
+	created, err := hs.CreateHabitEntry(ctx, userID, "2021-03-15", "monday", run.ID)
+	assert.Nil(t, err)
+	_, err = hs.CreateHabitEntry(ctx, otherID, "2021-03-15", "monday", other.ID)
+	assert.Nil(t, err)
+	latest, err := hs.CreateHabitEntry(ctx, userID, "2021-03-22", "monday", run.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, userID, latest.UserID)
+	assert.Equal(t, "2021-03-22", latest.Date)
+	assert.NotEqual(t, created.ID, latest.ID)
+}
+
+func testEntryValues(t *testing.T, hs internal.HabitzServicer) {
+	userID := newUser(t, hs, "alice")
+
+	habit, err := hs.CreateHabit(ctx, userID, &repository.Habit{Name: "Water", Kind: repository.HabitKindQuantity, Target: 8, Unit: "glasses"})
+	assert.Nil(t, err)
+	entry, err := hs.CreateHabitEntry(ctx, userID, "2021-03-01", "monday", habit.ID)
+	assert.Nil(t, err)
+
+	entry, err = hs.IncrementHabitEntry(ctx, userID, entry.ID, 5.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5.5, entry.Value)
+	assert.Equal(t, 8.0, entry.Target)
+	assert.Equal(t, "glasses", entry.Unit)
+	assert.False(t, entry.Complete)
+
+	// Complete once the target is reached
+	entry, err = hs.IncrementHabitEntry(ctx, userID, entry.ID, 2.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 8.0, entry.Value)
+	assert.True(t, entry.Complete)
+	assert.NotNil(t, entry.CompleteAt)
+
+	// Values don't go below zero
+	entry, err = hs.SetHabitEntryValue(ctx, userID, entry.ID, -2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, entry.Value)
+	assert.False(t, entry.Complete)
+
+	entry, err = hs.SetHabitEntryValue(ctx, userID, entry.ID, 9)
+	assert.Nil(t, err)
+	assert.True(t, entry.Complete)
+}
+
+func testEachHabitEntry(t *testing.T, hs internal.HabitzServicer) {
+	aliceID := newUser(t, hs, "alice")
+	bobID := newUser(t, hs, "bob")
+
+	habit, _ := scheduledHabit(t, hs, aliceID)
+	for _, date := range []string{"2021-03-15", "2021-03-08"} {
+		_, err := hs.CreateHabitEntry(ctx, aliceID, date, "monday", habit.ID)
+		assert.Nil(t, err)
+	}
+
+	dates := []string{}
+	err := hs.EachHabitEntry(ctx, aliceID, func(e *repository.HabitEntry) error {
+		dates = append(dates, e.Date)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"2021-03-01", "2021-03-08", "2021-03-15"}, dates)
+
+	// Errors stop the iteration
+	stop := errors.New("stop")
+	calls := 0
+	err = hs.EachHabitEntry(ctx, aliceID, func(e *repository.HabitEntry) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+
+	err = hs.EachHabitEntry(ctx, bobID, func(e *repository.HabitEntry) error {
+		t.Error("bob has no entries")
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func testOtherUsersEntriesAreNotFound(t *testing.T, hs internal.HabitzServicer) {
+	aliceID := newUser(t, hs, "alice")
+	bobID := newUser(t, hs, "bob")
+
+	_, entry := scheduledHabit(t, hs, aliceID)
+
+	found, err := hs.HabitEntry(ctx, bobID, entry.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	_, err = hs.UpdateHabitEntry(ctx, bobID, entry.ID, true)
+	assert.Equal(t, internal.ErrNotFound, err)
+
+	_, err = hs.SetHabitEntryValue(ctx, bobID, entry.ID, 10)
+	assert.Equal(t, internal.ErrNotFound, err)
+
+	_, err = hs.IncrementHabitEntry(ctx, bobID, entry.ID, 1)
+	assert.Equal(t, internal.ErrNotFound, err)
+
+	entries, err := hs.HabitEntries(ctx, aliceID, "2021-03-01")
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.False(t, entries[0].Complete)
+	assert.Equal(t, 0.0, entries[0].Value)
+
+	// The owner can still update it
+	updated, err := hs.UpdateHabitEntry(ctx, aliceID, entry.ID, true)
+	assert.Nil(t, err)
+	assert.True(t, updated.Complete)
+}
+
+func testOtherUsersHabitsAreNotFound(t *testing.T, hs internal.HabitzServicer) {
+	aliceID := newUser(t, hs, "alice")
+	bobID := newUser(t, hs, "bob")
+
+	habit, _ := scheduledHabit(t, hs, aliceID)
+
+	found, err := hs.Habit(ctx, bobID, habit.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+
+	_, err = hs.UpdateHabit(ctx, bobID, &repository.Habit{ID: habit.ID, Name: "Walk", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Equal(t, internal.ErrNotFound, err)
+
+	_, err = hs.ArchiveHabit(ctx, bobID, habit.ID, true)
+	assert.Equal(t, internal.ErrNotFound, err)
+
+	assert.Equal(t, internal.ErrNotFound, hs.CreateTemplate(ctx, bobID, "tuesday", habit.ID))
+	assert.Equal(t, internal.ErrNotFound, hs.RemoveTemplate(ctx, bobID, "monday", habit.ID))
+	assert.Equal(t, internal.ErrNotFound, hs.SetHabitRecurrence(ctx, bobID, habit.ID, "FREQ=DAILY", "2021-03-01"))
+	assert.Equal(t, internal.ErrNotFound, hs.RemoveHabit(ctx, bobID, habit.ID))
+
+	_, err = hs.CreateHabitEntry(ctx, bobID, "2021-03-02", "tuesday", habit.ID)
+	assert.Equal(t, internal.ErrNotFound, err)
+
+	// Nothing changed for alice
+	found, err = hs.Habit(ctx, aliceID, habit.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Run", found.Name)
+	assert.Nil(t, found.ArchivedAt)
+
+	templates, err := hs.WeekdayTemplates(ctx, aliceID, "monday")
+	assert.Nil(t, err)
+	assert.Len(t, templates, 1)
+
+	templates, err = hs.WeekdayTemplates(ctx, aliceID, "tuesday")
+	assert.Nil(t, err)
+	assert.Empty(t, templates)
+
+	entries, err := hs.HabitEntries(ctx, bobID, "2021-03-02")
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
+
+func testOtherUsersTypesAreNotFound(t *testing.T, hs internal.HabitzServicer) {
+	aliceID := newUser(t, hs, "alice")
+	bobID := newUser(t, hs, "bob")
+
+	aliceType, err := hs.CreateHabitType(ctx, aliceID, &repository.HabitType{Name: "Health"})
+	assert.Nil(t, err)
+
+	bobHabit, err := hs.CreateHabit(ctx, bobID, &repository.Habit{Name: "Read", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+
+	assert.Equal(t, internal.ErrNotFound, hs.SetHabitType(ctx, bobID, bobHabit.ID, &aliceType.ID))
+	assert.Equal(t, internal.ErrNotFound, hs.RemoveHabitType(ctx, bobID, aliceType.ID))
+
+	found, err := hs.HabitType(ctx, bobID, aliceType.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, found)
+}
+
+func testSessions(t *testing.T, hs internal.HabitzServicer) {
+	aliceID := newUser(t, hs, "alice")
+	bobID := newUser(t, hs, "bob")
+
+	now := time.Now()
+	for _, id := range []string{"phone", "laptop"} {
+		err := hs.CreateSession(ctx, &repository.Session{
+			ID:               id,
+			UserID:           aliceID,
+			RefreshTokenHash: "hash-" + id,
+			CreatedAt:        now,
+			ExpiresAt:        now.Add(time.Hour),
+		})
+		assert.Nil(t, err)
+	}
+
+	session, err := hs.SessionWithRefreshToken(ctx, "hash-phone")
+	assert.Nil(t, err)
+	assert.Equal(t, "phone", session.ID)
+	assert.Equal(t, aliceID, session.UserID)
+	assert.WithinDuration(t, now.Add(time.Hour), session.ExpiresAt, time.Second)
+
+	// The old refresh token can only be rotated once
+	assert.Nil(t, hs.RotateSession(ctx, "phone", "hash-phone", "hash-phone-2", now.Add(time.Hour)))
+	assert.Equal(t, internal.ErrNotFound, hs.RotateSession(ctx, "phone", "hash-phone", "hash-phone-3", now.Add(time.Hour)))
+
+	session, err = hs.SessionWithRefreshToken(ctx, "hash-phone")
+	assert.Nil(t, err)
+	assert.Nil(t, session)
+
+	// Other users can't sign out alice
+	assert.Equal(t, internal.ErrNotFound, hs.RevokeSession(ctx, bobID, "phone"))
+
+	assert.Nil(t, hs.RevokeSession(ctx, aliceID, "phone"))
+	session, err = hs.Session(ctx, "phone")
+	assert.Nil(t, err)
+	assert.NotNil(t, session.RevokedAt)
+
+	session, err = hs.Session(ctx, "laptop")
+	assert.Nil(t, err)
+	assert.Nil(t, session.RevokedAt)
+
+	assert.Nil(t, hs.RevokeAllSessions(ctx, aliceID))
+	session, err = hs.Session(ctx, "laptop")
+	assert.Nil(t, err)
+	assert.NotNil(t, session.RevokedAt)
+}
+
+func testMaterializedThrough(t *testing.T, hs internal.HabitzServicer) {
+	userID := newUser(t, hs, "alice")
+
+	date, err := hs.MaterializedThrough(ctx, userID)
+	assert.Nil(t, err)
+	assert.Equal(t, "", date)
+
+	assert.Nil(t, hs.SetMaterializedThrough(ctx, userID, "2021-03-01"))
+	assert.Nil(t, hs.SetMaterializedThrough(ctx, userID, "2021-03-08"))
+
+	date, err = hs.MaterializedThrough(ctx, userID)
+	assert.Nil(t, err)
+	assert.Equal(t, "2021-03-08", date)
+}
+
+func testImport(t *testing.T, hs internal.HabitzServicer) {
+	userID := newUser(t, hs, "alice")
+
+	// Alice has a habit called Run with an entry on 2021-03-01
+	scheduledHabit(t, hs, userID)
+
+	data := &repository.HabitImport{
+		Habits: []*repository.ImportedHabit{
+			{Habit: repository.Habit{Name: "run", Kind: repository.HabitKindCheck, Target: 1}, Weekdays: []string{"monday", "friday"}},
+			{Habit: repository.Habit{Name: "Read", Kind: repository.HabitKindCheck, Target: 1}, Weekdays: []string{"sunday"}},
+		},
+		Entries: []*repository.ImportedEntry{
+			{Habit: "Run", Date: "2021-03-01", Complete: true},
+			{Habit: "Run", Date: "2021-03-05", Complete: true},
+			{Habit: "Read", Date: "2021-03-07"},
+			{Habit: "Swim", Date: "2021-03-07"},
+		},
+	}
+
+	dryRun, err := hs.Import(ctx, userID, data, true)
+	assert.Nil(t, err)
+	assert.True(t, dryRun.DryRun)
+	assert.Equal(t, []string{"Read"}, dryRun.HabitsCreated)
+	assert.Equal(t, []string{"run"}, dryRun.HabitsExisting)
+	assert.Equal(t, 2, dryRun.TemplatesCreated) // Monday exists
+	assert.Equal(t, 2, dryRun.EntriesCreated)
+	assert.Equal(t, 2, dryRun.EntriesConflicting)
+	assert.Equal(t, "entry exists", dryRun.Conflicts[0].Reason)
+	assert.Equal(t, "unknown habit", dryRun.Conflicts[1].Reason)
+
+	// Nothing was stored
+	habits, err := hs.Habits(ctx, userID, true)
+	assert.Nil(t, err)
+	assert.Len(t, habits, 1)
+
+	result, err := hs.Import(ctx, userID, data, false)
+	assert.Nil(t, err)
+	dryRun.DryRun = false
+	assert.Equal(t, dryRun, result)
+
+	entries, err := hs.HabitEntries(ctx, userID, "2021-03-05")
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "friday", entries[0].Weekday)
+	assert.True(t, entries[0].Complete)
+
+	// Importing again only finds conflicts
+	again, err := hs.Import(ctx, userID, data, false)
+	assert.Nil(t, err)
+	assert.Empty(t, again.HabitsCreated)
+	assert.Equal(t, 0, again.EntriesCreated)
+	assert.Equal(t, 4, again.EntriesConflicting)
+}
@@ -0,0 +1,35 @@
+package sqlite_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/jfernstad/habitz/web/internal/servicetest"
+	"github.com/jfernstad/habitz/web/internal/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConformance(t *testing.T) {
+	servicetest.Run(t, func(t *testing.T) (internal.HabitzServicer, func()) {
+		db := newTestDB(t)
+		return sqlite.NewHabitzService(db, false), func() { db.Close() }
+	})
+}
+
+// Queries of cancelled requests aren't run
+func TestCancelledContext(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	hs := sqlite.NewHabitzService(db, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := hs.AllUsers(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = hs.CreateHabit(ctx, "u1", &repository.Habit{Name: "Read"})
+	assert.Equal(t, context.Canceled, err)
+}
@@ -1,62 +1,28 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/middleware"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/mattn/go-sqlite3"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/queries"
 	"github.com/jfernstad/habitz/web/internal/repository"
 )
 
-const createUserTable = `
-CREATE TABLE IF NOT EXISTS users(
-	id text PRIMARY KEY,
-	firstname TEXT,
-	lastname TEXT,
-	email TEXT,
-	profile_image TEXT
-);
-`
-
-const createExternalUserTable = `
-CREATE TABLE IF NOT EXISTS external_users(
-	id text PRIMARY KEY,
-	provider TEXT,
-	user_id TEXT,
-	FOREIGN KEY(user_id) REFERENCES users(id)
-);
-`
-
-const createHabitTemplateTable = `
-CREATE TABLE IF NOT EXISTS habit_templates (
-	user_id text,
-	weekday TEXT,
-	habit TEXT,
-	PRIMARY KEY (user_id, weekday, habit)
-) WITHOUT ROWID;
-`
-
-const createEntryTable = `
-CREATE TABLE IF NOT EXISTS habit_entries(
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	user_id text,
-	weekday TEXT,
-	date TEXT,
-	habit TEXT,
-	complete INTEGER,
-	complete_at TIMESTAMP
-);
-`
-
 const sqlTimeFormat = "2006-01-02 15:04:05"
 
+// build starts the queries shared with the other databases
+var build = queries.New(sq.Question)
+
 type habitzService struct {
 	db    *sqlx.DB
 	debug bool
@@ -68,49 +34,36 @@ func NewHabitzService(db *sqlx.DB, debug bool) internal.HabitzServicer {
 		debug: debug,
 	}
 
-	if err := hs.initSQLDatabase(); err != nil {
-		log.Fatal("initSQLDatabase: ", err)
-	}
-
-	return hs
-}
-
-func (m *habitzService) initSQLDatabase() error {
-	_, err := m.db.Exec(createUserTable)
+	applied, err := Migrate(db)
 	if err != nil {
-		return err
+		log.Fatal("migrate: ", err)
 	}
 
-	_, err = m.db.Exec(createExternalUserTable)
-	if err != nil {
-		return err
+	for _, mig := range applied {
+		log.Printf("Applied migration %d: %s\n", mig.Version, mig.Description)
 	}
 
-	_, err = m.db.Exec(createHabitTemplateTable)
-	if err != nil {
-		return err
-	}
+	return hs
+}
 
-	_, err = m.db.Exec(createEntryTable)
-	if err != nil {
-		return err
+// log prints queries in debug mode, with the ID of the request that made them
+func (m *habitzService) log(ctx context.Context, msg string) {
+	if !m.debug {
+		return
 	}
 
-	return nil
-}
-
-func (m *habitzService) log(msg string) {
-	if m.debug {
-		log.Println("sql: " + msg)
+	if reqID := middleware.GetReqID(ctx); reqID != "" {
+		msg = "[" + reqID + "] " + msg
 	}
+	log.Println("sql: " + msg)
 }
 
-func (m *habitzService) Users() ([]string, error) { // Probably obsolete
+func (m *habitzService) Users(ctx context.Context) ([]string, error) { // Probably obsolete
 	sql, _, _ := sq.Select("*").From("users").ToSql()
 
-	m.log("Users: " + sql)
+	m.log(ctx, "Users: "+sql)
 
-	rows, err := m.db.Queryx(sql)
+	rows, err := m.db.QueryxContext(ctx, sql)
 
 	if err != nil {
 		return nil, err
@@ -125,7 +78,7 @@ func (m *habitzService) Users() ([]string, error) { // Probably obsolete
 		if err = rows.StructScan(&user); err != nil {
 			return nil, err
 		}
-		m.log(" - " + user.Firstname)
+		m.log(ctx, " - "+user.Firstname)
 		users = append(users, user.Firstname)
 	}
 
@@ -136,7 +89,51 @@ func (m *habitzService) Users() ([]string, error) { // Probably obsolete
 	return users, nil
 }
 
-func (m *habitzService) UserWithExternalID(externalID string, provider string) (*repository.User, error) {
+func (m *habitzService) AllUsers(ctx context.Context) ([]*repository.User, error) {
+	usersQuery, _, _ := sq.Select("*").From("users").OrderBy("id").ToSql()
+
+	m.log(ctx, "AllUsers: "+usersQuery)
+
+	users := []*repository.User{}
+	if err := m.db.SelectContext(ctx, &users, usersQuery); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (m *habitzService) User(ctx context.Context, userID string) (*repository.User, error) {
+	userQuery, args, _ := sq.Select("*").
+		From("users").Where(sq.Eq{"id": userID}).
+		ToSql()
+
+	m.log(ctx, "User: "+userQuery+" >> "+userID)
+
+	user := repository.User{}
+	if err := m.db.QueryRowxContext(ctx, userQuery, args...).StructScan(&user); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m *habitzService) SetUserTimezone(ctx context.Context, userID, timezone string) error {
+	sql, args, _ := sq.Update("users").
+		Set("timezone", timezone).
+		Where(sq.Eq{"id": userID}).
+		ToSql()
+
+	m.log(ctx, "SetUserTimezone: "+sql+" >> "+userID+", "+timezone)
+
+	if _, err := m.db.ExecContext(ctx, sql, args...); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *habitzService) UserWithExternalID(ctx context.Context, externalID string, provider string) (*repository.User, error) {
 
 	extUserQuery, args, _ := sq.Select("user_id").
 		From("external_users").Where(sq.Eq{"id": externalID, "provider": provider}).
@@ -146,42 +143,46 @@ func (m *habitzService) UserWithExternalID(externalID string, provider string) (
 		From("users").Where("id = ("+extUserQuery+")", args).
 		ToSql()
 
-	m.log("Users: " + userQuery)
+	m.log(ctx, "Users: "+userQuery)
 
 	user := repository.User{}
-	row := m.db.QueryRowx(userQuery, args...)
+	row := m.db.QueryRowxContext(ctx, userQuery, args...)
 
 	if err := row.StructScan(&user); err != nil {
 		// Empty rows is not an error (in my mind at least)
 		if err == sql.ErrNoRows {
-			m.log(" NO ROWS ")
+			m.log(ctx, " NO ROWS ")
 			return nil, nil
 		} else {
-			m.log(" Some other error? ")
-			return nil, row.Err()
+			m.log(ctx, " Some other error? ")
+			return nil, err
 		}
 	}
 	return &user, nil
 }
 
-func (m *habitzService) CreateExternalUser(ext *repository.ExternalUser) (*repository.User, error) {
-	newUserID := "u" + internal.NewRandomString(12) // Assume this is unique enough. TODO: Generate ID in database
+func newUserID() string {
+	return "u" + internal.NewRandomString(12) // Assume this is unique enough. TODO: Generate ID in database
+}
+
+func (m *habitzService) CreateExternalUser(ctx context.Context, ext *repository.ExternalUser) (*repository.User, error) {
+	newUserID := newUserID()
 	sql, args, _ := sq.Insert("users").
-		Columns("id", "firstname", "lastname", "email", "profile_image").
-		Values(newUserID, ext.Firstname, ext.Lastname, ext.Email, ext.ProfileImageURL).
+		Columns("id", "firstname", "lastname", "email", "profile_image", "timezone").
+		Values(newUserID, ext.Firstname, ext.Lastname, ext.Email, ext.ProfileImageURL, ext.Timezone).
 		ToSql()
 
-	m.log("CreateExternalUser: " + sql + " >>  " + ext.Firstname)
+	m.log(ctx, "CreateExternalUser: "+sql+" >>  "+ext.Firstname)
 
-	if _, err := m.db.Exec(sql, args...); err != nil {
+	if _, err := m.db.ExecContext(ctx, sql, args...); err != nil {
 		return nil, err
 	}
 
 	sql, args, _ = sq.Insert("external_users").
-		Columns("id", "provider", "user_id").
-		Values(ext.ExternalID, ext.Provider, newUserID).ToSql()
+		Columns("id", "provider", "user_id", "email", "linked_at").
+		Values(ext.ExternalID, ext.Provider, newUserID, ext.Email, time.Now().UTC().Format(sqlTimeFormat)).ToSql()
 
-	if _, err := m.db.Exec(sql, args...); err != nil {
+	if _, err := m.db.ExecContext(ctx, sql, args...); err != nil {
 		return nil, err
 	}
 
@@ -190,16 +191,15 @@ func (m *habitzService) CreateExternalUser(ext *repository.ExternalUser) (*repos
 	return &ext.User, nil
 }
 
-func (m *habitzService) Templates(userID string) ([]*repository.WeekHabitTemplates, error) {
-	sql, args, _ := sq.Select("user_id", "weekday", "habit").
-		From("habit_templates").
-		Where(sq.Eq{"user_id": userID}).
-		Suffix("COLLATE NOCASE").
+func (m *habitzService) Templates(ctx context.Context, userID string) ([]*repository.WeekHabitTemplates, error) {
+	sql, args, _ := build.Templates().
+		Where(sq.Eq{"t.user_id": userID}).
+		OrderBy("h.id").
 		ToSql()
 
-	m.log("Templates: " + sql + " >> " + userID)
+	m.log(ctx, "Templates: "+sql+" >> "+userID)
 
-	rows, err := m.db.Queryx(sql, args...)
+	rows, err := m.db.QueryxContext(ctx, sql, args...)
 
 	if err != nil {
 		return nil, err
@@ -214,11 +214,11 @@ func (m *habitzService) Templates(userID string) ([]*repository.WeekHabitTemplat
 		if err = rows.StructScan(&tmpl); err != nil {
 			return nil, err
 		}
-		m.log(fmt.Sprintf(" - %+v", tmpl))
+		m.log(ctx, fmt.Sprintf(" - %+v", tmpl))
 
 		exist := false
 		for _, ut := range userTemplates {
-			if ut.Habit == tmpl.Habit { // Already in array, append weekday
+			if ut.HabitID == tmpl.HabitID { // Already in array, append weekday
 				ut.Weekdays = append(ut.Weekdays, tmpl.Weekday)
 				exist = true
 				break
@@ -227,7 +227,9 @@ func (m *habitzService) Templates(userID string) ([]*repository.WeekHabitTemplat
 
 		if !exist {
 			weekTmpl.UserID = tmpl.UserID
+			weekTmpl.HabitID = tmpl.HabitID
 			weekTmpl.Habit = tmpl.Habit
+			weekTmpl.TypeID = tmpl.TypeID
 			weekTmpl.Weekdays = []string{tmpl.Weekday}
 			userTemplates = append(userTemplates, &weekTmpl)
 			continue
@@ -242,15 +244,15 @@ func (m *habitzService) Templates(userID string) ([]*repository.WeekHabitTemplat
 
 }
 
-func (m *habitzService) WeekdayTemplates(userID, weekday string) ([]*repository.WeekdayHabitTemplate, error) {
-	sql, args, _ := sq.Select("user_id", "weekday", "habit").
-		From("habit_templates").
-		Where(sq.Eq{"user_id": userID, "weekday": weekday}).
+func (m *habitzService) WeekdayTemplates(ctx context.Context, userID, weekday string) ([]*repository.WeekdayHabitTemplate, error) {
+	sql, args, _ := build.Templates().
+		Where(sq.Eq{"t.user_id": userID, "t.weekday": weekday}).
+		OrderBy("h.id").
 		ToSql()
 
-	m.log("WeekdayTemplates: " + sql + " >> " + userID + ", " + weekday)
+	m.log(ctx, "WeekdayTemplates: "+sql+" >> "+userID+", "+weekday)
 
-	rows, err := m.db.Queryx(sql, args...)
+	rows, err := m.db.QueryxContext(ctx, sql, args...)
 
 	if err != nil {
 		return nil, err
@@ -264,7 +266,7 @@ func (m *habitzService) WeekdayTemplates(userID, weekday string) ([]*repository.
 		if err = rows.StructScan(&tmpl); err != nil {
 			return nil, err
 		}
-		m.log(fmt.Sprintf(" - %+v", tmpl))
+		m.log(ctx, fmt.Sprintf(" - %+v", tmpl))
 
 		userTemplates = append(userTemplates, &tmpl)
 	}
@@ -276,58 +278,66 @@ func (m *habitzService) WeekdayTemplates(userID, weekday string) ([]*repository.
 	return userTemplates, nil
 }
 
-func (m *habitzService) CreateTemplate(userID, weekday, habit string) error {
+func (m *habitzService) CreateTemplate(ctx context.Context, userID, weekday string, habitID int) error {
+	if err := m.ownsHabit(ctx, userID, habitID); err != nil {
+		return err
+	}
+
 	sql, args, _ := sq.Insert("habit_templates").
-		Columns("user_id", "weekday", "habit").Values(userID, weekday, habit).
+		Columns("user_id", "weekday", "habit_id").Values(userID, weekday, habitID).
 		ToSql()
 
-	m.log("CreateTemplate: " + sql + " >> " + userID + ", " + weekday + ", " + habit)
+	m.log(ctx, "CreateTemplate: "+sql+" >> "+userID+", "+weekday+", "+strconv.Itoa(habitID))
 
-	if _, err := m.db.Exec(sql, args...); err != nil {
+	if _, err := m.db.ExecContext(ctx, sql, args...); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (m *habitzService) RemoveTemplate(userID, weekday, habit string) error {
+func (m *habitzService) RemoveTemplate(ctx context.Context, userID, weekday string, habitID int) error {
 	sql, args, _ := sq.Delete("habit_templates").
-		Where(sq.Eq{"user_id": userID, "weekday": weekday, "habit": habit}).
+		Where(sq.Eq{"user_id": userID, "weekday": weekday, "habit_id": habitID}).
 		ToSql()
 
-	m.log("RemoveTemplate: " + sql + " >> " + userID + ", " + weekday + ", " + habit)
+	m.log(ctx, "RemoveTemplate: "+sql+" >> "+userID+", "+weekday+", "+strconv.Itoa(habitID))
 
-	if _, err := m.db.Exec(sql, args...); err != nil {
+	res, err := m.db.ExecContext(ctx, sql, args...)
+	if err != nil {
 		return err
 	}
 
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+
 	return nil
 }
 
-func (m *habitzService) RemoveEntry(userID, habit string, date time.Time) error {
-	shortDate := internal.ShortDate(date)
+func (m *habitzService) RemoveEntry(ctx context.Context, userID string, habitID int, date string) error {
 	sql, args, _ := sq.Delete("habit_entries").
-		Where(sq.Eq{"user_id": userID, "date": shortDate, "habit": habit}).
+		Where(sq.Eq{"user_id": userID, "date": date, "habit_id": habitID}).
 		ToSql()
 
-	m.log("RemoveEntry: " + sql + " >> " + userID + ", " + shortDate + ", " + habit)
+	m.log(ctx, "RemoveEntry: "+sql+" >> "+userID+", "+date+", "+strconv.Itoa(habitID))
 
-	if _, err := m.db.Exec(sql, args...); err != nil {
+	if _, err := m.db.ExecContext(ctx, sql, args...); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (m *habitzService) HabitEntries(userID string, date string) ([]*repository.HabitEntry, error) {
-	sql, args, _ := sq.Select("*").
-		From("habit_entries").
-		Where(sq.Eq{"user_id": userID, "date": date}).
+func (m *habitzService) HabitEntries(ctx context.Context, userID string, date string) ([]*repository.HabitEntry, error) {
+	sql, args, _ := build.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.date": date}).
+		OrderBy("e.id").
 		ToSql()
 
-	m.log("HabitEntries: " + sql + " >> " + userID + ", " + date)
+	m.log(ctx, "HabitEntries: "+sql+" >> "+userID+", "+date)
 
-	rows, err := m.db.Queryx(sql, args...)
+	rows, err := m.db.QueryxContext(ctx, sql, args...)
 
 	if err != nil {
 		return nil, err
@@ -343,7 +353,7 @@ func (m *habitzService) HabitEntries(userID string, date string) ([]*repository.
 			return nil, err
 		}
 
-		m.log(fmt.Sprintf(" - %+v", entry))
+		m.log(ctx, fmt.Sprintf(" - %+v", entry))
 
 		habitEntries = append(habitEntries, &entry)
 	}
@@ -355,39 +365,187 @@ func (m *habitzService) HabitEntries(userID string, date string) ([]*repository.
 	return habitEntries, nil
 }
 
-func (m *habitzService) CreateHabitEntry(userID, weekday, habit string) (*repository.HabitEntry, error) {
+// HabitEntry finds an entry of the user, other users entries are not found
+func (m *habitzService) HabitEntry(ctx context.Context, userID string, id int) (*repository.HabitEntry, error) {
+	query, args, _ := build.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.id": id}).
+		ToSql()
+
+	m.log(ctx, "HabitEntry: "+query+" >> "+userID+", "+strconv.Itoa(id))
+
+	entry := repository.HabitEntry{}
+	if err := m.db.QueryRowxContext(ctx, query, args...).StructScan(&entry); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// EachHabitEntry calls fn with every entry of the user, oldest first, one row at a time.
+// Stops at the first error returned by fn.
+func (m *habitzService) EachHabitEntry(ctx context.Context, userID string, fn func(*repository.HabitEntry) error) error {
+	sql, args, _ := build.Entries().
+		Where(sq.Eq{"e.user_id": userID}).
+		OrderBy("e.date", "e.id").
+		ToSql()
+
+	m.log(ctx, "EachHabitEntry: "+sql+" >> "+userID)
+
+	rows, err := m.db.QueryxContext(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry repository.HabitEntry
+		if err = rows.StructScan(&entry); err != nil {
+			return err
+		}
+		if err = fn(&entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// HabitEntriesBetween returns all entries from `from` to `to`, both dates included.
+// Entries are ordered by date, use limit and offset to page through them.
+func (m *habitzService) HabitEntriesBetween(ctx context.Context, userID string, from, to string, limit, offset int) ([]*repository.HabitEntry, error) {
+	sql, args, _ := build.Entries().
+		Where(sq.Eq{"e.user_id": userID}).
+		Where(sq.GtOrEq{"e.date": from}).
+		Where(sq.LtOrEq{"e.date": to}).
+		OrderBy("e.date", "e.id").
+		Limit(uint64(limit)).
+		Offset(uint64(offset)).
+		ToSql()
+
+	m.log(ctx, "HabitEntriesBetween: "+sql+" >> "+userID+", "+from+", "+to)
+
+	rows, err := m.db.QueryxContext(ctx, sql, args...)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	habitEntries := []*repository.HabitEntry{}
+
+	for rows.Next() {
+		var entry repository.HabitEntry
+
+		if err = rows.StructScan(&entry); err != nil {
+			return nil, err
+		}
+
+		habitEntries = append(habitEntries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return habitEntries, nil
+}
 
-	today := internal.Today()
+func (m *habitzService) CreateHabitEntry(ctx context.Context, userID, date, weekday string, habitID int) (*repository.HabitEntry, error) {
+	if err := m.ownsHabit(ctx, userID, habitID); err != nil {
+		return nil, err
+	}
 
 	sql, args, _ := sq.Insert("habit_entries").
-		Columns("user_id", "weekday", "habit", "date", "complete").
-		Values(userID, weekday, habit, today, 0).
+		Columns("user_id", "weekday", "habit_id", "date", "complete").
+		Values(userID, weekday, habitID, date, 0).
 		ToSql()
 
-	m.log("CreateHabitEntry:" + " >> " + userID + ", " + weekday + ", " + habit)
+	m.log(ctx, "CreateHabitEntry: "+sql+" >> "+userID+", "+date+", "+weekday+", "+strconv.Itoa(habitID))
+
+	res, err := m.db.ExecContext(ctx, sql, args...)
+	if isUniqueViolation(err) {
+		return nil, internal.ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err := m.db.Exec(sql, args...); err != nil {
+	id, err := res.LastInsertId()
+	if err != nil {
 		return nil, err
 	}
 
-	// Retrieve last insert values
 	entry := repository.HabitEntry{}
 
-	sql, _, _ = sq.Select("*").
-		From("habit_entries").
-		OrderBy("id desc").
-		Limit(1).ToSql()
+	sql, args, _ = build.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.id": id}).
+		ToSql()
 
-	if err := m.db.QueryRowx(sql).StructScan(&entry); err != nil {
+	if err := m.db.QueryRowxContext(ctx, sql, args...).StructScan(&entry); err != nil {
 		return nil, err
 	}
 
-	m.log(fmt.Sprintf(" - %+v", entry))
+	m.log(ctx, fmt.Sprintf(" - %+v", entry))
 
 	return &entry, nil
 }
 
-func (m *habitzService) UpdateHabitEntry(id int, complete bool) (*repository.HabitEntry, error) {
+// EnsureHabitEntries creates the missing entries of the habitz on `date` in one transaction.
+// Existing entries are left as they are, so concurrent calls don't create duplicates.
+// Only habitz of the user get entries. Returns all entries of that date.
+func (m *habitzService) EnsureHabitEntries(ctx context.Context, userID, date string, habitIDs []int) ([]*repository.HabitEntry, error) {
+	weekday, err := internal.WeekdayOf(date)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if len(habitIDs) > 0 {
+		sql, args, _ := sq.Insert("habit_entries").
+			Options("OR IGNORE").
+			Columns("user_id", "weekday", "habit_id", "date", "complete").
+			Select(sq.Select().
+				Column("?", userID).
+				Column("?", weekday).
+				Column("id").
+				Column("?", date).
+				Column("0").
+				From("habits").
+				Where(sq.Eq{"user_id": userID, "id": habitIDs})).
+			ToSql()
+
+		m.log(ctx, "EnsureHabitEntries: "+sql+" >> "+userID+", "+date+", "+fmt.Sprint(habitIDs))
+
+		if _, err := tx.ExecContext(ctx, sql, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	sql, args, _ := build.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.date": date}).
+		OrderBy("e.id").
+		ToSql()
+
+	entries := []*repository.HabitEntry{}
+	if err := tx.SelectContext(ctx, &entries, sql, args...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// UpdateHabitEntry completes an entry of the user, other users entries are not found
+func (m *habitzService) UpdateHabitEntry(ctx context.Context, userID string, id int, complete bool) (*repository.HabitEntry, error) {
 
 	query := sq.Update("habit_entries").
 		Set("complete", complete)
@@ -398,27 +556,122 @@ func (m *habitzService) UpdateHabitEntry(id int, complete bool) (*repository.Hab
 	}
 
 	sql, args, _ := query.
-		Where(sq.Eq{"id": id}).ToSql()
+		Where(sq.Eq{"user_id": userID, "id": id}).ToSql()
 
-	m.log("UpdateHabitEntry: " + sql + " >> " + strconv.FormatInt(int64(id), 10) + ", " + strconv.FormatBool(complete))
+	m.log(ctx, "UpdateHabitEntry: "+sql+" >> "+userID+", "+strconv.FormatInt(int64(id), 10)+", "+strconv.FormatBool(complete))
 
-	if _, err := m.db.Exec(sql, args...); err != nil {
+	res, err := m.db.ExecContext(ctx, sql, args...)
+	if err != nil {
 		return nil, err
 	}
 
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, internal.ErrNotFound
+	}
+
 	// Retrieve full object
-	sql, args, _ = sq.Select("*").
-		From("habit_entries").
-		Where(sq.Eq{"id": id}).
+	sql, args, _ = build.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.id": id}).
 		ToSql()
 
 	entry := repository.HabitEntry{}
 
-	if err := m.db.QueryRowx(sql, args...).StructScan(&entry); err != nil {
+	if err := m.db.QueryRowxContext(ctx, sql, args...).StructScan(&entry); err != nil {
 		return nil, err
 	}
 
-	m.log(fmt.Sprintf(" - %+v", entry))
+	m.log(ctx, fmt.Sprintf(" - %+v", entry))
 
 	return &entry, nil
 }
+
+// SetHabitEntryValue records the value of a quantitative entry.
+// The entry is complete once the value reaches the target of the habit.
+func (m *habitzService) SetHabitEntryValue(ctx context.Context, userID string, id int, value float64) (*repository.HabitEntry, error) {
+	return m.updateHabitEntryValue(ctx, userID, id, sq.Expr("?", value))
+}
+
+// IncrementHabitEntry adds `delta` to the value of the entry, e.g. one more glass of water
+func (m *habitzService) IncrementHabitEntry(ctx context.Context, userID string, id int, delta float64) (*repository.HabitEntry, error) {
+	return m.updateHabitEntryValue(ctx, userID, id, sq.Expr("value + ?", delta))
+}
+
+func (m *habitzService) updateHabitEntryValue(ctx context.Context, userID string, id int, value sq.Sqlizer) (*repository.HabitEntry, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	valueSQL, valueArgs, _ := value.ToSql()
+	sql, args, _ := sq.Update("habit_entries").
+		Set("value", sq.Expr("MAX(0, "+valueSQL+")", valueArgs...)).
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	m.log(ctx, "updateHabitEntryValue: "+sql+" >> "+userID+", "+strconv.Itoa(id))
+
+	res, err := tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, internal.ErrNotFound
+	}
+
+	// Complete once the target is reached, `complete` on the right hand side is the previous value
+	target := "(SELECT target FROM habits WHERE habits.id = habit_entries.habit_id)"
+	sql, args, _ = sq.Update("habit_entries").
+		Set("complete_at", sq.Expr("CASE WHEN complete = 0 AND value >= "+target+" THEN ? ELSE complete_at END", time.Now().UTC().Format(sqlTimeFormat))).
+		Set("complete", sq.Expr("value >= "+target)).
+		Where(sq.Eq{"user_id": userID, "id": id}).
+		ToSql()
+
+	if _, err := tx.ExecContext(ctx, sql, args...); err != nil {
+		return nil, err
+	}
+
+	sql, args, _ = build.Entries().
+		Where(sq.Eq{"e.user_id": userID, "e.id": id}).
+		ToSql()
+
+	entry := repository.HabitEntry{}
+	if err := tx.QueryRowxContext(ctx, sql, args...).StructScan(&entry); err != nil {
+		return nil, err
+	}
+
+	m.log(ctx, fmt.Sprintf(" - %+v", entry))
+
+	return &entry, tx.Commit()
+}
+
+// MaterializedThrough is the last date the scheduler created entries for, empty if never
+func (m *habitzService) MaterializedThrough(ctx context.Context, userID string) (string, error) {
+	query, args, _ := sq.Select("materialized_through").
+		From("scheduler_runs").
+		Where(sq.Eq{"user_id": userID}).
+		ToSql()
+
+	var date string
+	if err := m.db.GetContext(ctx, &date, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return date, nil
+}
+
+func (m *habitzService) SetMaterializedThrough(ctx context.Context, userID, date string) error {
+	query, args, _ := sq.Insert("scheduler_runs").
+		Columns("user_id", "materialized_through").
+		Values(userID, date).
+		Suffix("ON CONFLICT(user_id) DO UPDATE SET materialized_through = excluded.materialized_through").
+		ToSql()
+
+	m.log(ctx, "SetMaterializedThrough: "+query+" >> "+userID+", "+date)
+
+	_, err := m.db.ExecContext(ctx, query, args...)
+	return err
+}
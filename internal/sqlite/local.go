@@ -0,0 +1,94 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// CreateLocalUser creates a user that logs in with email and password.
+// Returns ErrAlreadyExists if the email is taken.
+func (m *habitzService) CreateLocalUser(ctx context.Context, user *repository.User, passwordHash string) (*repository.User, error) {
+	userID := newUserID()
+
+	m.log(ctx, "CreateLocalUser: >> "+userID+", "+user.Email)
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The credentials reference the user, so the user goes first
+	insert, args, _ := sq.Insert("users").
+		Columns("id", "firstname", "lastname", "email", "profile_image", "timezone").
+		Values(userID, user.Firstname, user.Lastname, user.Email, user.ProfileImageURL, user.Timezone).
+		ToSql()
+
+	if _, err := tx.ExecContext(ctx, insert, args...); err != nil {
+		return nil, err
+	}
+
+	insert, args, _ = sq.Insert("local_credentials").
+		Columns("email", "user_id", "password_hash", "created_at").
+		Values(user.Email, userID, passwordHash, time.Now().UTC().Format(sqlTimeFormat)).
+		ToSql()
+
+	if _, err := tx.ExecContext(ctx, insert, args...); err != nil {
+		if isUniqueViolation(err) || isPrimaryKeyViolation(err) {
+			return nil, internal.ErrAlreadyExists
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	created := *user
+	created.ID = userID
+	return &created, nil
+}
+
+// LocalCredentials finds the login of an email, ignoring case
+func (m *habitzService) LocalCredentials(ctx context.Context, email string) (*repository.LocalCredentials, error) {
+	credQuery, args, _ := sq.Select("email", "user_id", "password_hash", "created_at").
+		From("local_credentials").
+		Where(sq.Eq{"email": email}).
+		ToSql()
+
+	m.log(ctx, "LocalCredentials: "+credQuery)
+
+	creds := repository.LocalCredentials{}
+	if err := m.db.QueryRowxContext(ctx, credQuery, args...).StructScan(&creds); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// CreateLocalCredentials adds an email and password login to an existing user.
+// Returns ErrAlreadyExists if the email is taken or the user has a password already.
+func (m *habitzService) CreateLocalCredentials(ctx context.Context, userID, email, passwordHash string) error {
+	insert, args, _ := sq.Insert("local_credentials").
+		Columns("email", "user_id", "password_hash", "created_at").
+		Values(email, userID, passwordHash, time.Now().UTC().Format(sqlTimeFormat)).
+		ToSql()
+
+	m.log(ctx, "CreateLocalCredentials: >> "+userID+", "+email)
+
+	if _, err := m.db.ExecContext(ctx, insert, args...); err != nil {
+		if isUniqueViolation(err) || isPrimaryKeyViolation(err) {
+			return internal.ErrAlreadyExists
+		}
+		return err
+	}
+	return nil
+}
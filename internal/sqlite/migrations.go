@@ -0,0 +1,315 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration is a versioned schema change.
+// Never change a released migration, add a new one to the end of `migrations`.
+type Migration struct {
+	Version     int
+	Description string
+	statements  []string
+}
+
+const createSchemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version(
+	version INTEGER PRIMARY KEY,
+	description TEXT,
+	applied_at TIMESTAMP
+);
+`
+
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS users(
+				id text PRIMARY KEY,
+				firstname TEXT,
+				lastname TEXT,
+				email TEXT,
+				profile_image TEXT
+			)`,
+			`CREATE TABLE IF NOT EXISTS external_users(
+				id text PRIMARY KEY,
+				provider TEXT,
+				user_id TEXT,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS habit_templates (
+				user_id text,
+				weekday TEXT,
+				habit TEXT,
+				PRIMARY KEY (user_id, weekday, habit)
+			) WITHOUT ROWID`,
+			`CREATE TABLE IF NOT EXISTS habit_entries(
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id text,
+				weekday TEXT,
+				date TEXT,
+				habit TEXT,
+				complete INTEGER,
+				complete_at TIMESTAMP
+			)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "user timezone",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN timezone TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "habits with stable IDs",
+		statements: []string{
+			`CREATE TABLE habits(
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id TEXT NOT NULL,
+				name TEXT NOT NULL COLLATE NOCASE,
+				description TEXT NOT NULL DEFAULT '',
+				created_at TIMESTAMP NOT NULL,
+				archived_at TIMESTAMP,
+				UNIQUE (user_id, name),
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			// Templates and entries used to store the habit name
+			`INSERT OR IGNORE INTO habits(user_id, name, created_at)
+				SELECT user_id, habit, COALESCE(MIN(date), date('now')) || ' 00:00:00' FROM (
+					SELECT user_id, habit, date FROM habit_entries
+					UNION ALL
+					SELECT user_id, habit, NULL FROM habit_templates
+				) GROUP BY user_id, habit COLLATE NOCASE`,
+
+			`ALTER TABLE habit_templates RENAME TO habit_templates_old`,
+			`CREATE TABLE habit_templates (
+				user_id text,
+				weekday TEXT,
+				habit_id INTEGER,
+				PRIMARY KEY (user_id, weekday, habit_id),
+				FOREIGN KEY(habit_id) REFERENCES habits(id)
+			) WITHOUT ROWID`,
+			`INSERT OR IGNORE INTO habit_templates(user_id, weekday, habit_id)
+				SELECT t.user_id, t.weekday, h.id FROM habit_templates_old t
+				JOIN habits h ON h.user_id = t.user_id AND h.name = t.habit`,
+			`DROP TABLE habit_templates_old`,
+
+			`ALTER TABLE habit_entries RENAME TO habit_entries_old`,
+			`CREATE TABLE habit_entries(
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id text,
+				weekday TEXT,
+				date TEXT,
+				habit_id INTEGER,
+				complete INTEGER,
+				complete_at TIMESTAMP,
+				FOREIGN KEY(habit_id) REFERENCES habits(id)
+			)`,
+			`INSERT INTO habit_entries(id, user_id, weekday, date, habit_id, complete, complete_at)
+				SELECT e.id, e.user_id, e.weekday, e.date, h.id, e.complete, e.complete_at FROM habit_entries_old e
+				JOIN habits h ON h.user_id = e.user_id AND h.name = e.habit`,
+			`DROP TABLE habit_entries_old`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "scheduler state",
+		statements: []string{
+			`CREATE TABLE scheduler_runs(
+				user_id TEXT PRIMARY KEY,
+				materialized_through TEXT NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "habit types",
+		statements: []string{
+			`CREATE TABLE habit_types(
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id TEXT NOT NULL,
+				name TEXT NOT NULL COLLATE NOCASE,
+				color TEXT NOT NULL DEFAULT '',
+				sort_order INTEGER NOT NULL DEFAULT 0,
+				UNIQUE (user_id, name),
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`ALTER TABLE habits ADD COLUMN type_id INTEGER REFERENCES habit_types(id)`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "quantitative habitz",
+		statements: []string{
+			`ALTER TABLE habits ADD COLUMN kind TEXT NOT NULL DEFAULT 'check'`,
+			`ALTER TABLE habits ADD COLUMN target REAL NOT NULL DEFAULT 1`,
+			`ALTER TABLE habits ADD COLUMN unit TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE habit_entries ADD COLUMN value REAL NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "recurrence rules",
+		statements: []string{
+			`ALTER TABLE habits ADD COLUMN recurrence TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE habits ADD COLUMN recurrence_start TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "login sessions",
+		statements: []string{
+			`CREATE TABLE sessions(
+				id TEXT PRIMARY KEY,
+				user_id TEXT NOT NULL,
+				refresh_token_hash TEXT NOT NULL UNIQUE,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL,
+				revoked_at DATETIME,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`CREATE INDEX sessions_user_id ON sessions(user_id)`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "local accounts",
+		statements: []string{
+			`CREATE TABLE local_credentials(
+				email TEXT PRIMARY KEY COLLATE NOCASE,
+				user_id TEXT NOT NULL UNIQUE,
+				password_hash TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "linked identities",
+		statements: []string{
+			// External IDs are only unique per provider
+			`ALTER TABLE external_users RENAME TO external_users_old`,
+			`CREATE TABLE external_users(
+				id TEXT NOT NULL,
+				provider TEXT NOT NULL,
+				user_id TEXT NOT NULL,
+				email TEXT NOT NULL DEFAULT '',
+				linked_at DATETIME,
+				PRIMARY KEY (provider, id),
+				FOREIGN KEY(user_id) REFERENCES users(id)
+			)`,
+			`INSERT INTO external_users(id, provider, user_id, email)
+				SELECT e.id, COALESCE(e.provider, 'google'), e.user_id, COALESCE(u.email, '')
+				FROM external_users_old e LEFT JOIN users u ON u.id = e.user_id`,
+			`DROP TABLE external_users_old`,
+			`CREATE INDEX external_users_user_id ON external_users(user_id)`,
+		},
+	},
+	{
+		Version:     11,
+		Description: "user profiles",
+		statements: []string{
+			`ALTER TABLE users ADD COLUMN preferences TEXT NOT NULL DEFAULT '{}'`,
+			// Admins are appointed with `backend -admin <user id>`
+			`ALTER TABLE users ADD COLUMN is_admin INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		Version:     12,
+		Description: "one entry per habit and day",
+		statements: []string{
+			// Keep the most complete entry of duplicates, they were created by concurrent requests
+			`DELETE FROM habit_entries WHERE id NOT IN (
+				SELECT (SELECT d.id FROM habit_entries d
+					WHERE d.user_id = e.user_id AND d.habit_id = e.habit_id AND d.date = e.date
+					ORDER BY d.complete DESC, d.value DESC, d.id LIMIT 1)
+				FROM habit_entries e GROUP BY e.user_id, e.habit_id, e.date
+			)`,
+			`CREATE UNIQUE INDEX habit_entries_user_habit_date ON habit_entries(user_id, habit_id, date)`,
+		},
+	},
+}
+
+// SchemaVersion is the latest migration applied to the database, 0 for a new database
+func SchemaVersion(db *sqlx.DB) (int, error) {
+	if _, err := db.Exec(createSchemaVersionTable); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
+	return version, err
+}
+
+// PendingMigrations lists the migrations not yet applied to the database
+func PendingMigrations(db *sqlx.DB) ([]Migration, error) {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, mig := range migrations {
+		if mig.Version > version {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations in a single transaction.
+// Either every migration is applied or none of them.
+func Migrate(db *sqlx.DB) ([]Migration, error) {
+	pending, err := PendingMigrations(db)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	// Migrations rebuild tables, foreign keys are only checked again once they're done.
+	// The pragma has no effect inside a transaction, it's set on the connection instead.
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, mig := range pending {
+		for _, stmt := range mig.statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return nil, fmt.Errorf("migration %d (%s): %w", mig.Version, mig.Description, err)
+			}
+		}
+
+		_, err := tx.Exec("INSERT INTO schema_version(version, description, applied_at) VALUES (?, ?, ?)",
+			mig.Version, mig.Description, time.Now().UTC().Format(sqlTimeFormat))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
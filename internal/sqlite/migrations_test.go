@@ -0,0 +1,92 @@
+package sqlite_test
+
+import (
+	"testing"
+
+	"github.com/jfernstad/habitz/web/internal/sqlite"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDB(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", ":memory:?_foreign_keys=on")
+	assert.Nil(t, err)
+	db.SetMaxOpenConns(1) // Every connection gets a new in-memory database
+	return db
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	applied, err := sqlite.Migrate(db)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, applied)
+
+	pending, err := sqlite.PendingMigrations(db)
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+
+	// Nothing left to do the second time
+	applied, err = sqlite.Migrate(db)
+	assert.Nil(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestMigrateExistingDatabase(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	// Schema and data from before migrations existed
+	for _, stmt := range []string{
+		`CREATE TABLE users(id text PRIMARY KEY, firstname TEXT, lastname TEXT, email TEXT, profile_image TEXT)`,
+		`CREATE TABLE external_users(id text PRIMARY KEY, provider TEXT, user_id TEXT)`,
+		`CREATE TABLE habit_templates (user_id text, weekday TEXT, habit TEXT, PRIMARY KEY (user_id, weekday, habit)) WITHOUT ROWID`,
+		`CREATE TABLE habit_entries(id INTEGER PRIMARY KEY AUTOINCREMENT, user_id text, weekday TEXT, date TEXT, habit TEXT, complete INTEGER, complete_at TIMESTAMP)`,
+		`INSERT INTO users VALUES ('u1', 'Test', 'Testsson', 'test@example.com', '')`,
+		`INSERT INTO habit_templates VALUES ('u1', 'monday', 'Run'), ('u1', 'friday', 'run')`,
+		// Duplicates from concurrent requests, the completed entry is kept
+		`INSERT INTO habit_entries(user_id, weekday, date, habit, complete) VALUES
+			('u1', 'monday', '2021-03-01', 'Run', 0), ('u1', 'monday', '2021-03-01', 'Run', 1), ('u1', 'monday', '2021-03-01', 'Run', 0)`,
+	} {
+		_, err := db.Exec(stmt)
+		assert.Nil(t, err)
+	}
+
+	_, err := sqlite.Migrate(db)
+	assert.Nil(t, err)
+
+	var habits int
+	assert.Nil(t, db.Get(&habits, "SELECT COUNT(*) FROM habits"))
+	assert.Equal(t, 1, habits)
+
+	var templates int
+	assert.Nil(t, db.Get(&templates, "SELECT COUNT(*) FROM habit_templates WHERE habit_id = 1"))
+	assert.Equal(t, 2, templates)
+
+	entries := []struct {
+		ID      int `db:"id"`
+		HabitID int `db:"habit_id"`
+	}{}
+	assert.Nil(t, db.Select(&entries, "SELECT id, habit_id FROM habit_entries"))
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, 2, entries[0].ID)
+		assert.Equal(t, 1, entries[0].HabitID)
+	}
+}
+
+// Migrations turn foreign keys off while they run, they're enforced again afterwards
+func TestMigrateKeepsForeignKeys(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+
+	_, err := sqlite.Migrate(db)
+	assert.Nil(t, err)
+
+	var enabled bool
+	assert.Nil(t, db.Get(&enabled, "PRAGMA foreign_keys"))
+	assert.True(t, enabled)
+
+	_, err = db.Exec(`INSERT INTO habits(user_id, name, created_at) VALUES ('nobody', 'Run', '2021-03-01 00:00:00')`)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,104 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+func (m *habitzService) CreateSession(ctx context.Context, session *repository.Session) error {
+	insert, args, _ := sq.Insert("sessions").
+		Columns("id", "user_id", "refresh_token_hash", "created_at", "expires_at").
+		Values(session.ID, session.UserID, session.RefreshTokenHash,
+			session.CreatedAt.UTC().Format(sqlTimeFormat), session.ExpiresAt.UTC().Format(sqlTimeFormat)).
+		ToSql()
+
+	m.log(ctx, "CreateSession: "+insert+" >> "+session.UserID+", "+session.ID)
+
+	_, err := m.db.ExecContext(ctx, insert, args...)
+	return err
+}
+
+func (m *habitzService) Session(ctx context.Context, id string) (*repository.Session, error) {
+	return m.sessionWhere(ctx, sq.Eq{"id": id})
+}
+
+// SessionWithRefreshToken finds the session a refresh token was issued for
+func (m *habitzService) SessionWithRefreshToken(ctx context.Context, hash string) (*repository.Session, error) {
+	return m.sessionWhere(ctx, sq.Eq{"refresh_token_hash": hash})
+}
+
+func (m *habitzService) sessionWhere(ctx context.Context, where sq.Eq) (*repository.Session, error) {
+	sessionQuery, args, _ := build.Sessions().Where(where).ToSql()
+
+	m.log(ctx, "Session: "+sessionQuery)
+
+	session := repository.Session{}
+	if err := m.db.QueryRowxContext(ctx, sessionQuery, args...).StructScan(&session); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSession replaces the refresh token of a session, the old token can't be used again.
+// Returns ErrNotFound if the old token was already rotated, e.g. by a concurrent refresh.
+func (m *habitzService) RotateSession(ctx context.Context, id, oldHash, newHash string, expiresAt time.Time) error {
+	update, args, _ := sq.Update("sessions").
+		Set("refresh_token_hash", newHash).
+		Set("expires_at", expiresAt.UTC().Format(sqlTimeFormat)).
+		Where(sq.Eq{"id": id, "refresh_token_hash": oldHash, "revoked_at": nil}).
+		ToSql()
+
+	m.log(ctx, "RotateSession: "+update+" >> "+id)
+
+	res, err := m.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+	return nil
+}
+
+// RevokeSession signs out a single device
+func (m *habitzService) RevokeSession(ctx context.Context, userID, id string) error {
+	update, args, _ := sq.Update("sessions").
+		Set("revoked_at", time.Now().UTC().Format(sqlTimeFormat)).
+		Where(sq.Eq{"user_id": userID, "id": id, "revoked_at": nil}).
+		ToSql()
+
+	m.log(ctx, "RevokeSession: "+update+" >> "+userID+", "+id)
+
+	res, err := m.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return internal.ErrNotFound
+	}
+	return nil
+}
+
+// RevokeAllSessions signs out all devices of the user
+func (m *habitzService) RevokeAllSessions(ctx context.Context, userID string) error {
+	update, args, _ := sq.Update("sessions").
+		Set("revoked_at", time.Now().UTC().Format(sqlTimeFormat)).
+		Where(sq.Eq{"user_id": userID, "revoked_at": nil}).
+		ToSql()
+
+	m.log(ctx, "RevokeAllSessions: "+update+" >> "+userID)
+
+	_, err := m.db.ExecContext(ctx, update, args...)
+	return err
+}
@@ -0,0 +1,52 @@
+package sqlite_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/jfernstad/habitz/web/internal/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveUser(t *testing.T) {
+	db := newTestDB(t)
+	defer db.Close()
+	hs := sqlite.NewHabitzService(db, false)
+
+	user, err := hs.CreateExternalUser(context.Background(), &repository.ExternalUser{Provider: "google", ExternalID: "1234"})
+	assert.Nil(t, err)
+	assert.Nil(t, hs.CreateLocalCredentials(context.Background(), user.ID, "alice@example.com", "hash"))
+
+	habit, err := hs.CreateHabit(context.Background(), user.ID, &repository.Habit{Name: "Run", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+	assert.Nil(t, hs.CreateTemplate(context.Background(), user.ID, "monday", habit.ID))
+	_, err = hs.CreateHabitEntry(context.Background(), user.ID, "2021-03-01", "monday", habit.ID)
+	assert.Nil(t, err)
+
+	// Someone else's habitz stay
+	alice, err := hs.CreateExternalUser(context.Background(), &repository.ExternalUser{Provider: "google", ExternalID: "5678"})
+	assert.Nil(t, err)
+	aliceHabit, err := hs.CreateHabit(context.Background(), alice.ID, &repository.Habit{Name: "Run", Kind: repository.HabitKindCheck, Target: 1})
+	assert.Nil(t, err)
+	_, err = hs.CreateHabitEntry(context.Background(), alice.ID, "2021-03-01", "monday", aliceHabit.ID)
+	assert.Nil(t, err)
+
+	assert.Nil(t, hs.RemoveUser(context.Background(), user.ID))
+	assert.Equal(t, internal.ErrNotFound, hs.RemoveUser(context.Background(), user.ID))
+
+	stored, err := hs.User(context.Background(), user.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, stored)
+
+	for _, table := range []string{"habits", "habit_templates", "habit_entries", "external_users", "local_credentials"} {
+		var count int
+		assert.Nil(t, db.Get(&count, "SELECT COUNT(*) FROM "+table+" WHERE user_id = ?", user.ID))
+		assert.Equal(t, 0, count, table)
+	}
+
+	entries, err := hs.HabitEntries(context.Background(), alice.ID, "2021-03-01")
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
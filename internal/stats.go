@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+// statsPageSize is the number of entries loaded at a time for stats
+const statsPageSize = 1000
+
+// LoadHabitStats loads the habitz and entries of a user between `from` and `today`,
+// and calculates the stats of every habit
+func LoadHabitStats(ctx context.Context, hs HabitzServicer, userID, from, today string, loc *time.Location) ([]*repository.HabitStats, error) {
+	templates, err := hs.Templates(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	habits, err := hs.Habits(ctx, userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the whole window, page by page
+	entries := []*repository.HabitEntry{}
+	for offset := 0; ; offset += statsPageSize {
+		page, err := hs.HabitEntriesBetween(ctx, userID, from, today, statsPageSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+
+		if len(page) < statsPageSize {
+			break
+		}
+	}
+
+	return HabitStats(templates, habits, entries, from, today, loc)
+}
+
+// HabitStats calculates the stats of all habitz of a user between `from` and `today`,
+// in the same order as HabitStreaks. Habitz created within the window are counted from
+// the day they were created.
+func HabitStats(templates []*repository.WeekHabitTemplates, habits []*repository.Habit, entries []*repository.HabitEntry, from, today string, loc *time.Location) ([]*repository.HabitStats, error) {
+	u := orderHabits(templates, habits, entries)
+
+	stats := []*repository.HabitStats{}
+	for _, habitID := range u.order {
+		schedule, err := u.schedule(habitID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Days before the habit was created can't be missed
+		start := from
+		if habit, ok := u.byID[habitID]; ok {
+			if created := ShortDateIn(habit.CreatedAt, loc); created > start {
+				start = created
+			}
+		}
+
+		s := CalculateStats(u.names[habitID], schedule, u.entries[habitID], start, today, loc)
+		s.HabitID = habitID
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
+
+// CalculateStats aggregates the entries of a single habit between `from` and `today`.
+// Scheduled days are counted the same way as for streaks, every day from `from`, which
+// should be the later of the window start and the day the habit was created.
+// Today only counts as scheduled once it's completed. Quota habitz, e.g. 3 times a week,
+// get their completion rate from the periods that reached the quota.
+// The average completion time is the time of day in `loc`.
+func CalculateStats(habit string, schedule *Schedule, entries []*repository.HabitEntry, from, today string, loc *time.Location) *repository.HabitStats {
+	stats := &repository.HabitStats{
+		Habit: habit,
+	}
+
+	start, err := time.Parse(ShortDateFormat, from)
+	if err != nil {
+		return stats
+	}
+	end, err := time.Parse(ShortDateFormat, today)
+	if err != nil {
+		return stats
+	}
+
+	completed := map[string]bool{}
+	times := []time.Time{}
+
+	for _, e := range entries {
+		d, err := time.Parse(ShortDateFormat, e.Date)
+		if err != nil || d.Before(start) || d.After(end) {
+			continue
+		}
+		completed[e.Date] = completed[e.Date] || e.Complete
+
+		if e.Complete && e.CompleteAt != nil {
+			times = append(times, e.CompleteAt.In(loc))
+		}
+	}
+
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format(ShortDateFormat)
+		complete, hasEntry := completed[date]
+
+		if !hasEntry && !schedule.Scheduled(d) {
+			continue
+		}
+		if schedule.IsQuota() && !complete {
+			continue
+		}
+
+		if complete {
+			stats.CompletedDays++
+			stats.ScheduledDays++
+			continue
+		}
+
+		if date != today {
+			stats.ScheduledDays++
+		}
+	}
+
+	if stats.ScheduledDays > 0 {
+		stats.CompletionRate = float64(stats.CompletedDays) / float64(stats.ScheduledDays)
+	}
+
+	// Quota habitz have no missed days, only missed periods
+	if schedule.IsQuota() {
+		stats.Quota = quotaStats(schedule, completed, start, end)
+		stats.CompletionRate = 0
+		if stats.Quota.Periods > 0 {
+			stats.CompletionRate = float64(stats.Quota.CompletedPeriods) / float64(stats.Quota.Periods)
+		}
+	}
+
+	if len(times) > 0 {
+		avg := averageTimeOfDay(times)
+		stats.AverageCompleteTime = fmt.Sprintf("%02d:%02d", avg/3600, (avg%3600)/60)
+	}
+
+	return stats
+}
+
+// quotaStats counts the periods between `start` and `end` a quota habit was due, and how many of them
+// reached the quota. Like today, the current period and a period that began before `start` only
+// count once the quota is reached.
+func quotaStats(schedule *Schedule, completed map[string]bool, start, end time.Time) *repository.QuotaStats {
+	type period struct {
+		start     time.Time
+		due       bool
+		completed int
+	}
+
+	periods := []*period{}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		periodStart := schedule.PeriodStart(d)
+		if len(periods) == 0 || !periods[len(periods)-1].start.Equal(periodStart) {
+			periods = append(periods, &period{start: periodStart})
+		}
+
+		p := periods[len(periods)-1]
+		p.due = p.due || schedule.Due(d, 0)
+		if completed[d.Format(ShortDateFormat)] {
+			p.completed++
+		}
+	}
+
+	stats := &repository.QuotaStats{}
+	current := schedule.PeriodStart(end)
+	for _, p := range periods {
+		if !p.due {
+			continue
+		}
+
+		if schedule.QuotaReached(p.completed) {
+			stats.Periods++
+			stats.CompletedPeriods++
+			continue
+		}
+
+		if !p.start.Equal(current) && !p.start.Before(start) {
+			stats.Periods++
+		}
+	}
+	return stats
+}
+
+// averageTimeOfDay returns the mean time of day in seconds since midnight.
+// The times are averaged on a 24 hour clock, so 23:50 and 00:10 average to 00:00, not 12:00.
+func averageTimeOfDay(times []time.Time) int {
+	sin, cos := 0.0, 0.0
+	for _, t := range times {
+		seconds := t.Hour()*3600 + t.Minute()*60 + t.Second()
+		angle := 2 * math.Pi * float64(seconds) / secondsPerDay
+		sin += math.Sin(angle)
+		cos += math.Cos(angle)
+	}
+
+	angle := math.Atan2(sin, cos)
+	if angle < 0 {
+		angle += 2 * math.Pi
+	}
+
+	return int(math.Round(angle*secondsPerDay/(2*math.Pi))) % secondsPerDay
+}
@@ -0,0 +1,254 @@
+package internal_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func completedAt(date, clock string) *repository.HabitEntry {
+	at, _ := time.Parse("2006-01-02 15:04", date+" "+clock)
+	return &repository.HabitEntry{Date: date, Complete: true, CompleteAt: &at}
+}
+
+func TestStats(t *testing.T) {
+	// 2021-03-01 is a monday
+	daily := internal.WeekdaySchedule([]string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"})
+	quota, err := internal.HabitSchedule(&repository.Habit{Recurrence: "FREQ=WEEKLY;X-TIMES=3", RecurrenceStart: "2021-03-01"}, nil)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name      string
+		schedule  *internal.Schedule
+		entries   []*repository.HabitEntry
+		from      string
+		today     string
+		scheduled int
+		completed int
+		rate      float64
+		quota     *repository.QuotaStats
+	}{
+		{
+			name:      "no entries",
+			schedule:  daily,
+			from:      "2021-03-01",
+			today:     "2021-03-07",
+			scheduled: 6,
+		},
+		{
+			name:      "missed days before the first entry count",
+			schedule:  daily,
+			entries:   []*repository.HabitEntry{entry("2021-03-05", true), entry("2021-03-06", true)},
+			from:      "2021-03-01",
+			today:     "2021-03-07",
+			scheduled: 6,
+			completed: 2,
+			rate:      1.0 / 3,
+		},
+		{
+			name:      "entries outside the window are ignored",
+			schedule:  daily,
+			entries:   []*repository.HabitEntry{entry("2021-02-28", true), entry("2021-03-06", true), entry("2021-03-08", true)},
+			from:      "2021-03-06",
+			today:     "2021-03-07",
+			scheduled: 1,
+			completed: 1,
+			rate:      1,
+		},
+		{
+			name:      "unscheduled days only count with an entry",
+			schedule:  internal.WeekdaySchedule([]string{"monday", "wednesday", "friday"}),
+			entries:   []*repository.HabitEntry{entry("2021-03-01", true), entry("2021-03-06", true)},
+			from:      "2021-03-01",
+			today:     "2021-03-07",
+			scheduled: 4,
+			completed: 2,
+			rate:      0.5,
+		},
+		{
+			name:      "today counts once completed",
+			schedule:  daily,
+			entries:   []*repository.HabitEntry{entry("2021-03-06", true), entry("2021-03-07", true)},
+			from:      "2021-03-06",
+			today:     "2021-03-07",
+			scheduled: 2,
+			completed: 2,
+			rate:      1,
+		},
+		{
+			name:      "today doesn't count until completed",
+			schedule:  daily,
+			entries:   []*repository.HabitEntry{entry("2021-03-06", true), entry("2021-03-07", false)},
+			from:      "2021-03-06",
+			today:     "2021-03-07",
+			scheduled: 1,
+			completed: 1,
+			rate:      1,
+		},
+		{
+			name:      "quota of the current period isn't reached yet",
+			schedule:  quota,
+			entries:   []*repository.HabitEntry{entry("2021-03-02", true), entry("2021-03-03", false), entry("2021-03-04", true)},
+			from:      "2021-03-01",
+			today:     "2021-03-07",
+			scheduled: 2,
+			completed: 2,
+			quota:     &repository.QuotaStats{},
+		},
+		{
+			name:      "quota of the current period is reached",
+			schedule:  quota,
+			entries:   []*repository.HabitEntry{entry("2021-03-02", true), entry("2021-03-04", true), entry("2021-03-05", true)},
+			from:      "2021-03-01",
+			today:     "2021-03-07",
+			scheduled: 3,
+			completed: 3,
+			rate:      1,
+			quota:     &repository.QuotaStats{Periods: 1, CompletedPeriods: 1},
+		},
+		{
+			name:      "missed quota periods",
+			schedule:  quota,
+			entries:   []*repository.HabitEntry{entry("2021-03-01", true), entry("2021-03-02", true), entry("2021-03-03", true), entry("2021-03-09", true)},
+			from:      "2021-03-01",
+			today:     "2021-03-17",
+			scheduled: 4,
+			completed: 4,
+			rate:      0.5,
+			quota:     &repository.QuotaStats{Periods: 2, CompletedPeriods: 1},
+		},
+		{
+			name:      "quota period that began before the window",
+			schedule:  quota,
+			entries:   []*repository.HabitEntry{entry("2021-03-01", true), entry("2021-03-02", true), entry("2021-03-03", true), entry("2021-03-09", true)},
+			from:      "2021-03-03",
+			today:     "2021-03-17",
+			scheduled: 2,
+			completed: 2,
+			quota:     &repository.QuotaStats{Periods: 1},
+		},
+		{
+			name:     "quota habitz without completions",
+			schedule: quota,
+			entries:  []*repository.HabitEntry{entry("2021-03-03", false)},
+			from:     "2021-03-01",
+			today:    "2021-03-07",
+			quota:    &repository.QuotaStats{},
+		},
+	}
+
+	for _, c := range cases {
+		stats := internal.CalculateStats("Run", c.schedule, c.entries, c.from, c.today, time.UTC)
+		assert.Equal(t, "Run", stats.Habit, c.name)
+		assert.Equal(t, c.scheduled, stats.ScheduledDays, c.name)
+		assert.Equal(t, c.completed, stats.CompletedDays, c.name)
+		assert.InDelta(t, c.rate, stats.CompletionRate, 0.0001, c.name)
+		assert.Equal(t, c.quota, stats.Quota, c.name)
+	}
+}
+
+func TestStatsAverageCompleteTime(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name    string
+		entries []*repository.HabitEntry
+		loc     *time.Location
+		average string
+	}{
+		{
+			name:    "no completion times",
+			entries: []*repository.HabitEntry{entry("2021-03-01", true)},
+			loc:     time.UTC,
+		},
+		{
+			name:    "same time",
+			entries: []*repository.HabitEntry{completedAt("2021-03-01", "07:30"), completedAt("2021-03-02", "07:30")},
+			loc:     time.UTC,
+			average: "07:30",
+		},
+		{
+			name:    "mean of the times",
+			entries: []*repository.HabitEntry{completedAt("2021-03-01", "07:00"), completedAt("2021-03-02", "09:00")},
+			loc:     time.UTC,
+			average: "08:00",
+		},
+		{
+			name:    "around midnight",
+			entries: []*repository.HabitEntry{completedAt("2021-03-01", "23:50"), completedAt("2021-03-02", "00:10")},
+			loc:     time.UTC,
+			average: "00:00",
+		},
+		{
+			name:    "before midnight",
+			entries: []*repository.HabitEntry{completedAt("2021-03-01", "23:30"), completedAt("2021-03-02", "23:50"), completedAt("2021-03-03", "00:10")},
+			loc:     time.UTC,
+			average: "23:50",
+		},
+		{
+			name:    "incomplete entries are ignored",
+			entries: []*repository.HabitEntry{completedAt("2021-03-01", "07:00"), {Date: "2021-03-02", CompleteAt: completedAt("2021-03-02", "12:00").CompleteAt}},
+			loc:     time.UTC,
+			average: "07:00",
+		},
+		{
+			name:    "time of day in the user's timezone",
+			entries: []*repository.HabitEntry{completedAt("2021-03-01", "22:30")},
+			loc:     stockholm,
+			average: "23:30",
+		},
+	}
+
+	for _, c := range cases {
+		stats := internal.CalculateStats("Run", internal.WeekdaySchedule(nil), c.entries, "2021-03-01", "2021-03-07", c.loc)
+		assert.Equal(t, c.average, stats.AverageCompleteTime, c.name)
+	}
+}
+
+func TestHabitStats(t *testing.T) {
+	day := func(date string) time.Time {
+		d, _ := time.Parse("2006-01-02", date)
+		return d
+	}
+	archived := day("2021-02-15")
+
+	templates := []*repository.WeekHabitTemplates{
+		{HabitID: 1, Habit: "Run", Weekdays: []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}},
+	}
+	habits := []*repository.Habit{
+		{ID: 3, Name: "Swim", CreatedAt: day("2021-01-01"), ArchivedAt: &archived},
+		{ID: 2, Name: "Read", CreatedAt: day("2021-03-05"), Recurrence: "FREQ=DAILY"},
+		{ID: 1, Name: "Run", CreatedAt: day("2021-02-01")},
+	}
+	entries := []*repository.HabitEntry{
+		{HabitID: 3, Habit: "Swim", Date: "2021-03-02", Complete: true},
+		{HabitID: 2, Habit: "Read", Date: "2021-03-06", Complete: true},
+	}
+
+	stats, err := internal.HabitStats(templates, habits, entries, "2021-03-01", "2021-03-07", time.UTC)
+	assert.Nil(t, err)
+
+	// Templates first, then recurrence rules, then habitz only found in the entries
+	if assert.Len(t, stats, 3) {
+		assert.Equal(t, 1, stats[0].HabitID)
+		assert.Equal(t, "Run", stats[0].Habit)
+		assert.Equal(t, 6, stats[0].ScheduledDays)
+		assert.Equal(t, 0, stats[0].CompletedDays)
+
+		// Created within the window
+		assert.Equal(t, 2, stats[1].HabitID)
+		assert.Equal(t, 2, stats[1].ScheduledDays)
+		assert.Equal(t, 1, stats[1].CompletedDays)
+
+		assert.Equal(t, 3, stats[2].HabitID)
+		assert.Equal(t, 1, stats[2].ScheduledDays)
+		assert.Equal(t, 1, stats[2].CompletedDays)
+	}
+
+	_, err = internal.HabitStats(nil, []*repository.Habit{{ID: 4, Name: "Walk", Recurrence: "FREQ=HOURLY"}}, nil, "2021-03-01", "2021-03-07", time.UTC)
+	assert.NotNil(t, err)
+}
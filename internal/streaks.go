@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jfernstad/habitz/web/internal/repository"
+)
+
+// CalculateStreak walks every day from the first entry of a habit until today.
+// Completed entries extend the streak, entries that weren't completed break it.
+// Days without an entry are ignored, the scheduler creates an entry for every scheduled day.
+// Checking days against the schedule instead would let a changed schedule break old streaks.
+// Today never breaks a streak, the day isn't over yet.
+// Quota habitz, e.g. 3 times a week, only count their completed days.
+func CalculateStreak(schedule *Schedule, entries []*repository.HabitEntry, today string) (current int, longest int) {
+	if len(entries) == 0 {
+		return 0, 0
+	}
+
+	end, err := time.Parse(ShortDateFormat, today)
+	if err != nil {
+		return 0, 0
+	}
+
+	start := end
+	completed := map[string]bool{}
+	for _, e := range entries {
+		d, err := time.Parse(ShortDateFormat, e.Date)
+		if err != nil {
+			continue
+		}
+		if d.Before(start) {
+			start = d
+		}
+		// Multiple entries for the same day, any completed one counts
+		completed[e.Date] = completed[e.Date] || e.Complete
+	}
+
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format(ShortDateFormat)
+		complete, hasEntry := completed[date]
+
+		if !hasEntry {
+			continue
+		}
+		if schedule.IsQuota() && !complete {
+			continue
+		}
+
+		if complete {
+			current++
+			if current > longest {
+				longest = current
+			}
+			continue
+		}
+
+		if date != today {
+			current = 0
+		}
+	}
+
+	return current, longest
+}
+
+func weekdaySet(weekdays []string) map[string]bool {
+	set := map[string]bool{}
+	for _, day := range weekdays {
+		set[strings.ToLower(day)] = true
+	}
+	return set
+}
+
+// userHabits orders the habitz of a user like the schedule: habitz with templates first,
+// then habitz with a recurrence rule, habitz only found in the entries go last.
+type userHabits struct {
+	order    []int
+	names    map[int]string
+	weekdays map[int][]string
+	byID     map[int]*repository.Habit
+	entries  map[int][]*repository.HabitEntry
+}
+
+func orderHabits(templates []*repository.WeekHabitTemplates, habits []*repository.Habit, entries []*repository.HabitEntry) *userHabits {
+	u := &userHabits{
+		order:    []int{},
+		names:    map[int]string{},
+		weekdays: map[int][]string{},
+		byID:     map[int]*repository.Habit{},
+		entries:  map[int][]*repository.HabitEntry{},
+	}
+
+	for _, t := range templates {
+		u.order = append(u.order, t.HabitID)
+		u.names[t.HabitID] = t.Habit
+		u.weekdays[t.HabitID] = t.Weekdays
+	}
+
+	for _, habit := range habits {
+		u.byID[habit.ID] = habit
+
+		// Habitz with a recurrence rule have no templates
+		if _, ok := u.names[habit.ID]; !ok && habit.Recurrence != "" && habit.ArchivedAt == nil {
+			u.order = append(u.order, habit.ID)
+			u.names[habit.ID] = habit.Name
+		}
+	}
+
+	for _, entry := range entries {
+		if _, ok := u.names[entry.HabitID]; !ok {
+			u.order = append(u.order, entry.HabitID)
+			u.names[entry.HabitID] = entry.Habit
+		}
+		u.entries[entry.HabitID] = append(u.entries[entry.HabitID], entry)
+	}
+
+	return u
+}
+
+func (u *userHabits) schedule(habitID int) (*Schedule, error) {
+	return HabitSchedule(u.byID[habitID], u.weekdays[habitID])
+}
+
+// LoadHabitStreaks loads the habitz and entries of a user up to `today`,
+// and calculates the streaks of every habit
+func LoadHabitStreaks(ctx context.Context, hs HabitzServicer, userID, today string) ([]*repository.HabitStreak, error) {
+	templates, err := hs.Templates(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	habits, err := hs.Habits(ctx, userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*repository.HabitEntry{}
+	err = hs.EachHabitEntry(ctx, userID, func(e *repository.HabitEntry) error {
+		if e.Date <= today {
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return HabitStreaks(templates, habits, entries, today)
+}
+
+// HabitStreaks calculates the streaks of all habitz of a user, in the order of the templates.
+// Habitz with a recurrence rule follow, habitz only found in the entries go last.
+// The entries must be ordered by date.
+func HabitStreaks(templates []*repository.WeekHabitTemplates, habits []*repository.Habit, entries []*repository.HabitEntry, today string) ([]*repository.HabitStreak, error) {
+	u := orderHabits(templates, habits, entries)
+
+	streaks := []*repository.HabitStreak{}
+	for _, habitID := range u.order {
+		schedule, err := u.schedule(habitID)
+		if err != nil {
+			return nil, err
+		}
+
+		current, longest := CalculateStreak(schedule, u.entries[habitID], today)
+		streaks = append(streaks, &repository.HabitStreak{
+			HabitID: habitID,
+			Habit:   u.names[habitID],
+			Current: current,
+			Longest: longest,
+		})
+	}
+
+	return streaks, nil
+}
@@ -0,0 +1,127 @@
+package internal_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jfernstad/habitz/web/internal"
+	"github.com/jfernstad/habitz/web/internal/mock"
+	"github.com/jfernstad/habitz/web/internal/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func entry(date string, complete bool) *repository.HabitEntry {
+	return &repository.HabitEntry{Date: date, Complete: complete}
+}
+
+func TestStreakNoEntries(t *testing.T) {
+	current, longest := internal.CalculateStreak(internal.WeekdaySchedule([]string{"monday"}), nil, "2021-03-01")
+	assert.Equal(t, 0, current)
+	assert.Equal(t, 0, longest)
+}
+
+func TestStreakUnscheduledDaysDontBreak(t *testing.T) {
+	// 2021-03-01 is a monday
+	weekdays := []string{"monday", "wednesday", "friday"}
+	entries := []*repository.HabitEntry{
+		entry("2021-03-01", true),
+		entry("2021-03-03", true),
+		entry("2021-03-05", true),
+		entry("2021-03-08", true),
+	}
+
+	current, longest := internal.CalculateStreak(internal.WeekdaySchedule(weekdays), entries, "2021-03-09")
+	assert.Equal(t, 4, current)
+	assert.Equal(t, 4, longest)
+}
+
+func TestStreakMissedDayBreaks(t *testing.T) {
+	weekdays := []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+	entries := []*repository.HabitEntry{
+		entry("2021-03-01", true),
+		entry("2021-03-02", true),
+		entry("2021-03-03", true),
+		entry("2021-03-04", false),
+		entry("2021-03-05", true),
+		entry("2021-03-06", false),
+		entry("2021-03-07", true),
+	}
+
+	current, longest := internal.CalculateStreak(internal.WeekdaySchedule(weekdays), entries, "2021-03-07")
+	assert.Equal(t, 1, current)
+	assert.Equal(t, 3, longest)
+}
+
+func TestStreakTodayNotBroken(t *testing.T) {
+	weekdays := []string{"monday", "tuesday"}
+	entries := []*repository.HabitEntry{
+		entry("2021-03-01", true),
+		entry("2021-03-02", false),
+	}
+
+	current, longest := internal.CalculateStreak(internal.WeekdaySchedule(weekdays), entries, "2021-03-02")
+	assert.Equal(t, 1, current)
+	assert.Equal(t, 1, longest)
+}
+
+// Moving a habit from monday to tuesday doesn't turn old tuesdays into missed days
+func TestStreakScheduleChanged(t *testing.T) {
+	entries := []*repository.HabitEntry{
+		entry("2021-03-01", true),
+		entry("2021-03-08", true),
+		entry("2021-03-16", true),
+	}
+
+	current, longest := internal.CalculateStreak(internal.WeekdaySchedule([]string{"tuesday"}), entries, "2021-03-16")
+	assert.Equal(t, 3, current)
+	assert.Equal(t, 3, longest)
+}
+
+func TestStreakScheduledDayWithoutEntry(t *testing.T) {
+	weekdays := []string{"monday", "tuesday", "wednesday"}
+	entries := []*repository.HabitEntry{
+		entry("2021-03-01", true),
+		// 2021-03-02 is scheduled, but was never materialized
+		entry("2021-03-03", true),
+	}
+
+	current, longest := internal.CalculateStreak(internal.WeekdaySchedule(weekdays), entries, "2021-03-03")
+	assert.Equal(t, 2, current)
+	assert.Equal(t, 2, longest)
+}
+
+func TestLoadHabitStreaks(t *testing.T) {
+	ctx := context.Background()
+	hs := mock.NewHabitzService()
+
+	user, err := hs.CreateLocalUser(ctx, &repository.User{Email: "streaks@example.com", Timezone: "UTC"}, "")
+	assert.Nil(t, err)
+
+	_, err = hs.Import(ctx, user.ID, &repository.HabitImport{
+		Habits: []*repository.ImportedHabit{
+			{Habit: repository.Habit{Name: "Run", Kind: repository.HabitKindCheck, Target: 1}, Weekdays: []string{"monday"}},
+			{Habit: repository.Habit{Name: "Read", Kind: repository.HabitKindCheck, Target: 1, Recurrence: "FREQ=DAILY"}},
+		},
+		Entries: []*repository.ImportedEntry{
+			{Habit: "Run", Date: "2021-03-01", Complete: true},
+			{Habit: "Run", Date: "2021-03-08", Complete: true},
+			{Habit: "Run", Date: "2021-03-15", Complete: true},
+			{Habit: "Run", Date: "2021-03-22", Complete: false}, // After today
+			{Habit: "Read", Date: "2021-03-15", Complete: false},
+			{Habit: "Read", Date: "2021-03-16", Complete: true},
+		},
+	}, false)
+	assert.Nil(t, err)
+
+	streaks, err := internal.LoadHabitStreaks(ctx, hs, user.ID, "2021-03-16")
+	assert.Nil(t, err)
+	if assert.Len(t, streaks, 2) {
+		assert.Equal(t, "Run", streaks[0].Habit)
+		assert.Equal(t, 3, streaks[0].Current)
+		assert.Equal(t, 3, streaks[0].Longest)
+
+		assert.Equal(t, "Read", streaks[1].Habit)
+		assert.Equal(t, 1, streaks[1].Current)
+		assert.Equal(t, 1, streaks[1].Longest)
+	}
+}